@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/theag3nt/duckdns/pkg/duckdns"
+)
+
+// runACMEHook sets or removes the TXT record certbot asks for, so the binary
+// can serve as both its --manual-auth-hook and --manual-cleanup-hook. certbot
+// only sets CERTBOT_AUTH_OUTPUT for the cleanup hook.
+func runACMEHook(ctx context.Context, update Update) {
+	domain := os.Getenv("CERTBOT_DOMAIN")
+	if domain == "" {
+		fatal(exitConfig, nil, "--acme-hook needs CERTBOT_DOMAIN, it is meant to be run by certbot")
+	}
+	name, err := acmeName(domain)
+	if err != nil {
+		fatal(exitConfig, err, "unable to run the ACME hook")
+	}
+	p, err := acmeProvider(update, name)
+	if err != nil {
+		fatal(exitConfig, err, "unable to run the ACME hook")
+	}
+
+	defer lock(update).Close()
+	if _, cleanup := os.LookupEnv("CERTBOT_AUTH_OUTPUT"); cleanup {
+		if err := clearACMEChallenge(ctx, p, name); err != nil {
+			fatal(exitCode(err), err, "error clearing TXT record")
+		}
+		return
+	}
+
+	validation := os.Getenv("CERTBOT_VALIDATION")
+	if validation == "" {
+		fatal(exitConfig, nil, "--acme-hook needs CERTBOT_VALIDATION, it is meant to be run by certbot")
+	}
+	if err := setACMEChallenge(ctx, update, p, name, validation); err != nil {
+		fatal(exitCode(err), err, "error setting TXT record")
+	}
+}
+
+// acmeName returns the DuckDNS name a certbot domain falls under: both
+// example.duckdns.org and *.example.duckdns.org give example
+func acmeName(domain string) (string, error) {
+	fqdn := strings.ToLower(strings.TrimSuffix(domain, "."))
+	rest := strings.TrimSuffix(fqdn, duckDNSZone)
+	if rest == fqdn || rest == "" {
+		return "", fmt.Errorf("%s is not a duckdns.org name", domain)
+	}
+	labels := strings.Split(rest, ".")
+	return labels[len(labels)-1], nil
+}
+
+// acmeProvider returns the DuckDNS provider for the account owning name. The
+// token covers every name of an account, so names that aren't configured use
+// the first DuckDNS account.
+func acmeProvider(update Update, name string) (*duckdnsDNSProvider, error) {
+	var account *Account
+	for _, a := range update.accounts() {
+		if providerName(a) != "duckdns" {
+			continue
+		}
+		a := a
+		if account == nil {
+			account = &a
+		}
+		for _, n := range a.Names {
+			if n == name {
+				account = &a
+			}
+		}
+	}
+	if account == nil {
+		account = &Account{Token: update.Token}
+	}
+	if account.Token == "" {
+		account.Token = keyringToken()
+	}
+	if account.Token == "" {
+		return nil, fmt.Errorf("no DuckDNS token is configured for %s", name)
+	}
+
+	client, err := newClient(update)
+	if err != nil {
+		return nil, err
+	}
+	p, err := newDNSProvider(*account, client)
+	if err != nil {
+		return nil, err
+	}
+	return p.(*duckdnsDNSProvider), nil
+}
+
+// setACMEChallenge sets the TXT record of name and waits until the resolver
+// used for --verify sees it, since certbot asks the CA to check right after
+// the hook returns
+func setACMEChallenge(ctx context.Context, update Update, p *duckdnsDNSProvider, name, validation string) error {
+	res, err := p.UpdateTXT(ctx, name, validation)
+	if err != nil {
+		return acmeError(name, err)
+	}
+	logrus.WithFields(resultFields(res)).Infof("TXT record set for %s", name)
+
+	resolver, err := newResolver(update)
+	if err != nil {
+		return err
+	}
+	err = verifyResult(ctx, resolver, duckdns.Result{Domain: name, TXT: validation},
+		update.VerifyTimeout, update.VerifyInterval)
+	if err != nil {
+		e := &updateError{names: 1, verify: 1}
+		e.fail(name, fmt.Errorf("TXT record of %s did not propagate: %v", fqdn(name), err))
+		return e
+	}
+	logrus.Infof("TXT record of %s has propagated", fqdn(name))
+	return nil
+}
+
+// clearACMEChallenge removes the TXT record of name once certbot is done
+func clearACMEChallenge(ctx context.Context, p *duckdnsDNSProvider, name string) error {
+	res, err := p.ClearTXT(ctx, name)
+	if err != nil {
+		return acmeError(name, err)
+	}
+	logrus.WithFields(resultFields(res)).Infof("TXT record cleared for %s", name)
+	return nil
+}
+
+// acmeError classes a failed request like a failed update, so the hook exits
+// with the same codes
+func acmeError(name string, err error) error {
+	e := &updateError{names: 1}
+	if isRejected(err) {
+		e.ko++
+	} else {
+		e.network++
+	}
+	e.fail(name, err)
+	return e
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// maxTriggerBody bounds the body of POST /update
+const maxTriggerBody = 4096
+
+// updateTrigger asks the daemon for a run right away, with the addresses to
+// send instead of detecting them when set
+type updateTrigger struct {
+	ip, ipv6 string
+	// from says who asked, for the log
+	from string
+	done chan triggerResult
+}
+
+type triggerResult struct {
+	Results []Result `json:"results"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// handleUpdate is POST /update. It needs the API token as a bearer token,
+// takes an optional JSON body like {"ip": "203.0.113.5"}, and answers with the
+// results of the run once it is over. Without an API token it doesn't exist,
+// which a reload can change.
+func (s *daemonState) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	token := s.apiToken
+	s.mu.Unlock()
+	if token == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !bearerAuthorized(w, r, token) {
+		return
+	}
+
+	t := updateTrigger{from: "the API", done: make(chan triggerResult, 1)}
+	var err error
+	if t.ip, t.ipv6, err = readTriggerBody(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case s.triggers <- t:
+	case <-r.Context().Done():
+		return
+	}
+	var res triggerResult
+	select {
+	case res = <-t.done:
+	case <-r.Context().Done():
+		return
+	}
+	writeTriggerResult(w, res)
+}
+
+// bearerAuthorized checks that r has token as its bearer token, answering 401
+// otherwise
+func bearerAuthorized(w http.ResponseWriter, r *http.Request, token string) bool {
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="duckdns"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// triggerAddrs are the addresses a request for an update can give, to send
+// instead of detecting them
+type triggerAddrs struct {
+	IP   string `json:"ip"`
+	IPv6 string `json:"ipv6"`
+}
+
+// check makes sure each address given is of its family
+func (a triggerAddrs) check() error {
+	if ip := net.ParseIP(a.IP); a.IP != "" && (ip == nil || ip.To4() == nil) {
+		return fmt.Errorf("%q is not an IPv4 address", a.IP)
+	}
+	if ip := net.ParseIP(a.IPv6); a.IPv6 != "" && (ip == nil || ip.To4() != nil) {
+		return fmt.Errorf("%q is not an IPv6 address", a.IPv6)
+	}
+	return nil
+}
+
+// readTriggerBody reads the addresses of an optional JSON body like
+// {"ip": "203.0.113.5"}
+func readTriggerBody(r *http.Request) (ip, ipv6 string, err error) {
+	var body triggerAddrs
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxTriggerBody))
+	if err == nil && strings.TrimSpace(string(data)) != "" {
+		err = json.Unmarshal(data, &body)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("invalid body: %v", err)
+	}
+	if err := body.check(); err != nil {
+		return "", "", err
+	}
+	return body.IP, body.IPv6, nil
+}
+
+// writeTriggerResult answers with the results of a requested run, as a 502
+// when it failed
+func writeTriggerResult(w http.ResponseWriter, res triggerResult) {
+	if res.Results == nil {
+		res.Results = []Result{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if res.Error != "" {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(res)
+}
@@ -0,0 +1,27 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// bindInterface binds every socket of the dialer to the device, so the
+// routing table of that interface is used whatever the source address
+func bindInterface(d *net.Dialer, ifi *net.Interface) error {
+	d.Control = func(network, address string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, ifi.Name)
+		}); cerr != nil {
+			return cerr
+		}
+		if err != nil {
+			return &net.OpError{Op: "bind", Net: network, Err: err}
+		}
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// bindInterface uses an address of the interface as the source address,
+// since only Linux can bind a socket to the device itself. A source_ip that
+// is set already wins.
+func bindInterface(d *net.Dialer, ifi *net.Interface) error {
+	if d.LocalAddr != nil {
+		return nil
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return err
+	}
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.IsGlobalUnicast() {
+			d.LocalAddr = &net.TCPAddr{IP: ipnet.IP}
+			return nil
+		}
+	}
+	return fmt.Errorf("bind_interface: %s has no address to send from", ifi.Name)
+}
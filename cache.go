@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ipCache is the state file shared by one-shot runs, the daemon, status and
+// healthcheck. It remembers the last address successfully sent for each name,
+// so that runs with a detected address can skip names that haven't changed,
+// and how each name's latest updates went.
+type ipCache struct {
+	path    string
+	Domains map[string]cachedIP `json:"domains"`
+
+	// LastRun and LastError describe the latest run, for healthcheck
+	LastRun   time.Time `json:"last_run"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+type cachedIP struct {
+	IP   string    `json:"ip"`
+	IPv6 string    `json:"ipv6,omitempty"`
+	Sent time.Time `json:"sent"`
+	// LastSuccess is when the name was last updated or found unchanged
+	LastSuccess time.Time `json:"last_success"`
+	// LastError is the error of the latest run, if it failed
+	LastError string `json:"last_error,omitempty"`
+	// Failures counts the runs in a row that failed to update the name
+	Failures int `json:"failures,omitempty"`
+}
+
+// memoryCaches keeps the caches that couldn't be written, by path, so that a
+// daemon on a read-only filesystem still remembers what it sent
+var memoryCaches = struct {
+	sync.Mutex
+	data map[string][]byte
+}{data: map[string][]byte{}}
+
+// legacyCacheFile is where the cache lived before the data dir, still used
+// while it is there and the data dir has none
+func legacyCacheFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "duckdns", "cache.json")
+}
+
+// loadCache reads the cache at path. A missing file gives an empty cache.
+func loadCache(path string) (*ipCache, error) {
+	c := &ipCache{path: path, Domains: map[string]cachedIP{}}
+	if path == "" {
+		return c, nil
+	}
+
+	memoryCaches.Lock()
+	data, inMemory := memoryCaches.data[path]
+	memoryCaches.Unlock()
+	var err error
+	if !inMemory {
+		data, err = ioutil.ReadFile(path)
+	}
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return c, err
+	}
+	if c.Domains == nil {
+		c.Domains = map[string]cachedIP{}
+	}
+	return c, nil
+}
+
+// unchanged reports whether ip is what was last sent for name, within maxAge
+// so that records still get refreshed now and then
+func (c *ipCache) unchanged(name, ip, ipv6 string, maxAge time.Duration) bool {
+	last, ok := c.Domains[name]
+	return ok && ip != "" && last.IP == ip && (ipv6 == "" || last.IPv6 == ipv6) &&
+		time.Since(last.Sent) < maxAge
+}
+
+func (c *ipCache) set(name, ip, ipv6 string) {
+	now := time.Now()
+	c.Domains[name] = cachedIP{IP: ip, IPv6: ipv6, Sent: now, LastSuccess: now}
+}
+
+// succeeded records a successful run for name that sent nothing new
+func (c *ipCache) succeeded(name string) {
+	last := c.Domains[name]
+	last.LastSuccess = time.Now()
+	last.LastError = ""
+	last.Failures = 0
+	c.Domains[name] = last
+}
+
+// failed counts another failed run for name, keeping the last address sent
+func (c *ipCache) failed(name, msg string) {
+	last := c.Domains[name]
+	last.LastError = msg
+	last.Failures++
+	c.Domains[name] = last
+}
+
+// finished records the outcome of a run
+func (c *ipCache) finished(err error) {
+	c.LastRun = time.Now()
+	c.LastError = ""
+	if err != nil {
+		c.LastError = redact(err.Error())
+	}
+}
+
+// save writes the cache through a temporary file so that a crash can't leave
+// it half written. Where it can't be written at all, it is kept in memory for
+// the rest of the process instead.
+func (c *ipCache) save() error {
+	if c.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = c.write(data)
+	if err != nil && warnReadOnly(c.path, err, "keeping the state in memory") {
+		memoryCaches.Lock()
+		memoryCaches.data[c.path] = data
+		memoryCaches.Unlock()
+		return nil
+	}
+	return err
+}
+
+func (c *ipCache) write(data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
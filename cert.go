@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme"
+)
+
+// CertConfig is where the cert subcommand gets its certificate from and where
+// it writes it
+type CertConfig struct {
+	// Email is the contact of the ACME account, told about expiring
+	// certificates
+	Email string `yaml:"email"`
+	// Directory is the ACME directory URL, Let's Encrypt by default
+	Directory string `yaml:"directory"`
+	// AccountKey is the ACME account key, created when missing. It defaults to
+	// acme-account.key in the user config dir.
+	AccountKey string `yaml:"account_key"`
+	// CertFile receives the certificate chain and KeyFile its private key,
+	// both PEM encoded
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// RenewBefore is how long before it expires the certificate is renewed
+	RenewBefore time.Duration `yaml:"renew_before"`
+}
+
+const (
+	letsEncryptStaging = "https://acme-staging-v02.api.letsencrypt.org/directory"
+	defaultRenewBefore = 30 * 24 * time.Hour
+)
+
+// merge fills the settings left unset in c from o
+func (c *CertConfig) merge(o CertConfig) {
+	if c.Email == "" {
+		c.Email = o.Email
+	}
+	if c.Directory == "" {
+		c.Directory = o.Directory
+	}
+	if c.AccountKey == "" {
+		c.AccountKey = o.AccountKey
+	}
+	if c.CertFile == "" {
+		c.CertFile = o.CertFile
+	}
+	if c.KeyFile == "" {
+		c.KeyFile = o.KeyFile
+	}
+	if c.RenewBefore == 0 {
+		c.RenewBefore = o.RenewBefore
+	}
+}
+
+func (c *CertConfig) setDefaults() {
+	if c.Directory == "" {
+		c.Directory = acme.LetsEncryptURL
+	}
+	if c.AccountKey == "" {
+		if dir, err := os.UserConfigDir(); err == nil {
+			c.AccountKey = filepath.Join(dir, "duckdns", "acme-account.key")
+		}
+	}
+	if c.RenewBefore == 0 {
+		c.RenewBefore = defaultRenewBefore
+	}
+}
+
+// runCert obtains a certificate for every configured DuckDNS name through
+// DNS-01 validation, unless the one in CertFile covers them and isn't due for
+// renewal yet. Running it daily from cron or a timer keeps it renewed.
+func runCert(ctx context.Context, update Update, c CertConfig, force bool) {
+	c.merge(update.Cert)
+	c.setDefaults()
+	if c.CertFile == "" || c.KeyFile == "" {
+		fatal(exitConfig, nil, "the cert command needs cert_file and key_file, or --cert-file and --key-file")
+	}
+
+	names := certNames(update)
+	if len(names) == 0 {
+		fatal(exitConfig, nil, "no DuckDNS names configured to get a certificate for")
+	}
+	if !force {
+		due, err := renewalDue(c.CertFile, names, c.RenewBefore)
+		if err != nil {
+			logrus.WithError(err).Warnf("unable to read %s, getting a new certificate", c.CertFile)
+		} else if !due {
+			logrus.Infof("%s is not due for renewal", c.CertFile)
+			return
+		}
+	}
+
+	defer lock(update).Close()
+	if err := obtainCert(ctx, update, c, names); err != nil {
+		fatal(exitCode(err), err, "error getting a certificate")
+	}
+	logrus.Infof("certificate for %s written to %s", strings.Join(names, ", "), c.CertFile)
+}
+
+// certNames returns the host names of every DuckDNS name, sorted
+func certNames(update Update) []string {
+	var names []string
+	for _, a := range update.accounts() {
+		if providerName(a) != "duckdns" {
+			continue
+		}
+		for _, n := range a.Names {
+			names = append(names, fqdn(n))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// renewalDue reports whether the certificate at path is missing, misses one
+// of names or expires within renewBefore
+func renewalDue(path string, names []string, renewBefore time.Duration) (bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return true, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return true, errors.New("no PEM certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return true, err
+	}
+
+	for _, n := range names {
+		if cert.VerifyHostname(n) != nil {
+			logrus.Infof("%s does not cover %s", path, n)
+			return true, nil
+		}
+	}
+	if time.Until(cert.NotAfter) < renewBefore {
+		logrus.Infof("%s expires on %s", path, cert.NotAfter.Format(time.RFC3339))
+		return true, nil
+	}
+	return false, nil
+}
+
+// obtainCert orders a certificate for names, answering each DNS-01 challenge
+// through the TXT record of the name, and writes the key and chain
+func obtainCert(ctx context.Context, update Update, c CertConfig, names []string) error {
+	key, err := loadAccountKey(c.AccountKey)
+	if err != nil {
+		return fmt.Errorf("ACME account key: %v", err)
+	}
+	hc, err := newHTTPClient(update)
+	if err != nil {
+		return err
+	}
+	client := &acme.Client{
+		Key:          key,
+		DirectoryURL: c.Directory,
+		HTTPClient:   hc,
+		UserAgent:    userAgent(),
+	}
+
+	account := &acme.Account{}
+	if c.Email != "" {
+		account.Contact = []string{"mailto:" + c.Email}
+	}
+	if _, err := client.Register(ctx, account, acme.AcceptTOS); err != nil &&
+		err != acme.ErrAccountAlreadyExists {
+		return fmt.Errorf("registering with %s: %v", c.Directory, err)
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(names...))
+	if err != nil {
+		return fmt.Errorf("ordering a certificate: %v", err)
+	}
+	for _, u := range order.AuthzURLs {
+		if err := authorize(ctx, update, client, u); err != nil {
+			return err
+		}
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return fmt.Errorf("waiting for the order: %v", err)
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		DNSNames: names,
+	}, certKey)
+	if err != nil {
+		return err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("finalizing the order: %v", err)
+	}
+
+	keyPEM, err := encodeKey(certKey)
+	if err != nil {
+		return err
+	}
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	// the key goes first, so a crash in between leaves a certificate that
+	// doesn't match rather than a new certificate with the old key
+	if err := writeFileAtomic(c.KeyFile, keyPEM, 0600); err != nil {
+		return err
+	}
+	return writeFileAtomic(c.CertFile, certPEM, 0644)
+}
+
+// authorize answers the DNS-01 challenge of one authorization, unless the CA
+// still remembers an earlier one
+func authorize(ctx context.Context, update Update, client *acme.Client, u string) error {
+	authz, err := client.GetAuthorization(ctx, u)
+	if err != nil {
+		return err
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+	host := authz.Identifier.Value
+
+	var chal *acme.Challenge
+	for _, ch := range authz.Challenges {
+		if ch.Type == "dns-01" {
+			chal = ch
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("%s offers no dns-01 challenge for %s", client.DirectoryURL, host)
+	}
+	record, err := client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+
+	name, err := acmeName(host)
+	if err != nil {
+		return err
+	}
+	p, err := acmeProvider(update, name)
+	if err != nil {
+		return err
+	}
+	// the record is cleared even when interrupted, the request is short
+	defer func() {
+		if err := clearACMEChallenge(context.Background(), p, name); err != nil {
+			logrus.WithError(err).Warnf("unable to clear the TXT record of %s", host)
+		}
+	}()
+	if err := setACMEChallenge(ctx, update, p, name, record); err != nil {
+		return err
+	}
+
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("accepting the challenge for %s: %v", host, err)
+	}
+	if _, err := client.WaitAuthorization(ctx, u); err != nil {
+		return fmt.Errorf("validating %s: %v", host, err)
+	}
+	logrus.Infof("%s validated", host)
+	return nil
+}
+
+// loadAccountKey reads the ACME account key at path, creating one if there is
+// none yet
+func loadAccountKey(path string) (crypto.Signer, error) {
+	if path == "" {
+		return nil, errors.New("no account_key location")
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		data, err := encodeKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+		logrus.Infof("creating ACME account key %s", path)
+		return key, writeFileAtomic(path, data, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM key found in %s", path)
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+func encodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+// writeFileAtomic replaces path with data through a temporary file, so that
+// readers never see half a file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/theag3nt/duckdns/pkg/duckdns"
+)
+
+// runCheckToken makes sure the token of every DuckDNS account is accepted,
+// with the first name of the account or name when given, without changing
+// any record. It exits non-zero unless all of them are.
+func runCheckToken(ctx context.Context, update Update, name string) {
+	if err := update.check(); err != nil {
+		fatal(exitConfig, err, "not checking")
+	}
+	name = normalizeName(Account{}, name)
+	client, err := newClient(update)
+	if err != nil {
+		fatal(exitConfig, err, "error preparing the token check")
+	}
+	resolver, err := newResolver(update)
+	if err != nil {
+		fatal(exitConfig, err, "error preparing the token check")
+	}
+
+	errs := &updateError{}
+	for i, a := range update.accounts() {
+		where := fmt.Sprintf("account %d", i+1)
+		p, err := newDNSProvider(a, client)
+		if err != nil {
+			fatal(exitConfig, err, "%s", where)
+		}
+		d, ok := p.(*duckdnsDNSProvider)
+		if !ok {
+			logrus.Infof("Skipping %s, %s tokens can't be checked without an update", where, p.Name())
+			continue
+		}
+		n := a.Names[0]
+		if name != "" {
+			if !containsName(a.Names, name) {
+				continue
+			}
+			n = name
+		}
+
+		errs.names++
+		if err := checkToken(ctx, d, resolver, n); err != nil {
+			if isRejected(err) {
+				errs.ko++
+				err = fmt.Errorf("the token of %s was rejected for %s: it is wrong or was "+
+					"regenerated, or %s is not one of its domains", where, n, n)
+			} else {
+				errs.network++
+			}
+			errs.fail(n, err)
+			continue
+		}
+		logrus.Infof("The token of %s works, checked with %s", where, n)
+	}
+	if errs.names == 0 {
+		fatal(exitConfig, nil, "%s is not a configured DuckDNS name", name)
+	}
+	if errs.failed() != 0 {
+		fatal(exitCode(errs), errs, "token check failed")
+	}
+}
+
+// checkToken sends the TXT value name already has back to DuckDNS, which
+// answers KO for a bad token and otherwise leaves everything as it was
+func checkToken(ctx context.Context, p *duckdnsDNSProvider, resolver *net.Resolver, name string) error {
+	txts, err := resolver.LookupTXT(ctx, fqdn(name))
+	var dnsErr *net.DNSError
+	if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		return fmt.Errorf("unable to look up the TXT record of %s: %v", name, err)
+	}
+	txt := strings.Join(txts, "")
+
+	var res duckdns.Result
+	if txt == "" {
+		res, err = p.ClearTXT(ctx, name)
+	} else {
+		res, err = p.UpdateTXT(ctx, name, txt)
+	}
+	if err == nil && res.Updated {
+		// the resolver had an outdated answer, most likely mid ACME challenge
+		logrus.Warnf("DuckDNS reported the TXT record of %s as changed, it is now %q", name, res.TXT)
+	}
+	return err
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"golang.org/x/crypto/acme"
+)
+
+// command is a subcommand such as `duckdns daemon`
+type command struct {
+	name string
+	// args describes the arguments after the name, for usage messages
+	args    string
+	summary string
+	// flags registers the flags only this command takes
+	flags func(fs *pflag.FlagSet, cli *CLIOptions)
+	// standalone commands run without loading the configuration
+	standalone bool
+	run        func(ctx context.Context, cli CLIOptions, update Update, args []string)
+}
+
+// commands in the order usage lists them. The first is the default.
+var commands = []*command{
+	{
+		name:    "update",
+		summary: "Update the names once, or keep running with daemon: true (default)",
+		run:     runUpdate,
+	},
+	{
+		name:    "daemon",
+		summary: "Keep running and update whenever the public IP changes",
+		run: func(ctx context.Context, cli CLIOptions, update Update, args []string) {
+			noArgs("daemon", args)
+			defer lock(update).Close()
+			runDaemon(ctx, update, func() Update { return loadConfig(ctx, cli) })
+		},
+	},
+	{
+		name:    "watch",
+		summary: "Run the daemon with a live view of the IP, records and log in the terminal",
+		run: func(ctx context.Context, cli CLIOptions, update Update, args []string) {
+			noArgs("watch", args)
+			defer lock(update).Close()
+			runWatch(ctx, update, func() Update { return loadConfig(ctx, cli) })
+		},
+	},
+	{
+		name:    "kubernetes",
+		summary: "Run the daemon in a pod, reloading when its ConfigMap or Secret changes",
+		run: func(ctx context.Context, cli CLIOptions, update Update, args []string) {
+			noArgs("kubernetes", args)
+			if os.Getenv("KUBERNETES_SERVICE_HOST") == "" {
+				logrus.Warn("not running in a Kubernetes pod")
+			}
+			cli.Watch = true
+			update.Watch = true
+			defer lock(update).Close()
+			runDaemon(ctx, update, func() Update { return loadConfig(ctx, cli) })
+		},
+	},
+	{
+		name:    "hotplug",
+		args:    "[address...]",
+		summary: "Update with the address a router's hotplug or ip-up script gives, without detecting it",
+		flags: func(fs *pflag.FlagSet, cli *CLIOptions) {
+			fs.StringSliceVar(&cli.HotplugInterfaces, "wan", defaultHotplugInterfaces,
+				"OpenWrt interfaces whose hotplug events update the names")
+		},
+		run: runHotplug,
+	},
+	{
+		name:    "txt",
+		args:    "<value>",
+		summary: "Set a TXT record on the names, e.g. for an ACME DNS challenge",
+		run: func(ctx context.Context, cli CLIOptions, update Update, args []string) {
+			if len(args) != 1 {
+				fatal(exitConfig, nil, "usage: duckdns [flags] txt <value>")
+			}
+			runTXT(ctx, cli, update, args[0])
+		},
+	},
+	{
+		name:    "clear",
+		summary: "Remove the IPv4 and IPv6 addresses of the names",
+		run:     runClear,
+	},
+	{
+		name:    "cert",
+		summary: "Get or renew a Let's Encrypt certificate for the names",
+		flags: func(fs *pflag.FlagSet, cli *CLIOptions) {
+			fs.StringVar(&cli.Cert.CertFile, "cert-file", "",
+				"Where to write the certificate chain")
+			fs.StringVar(&cli.Cert.KeyFile, "key-file", "",
+				"Where to write the private key of the certificate")
+			fs.StringVar(&cli.Cert.Email, "email", "",
+				"Contact address of the ACME account, for expiry notices")
+			fs.StringVar(&cli.Cert.Directory, "acme-directory", "",
+				"ACME directory URL (default \""+acme.LetsEncryptURL+"\")")
+			fs.BoolVar(&cli.CertStaging, "staging", false,
+				"Use the Let's Encrypt staging environment, for testing")
+			fs.StringVar(&cli.Cert.AccountKey, "account-key", "",
+				"ACME account key, created when missing (default in the user config dir)")
+			fs.DurationVar(&cli.Cert.RenewBefore, "renew-before", 0,
+				"Renew the certificate when it expires within this long (default 720h0m0s)")
+			fs.BoolVar(&cli.CertForce, "force", false,
+				"Get a new certificate even if the current one is not due for renewal")
+		},
+		run: func(ctx context.Context, cli CLIOptions, update Update, args []string) {
+			noArgs("cert", args)
+			if cli.CertStaging && cli.Cert.Directory == "" {
+				cli.Cert.Directory = letsEncryptStaging
+			}
+			runCert(ctx, update, cli.Cert, cli.CertForce)
+		},
+	},
+	{
+		name:    "status",
+		summary: "Check whether the names resolve to the current public IP",
+		run: func(ctx context.Context, cli CLIOptions, update Update, args []string) {
+			noArgs("status", args)
+			runStatus(ctx, update)
+		},
+	},
+	{
+		name:    "validate",
+		summary: "Check the configuration without contacting DuckDNS",
+		run: func(ctx context.Context, cli CLIOptions, update Update, args []string) {
+			noArgs("validate", args)
+			runValidate(update)
+		},
+	},
+	{
+		name:    "check-token",
+		args:    "[name]",
+		summary: "Check that DuckDNS accepts the tokens, without changing any record",
+		run: func(ctx context.Context, cli CLIOptions, update Update, args []string) {
+			if len(args) > 1 {
+				fatal(exitConfig, nil, "usage: duckdns [flags] check-token [name]")
+			}
+			name := ""
+			if len(args) == 1 {
+				name = args[0]
+			}
+			runCheckToken(ctx, update, name)
+		},
+	},
+	{
+		name:    "history",
+		args:    "[name...]",
+		summary: "Show the requests recorded in the history file",
+		flags: func(fs *pflag.FlagSet, cli *CLIOptions) {
+			fs.DurationVar(&cli.Since, "since", 0,
+				"Only show entries this recent, e.g. 720h")
+			fs.BoolVar(&cli.Changes, "changes", false,
+				"Only show requests that moved a name to a new address")
+		},
+		run: func(ctx context.Context, cli CLIOptions, update Update, args []string) {
+			runHistory(update, args, cli.Since, cli.Changes)
+		},
+	},
+	{
+		name:    "healthcheck",
+		summary: "Exit non-zero unless the last run succeeded recently",
+		flags: func(fs *pflag.FlagSet, cli *CLIOptions) {
+			fs.DurationVar(&cli.MaxAge, "max-age", 0,
+				"How recent the last successful run must be (default 3 intervals)")
+		},
+		run: func(ctx context.Context, cli CLIOptions, update Update, args []string) {
+			noArgs("healthcheck", args)
+			runHealthcheck(update, cli.MaxAge)
+		},
+	},
+	{
+		name:    "install",
+		args:    "systemd|launchd|task",
+		summary: "Write service definitions or a scheduled task running this binary",
+		flags: func(fs *pflag.FlagSet, cli *CLIOptions) {
+			fs.BoolVar(&cli.InstallUser, "user", false,
+				"Set up units for the current user instead of the system")
+			fs.BoolVar(&cli.InstallTimer, "timer", false,
+				"With systemd, run one-shot updates from a timer instead of the daemon")
+			fs.BoolVar(&cli.NetworkChange, "network-change", false,
+				"With launchd, also run whenever the network configuration changes")
+		},
+		run: func(ctx context.Context, cli CLIOptions, update Update, args []string) {
+			if len(args) != 1 {
+				fatal(exitConfig, nil, "usage: duckdns [flags] install systemd|launchd|task")
+			}
+			runInstall(update, cli, args[0])
+		},
+	},
+	{
+		name:    "service",
+		args:    "install|uninstall|start|stop",
+		summary: "Manage the Windows service",
+		run: func(ctx context.Context, cli CLIOptions, update Update, args []string) {
+			if len(args) != 1 {
+				fatal(exitConfig, nil, "usage: duckdns [flags] service install|uninstall|start|stop")
+			}
+			runServiceCommand(update, args[0])
+		},
+	},
+	{
+		name:    "self-update",
+		summary: "Replace this binary with the latest release",
+		flags: func(fs *pflag.FlagSet, cli *CLIOptions) {
+			fs.BoolVar(&cli.UpdateCheck, "check", false,
+				"Only report whether a newer release is available")
+			fs.BoolVar(&cli.UpdateForce, "force", false,
+				"Install the latest release even if it is this version, or over a development build")
+			fs.StringVar(&cli.ReleasesURL, "releases-url", defaultReleasesURL,
+				"Release API URL, for a mirror of the GitHub releases")
+		},
+		run: func(ctx context.Context, cli CLIOptions, update Update, args []string) {
+			noArgs("self-update", args)
+			runSelfUpdate(update, cli.ReleasesURL, cli.UpdateCheck, cli.UpdateForce)
+		},
+	},
+	{
+		name:    "mock-server",
+		summary: "Serve a local stand-in for the DuckDNS API to test configurations against",
+		flags: func(fs *pflag.FlagSet, cli *CLIOptions) {
+			fs.StringVar(&cli.MockAddr, "addr", defaultMockAddr,
+				"Address to listen on, the endpoint being http://<addr>/update")
+			fs.BoolVar(&cli.MockAcceptAny, "accept-any", false,
+				"Accept every token and name, not just those of the configuration")
+		},
+		run: func(ctx context.Context, cli CLIOptions, update Update, args []string) {
+			noArgs("mock-server", args)
+			runMockServer(ctx, update, cli.MockAddr, cli.MockAcceptAny)
+		},
+	},
+	{
+		name:    "dyndns-server",
+		summary: "Accept dyndns2 updates, from a router say, and pass them on to the names",
+		flags: func(fs *pflag.FlagSet, cli *CLIOptions) {
+			fs.StringVar(&cli.DyndnsAddr, "addr", defaultDyndnsAddr,
+				"Address to listen on, the update URL being http://<addr>/nic/update")
+		},
+		run: func(ctx context.Context, cli CLIOptions, update Update, args []string) {
+			noArgs("dyndns-server", args)
+			runDyndnsServer(ctx, update, cli.DyndnsAddr)
+		},
+	},
+	{
+		name:    "http-handler",
+		summary: "Run an update for every POST, for serverless platforms calling over HTTP",
+		flags: func(fs *pflag.FlagSet, cli *CLIOptions) {
+			fs.StringVar(&cli.HandlerAddr, "addr", "",
+				"Address to listen on (default :$PORT, :$FUNCTIONS_CUSTOMHANDLER_PORT or :8080)")
+		},
+		run: func(ctx context.Context, cli CLIOptions, update Update, args []string) {
+			noArgs("http-handler", args)
+			runHTTPHandler(ctx, update, handlerAddr(cli.HandlerAddr))
+		},
+	},
+	{
+		name:       "migrate",
+		args:       "[file]",
+		summary:    "Print the config equivalent to the DuckDNS entries of a ddclient or inadyn config",
+		standalone: true,
+		flags: func(fs *pflag.FlagSet, cli *CLIOptions) {
+			fs.StringVar(&cli.MigrateFrom, "from", "",
+				"Format of the file, ddclient or inadyn (default guessed from the file)")
+		},
+		run: func(ctx context.Context, cli CLIOptions, update Update, args []string) {
+			if len(args) > 1 {
+				fatal(exitConfig, nil, "usage: duckdns [flags] migrate [--from ddclient|inadyn] [file]")
+			}
+			var file string
+			if len(args) == 1 {
+				file = args[0]
+			}
+			runMigrate(file, cli.MigrateFrom)
+		},
+	},
+	{
+		name:       "version",
+		summary:    "Print the version and build details",
+		standalone: true,
+		run: func(ctx context.Context, cli CLIOptions, update Update, args []string) {
+			noArgs("version", args)
+			printVersion()
+		},
+	},
+	{
+		name:       "token",
+		args:       "set|get|delete",
+		summary:    "Manage the token stored in the OS keyring",
+		standalone: true,
+		run: func(ctx context.Context, cli CLIOptions, update Update, args []string) {
+			if len(args) != 1 {
+				fatal(exitConfig, nil, "usage: duckdns [flags] token set|get|delete")
+			}
+			runToken(args[0])
+		},
+	},
+}
+
+// findCommand picks the command named by the first argument, update when there
+// are none
+func findCommand(args []string) (*command, []string) {
+	if len(args) == 0 {
+		return commands[0], nil
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c, args[1:]
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	usage()
+	os.Exit(exitConfig)
+	return nil, nil
+}
+
+func noArgs(name string, args []string) {
+	if len(args) != 0 {
+		fatal(exitConfig, nil, "usage: duckdns [flags] %s", name)
+	}
+}
+
+// usage lists the commands and the flags they share
+func usage() {
+	w := pflag.CommandLine.Output()
+	fmt.Fprintf(w, "Usage: duckdns [flags] [command] [command flags]\n\nCommands:\n")
+	for _, c := range commands {
+		name := strings.TrimSpace(c.name + " " + c.args)
+		fmt.Fprintf(w, "  %-38s %s\n", name, c.summary)
+	}
+	fmt.Fprintf(w, "\nFlags:\n%s\n", pflag.CommandLine.FlagUsages())
+	fmt.Fprintf(w, "Run `duckdns <command> --help` for the flags of a command.\n")
+}
+
+// commandUsage describes one command, with its own flags listed apart from
+// the shared ones
+func commandUsage(c *command, fs *pflag.FlagSet) {
+	w := pflag.CommandLine.Output()
+	fmt.Fprintf(w, "Usage: duckdns [flags] %s\n\n%s\n",
+		strings.TrimSpace(c.name+" [flags] "+c.args), c.summary)
+	if c.flags != nil {
+		local := pflag.NewFlagSet(c.name, pflag.ContinueOnError)
+		c.flags(local, &CLIOptions{})
+		fmt.Fprintf(w, "\nFlags:\n%s", local.FlagUsages())
+	}
+	fmt.Fprintf(w, "\nRun `duckdns --help` for the flags shared by every command.\n")
+}
+
+// runUpdate runs the default command: a single update, or the daemon when the
+// configuration asks for one
+func runUpdate(ctx context.Context, cli CLIOptions, update Update, args []string) {
+	noArgs("update", args)
+	if cli.ACMEHook {
+		runACMEHook(ctx, update)
+		return
+	}
+	// --txt predates the txt command
+	if cli.TXT != "" {
+		runTXT(ctx, cli, update, cli.TXT)
+		return
+	}
+	if cli.DryRun {
+		fixed := update.fixedAddrs()
+		if err := dryRun(update, func(p dnsProvider, name string) (string, error) {
+			f := fixed[name]
+			return updateURL(p, name, f.ip, f.ipv6), nil
+		}); err != nil {
+			fatal(exitConfig, err, "error preparing update")
+		}
+		return
+	}
+
+	defer lock(update).Close()
+	if update.Daemon {
+		runDaemon(ctx, update, func() Update { return loadConfig(ctx, cli) })
+		return
+	}
+	results, err := makeUpdate(ctx, update)
+	printOutput(cli, results)
+	if ctx.Err() != nil {
+		fatal(exitError, nil, "interrupted")
+	}
+	if err != nil {
+		fatal(exitCode(err), err, "error updating IP address")
+	}
+	logrus.Debug("IP address updated successfully")
+}
+
+func runTXT(ctx context.Context, cli CLIOptions, update Update, txt string) {
+	if cli.DryRun {
+		if err := dryRun(update, func(p dnsProvider, name string) (string, error) {
+			d, ok := p.(*duckdnsDNSProvider)
+			if !ok {
+				return "", fmt.Errorf("%s can't set TXT records", p.Name())
+			}
+			return d.UpdateTXTURL(name, txt), nil
+		}); err != nil {
+			fatal(exitConfig, err, "error preparing update")
+		}
+		return
+	}
+
+	defer lock(update).Close()
+	results, err := makeTXTUpdate(ctx, update, txt)
+	printOutput(cli, results)
+	if ctx.Err() != nil {
+		fatal(exitError, nil, "interrupted")
+	}
+	if err != nil {
+		fatal(exitCode(err), err, "error updating TXT record")
+	}
+	logrus.Debug("TXT record updated successfully")
+}
+
+func runClear(ctx context.Context, cli CLIOptions, update Update, args []string) {
+	noArgs("clear", args)
+	if cli.DryRun {
+		if err := dryRun(update, func(p dnsProvider, name string) (string, error) {
+			d, ok := p.(*duckdnsDNSProvider)
+			if !ok {
+				return "", fmt.Errorf("%s can't clear records", p.Name())
+			}
+			return d.ClearURL(name), nil
+		}); err != nil {
+			fatal(exitConfig, err, "error preparing update")
+		}
+		return
+	}
+
+	defer lock(update).Close()
+	results, err := makeClear(ctx, update)
+	printOutput(cli, results)
+	if ctx.Err() != nil {
+		fatal(exitError, nil, "interrupted")
+	}
+	if err != nil {
+		fatal(exitCode(err), err, "error clearing addresses")
+	}
+	logrus.Debug("addresses cleared successfully")
+}
+
+// printOutput prints the results of a one-off run in the --output format
+func printOutput(cli CLIOptions, results []Result) {
+	if err := printResults(os.Stdout, cli.Output, results); err != nil {
+		logrus.WithError(err).Warn("unable to print the results")
+	}
+}
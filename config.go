@@ -0,0 +1,995 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/TV4/env"
+	"github.com/sirupsen/logrus"
+	"github.com/theag3nt/duckdns/pkg/duckdns"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Update contains everything that DuckDNS will need to update a record
+type Update struct {
+	Token string   `yaml:"token"`
+	Names []string `yaml:"domains"`
+	// TokenFile holds the token when Token is empty, such as a Docker secret.
+	// A relative path is relative to the config file.
+	TokenFile string `yaml:"token_file"`
+	// NamesFile lists the domains when Names is empty, one per line or
+	// separated by spaces or commas, such as a key of a mounted ConfigMap.
+	// A relative path is relative to the config file.
+	NamesFile string `yaml:"domains_file"`
+
+	// Accounts holds further tokens, each with their own domains, so names
+	// from several DuckDNS accounts can be updated in one run
+	Accounts []Account `yaml:"accounts"`
+
+	// Profiles are named sets of settings selected with --profile. Anything a
+	// profile leaves out is taken from the top level of the file.
+	Profiles map[string]Update `yaml:"profiles"`
+
+	// Endpoint is the update URL, for mocks and DuckDNS-compatible services
+	Endpoint string `yaml:"endpoint"`
+
+	// ConnectTimeout bounds the TCP connect and TLS handshake
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	// Timeout bounds each request, including reading the response
+	Timeout time.Duration `yaml:"timeout"`
+
+	// Proxy is an http://, https:// or socks5:// URL to send requests through.
+	// HTTP_PROXY and HTTPS_PROXY are honored when it is empty.
+	Proxy string `yaml:"proxy"`
+	// TLS sets extra root certificates, the oldest TLS version and pins for
+	// the endpoint
+	TLS TLSConfig `yaml:"tls"`
+	// ForceIPv4 and ForceIPv6 connect to the endpoint over that family only.
+	// DuckDNS sets the record the request came from when no IP is sent, which
+	// is either family on a dual-stack host.
+	ForceIPv4 bool `yaml:"force_ipv4"`
+	ForceIPv6 bool `yaml:"force_ipv6"`
+	// SourceIP is the local address requests are sent from, and
+	// BindInterface the network interface they leave through, for hosts with
+	// more than one uplink
+	SourceIP      string `yaml:"source_ip"`
+	BindInterface string `yaml:"bind_interface"`
+
+	// DetectIP looks up the public address before updating and sends it
+	// explicitly, which lets unchanged names be skipped
+	DetectIP bool `yaml:"detect_ip"`
+	// DualStack detects the IPv6 address as well and sends both in one
+	// request, and turns on DetectIP
+	DualStack bool `yaml:"dual_stack"`
+	// Addresses fixes the address of some names, such as internal ones
+	// pointing at the LAN, as "192.168.1.10" or "192.168.1.10,fd00::10".
+	// The other names get the detected address.
+	Addresses map[string]string `yaml:"addresses"`
+	// IPProviders is the detection chain, tried in order until one answers:
+	// ipify, icanhazip, duckdns or the URL of a service returning the address
+	IPProviders []string `yaml:"ip_providers"`
+	// IPQuorum is how many providers have to agree on the address before it
+	// is sent, so that one service answering wrong can't move the records
+	IPQuorum int `yaml:"ip_quorum"`
+	// Interface reads the address from a local network interface instead of
+	// the providers, and turns on DetectIP
+	Interface string `yaml:"interface"`
+	// DataDir holds the cache, history and lock files, those with a relative
+	// path included
+	DataDir string `yaml:"data_dir"`
+	// CacheFile records the last address sent for each name
+	CacheFile string `yaml:"cache_file"`
+	// HistoryFile is a JSON lines file recording every request to DuckDNS
+	HistoryFile string `yaml:"history_file"`
+	// Lock is a file locked while updating, so only one instance runs at once
+	Lock string `yaml:"lock"`
+	// RefreshInterval forces an update even if the address is unchanged
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+
+	// Daemon keeps running, checking the address every Interval
+	Daemon bool `yaml:"daemon"`
+	// Interval is how often the daemon checks the public address
+	Interval time.Duration `yaml:"interval"`
+	// Schedule is a crontab expression used instead of Interval
+	Schedule string `yaml:"schedule"`
+	// Jitter delays each scheduled run by a random amount up to this long
+	Jitter time.Duration `yaml:"jitter"`
+	// StartupDelay delays the daemon's first run by a random amount up to
+	// this long
+	StartupDelay time.Duration `yaml:"startup_delay"`
+	// Listen is the address of the daemon's HTTP server, disabled when empty
+	Listen string `yaml:"listen"`
+	// APIToken enables POST /update on the Listen server, for callers sending
+	// it as a bearer token
+	APIToken string `yaml:"api_token"`
+	// Watch reloads the daemon whenever the config, token or domains files
+	// change, as when Kubernetes updates a mounted ConfigMap or Secret
+	Watch bool `yaml:"watch"`
+	// WatchAddresses runs the daemon's check as soon as the address of the
+	// interface, or any one without Interface or BindInterface, changes.
+	// Linux only.
+	WatchAddresses bool `yaml:"watch_addresses"`
+
+	// Notify lists where address changes and failures are reported
+	Notify Notify `yaml:"notify"`
+	// MQTT publishes the daemon's state to a broker
+	MQTT MQTTConfig `yaml:"mqtt"`
+	// Metrics pushes the results of every run to statsd or InfluxDB
+	Metrics MetricsConfig `yaml:"metrics"`
+	// Hooks are commands run before updates and after changes or failures
+	Hooks Hooks `yaml:"hooks"`
+	// Cert is used by the cert subcommand
+	Cert CertConfig `yaml:"cert"`
+	// PingURL is a healthchecks.io style check pinged around every run
+	PingURL string `yaml:"ping_url"`
+	// OTLPEndpoint is the OTLP/HTTP collector that traces of every run are
+	// exported to, as with OTEL_EXPORTER_OTLP_ENDPOINT
+	OTLPEndpoint string `yaml:"otlp_endpoint"`
+
+	// configFile is the file the settings were read from, if any
+	configFile string
+	// configErrors are the problems met reading the config file and the
+	// token and domains files, reported along with the others by check so
+	// that a reload can keep the old config instead of exiting
+	configErrors []error
+	// ip and ipv6 are sent instead of detecting the address, for a run
+	// requested through POST /update
+	ip, ipv6 string
+
+	// Verify resolves each name after updating it to check that the new
+	// record has propagated
+	Verify bool `yaml:"verify"`
+	// VerifyResolver is the DNS server used for verification and status, as
+	// host:port, tls://host:port or a DNS over HTTPS URL, the system resolver
+	// when empty
+	VerifyResolver string `yaml:"verify_resolver"`
+	// VerifyTimeout is how long to wait for a record to propagate
+	VerifyTimeout time.Duration `yaml:"verify_timeout"`
+	// VerifyInterval is the wait between lookups while verifying
+	VerifyInterval time.Duration `yaml:"verify_interval"`
+
+	// RetryAttempts is how many times a name is tried before it counts as failed
+	RetryAttempts int `yaml:"retry_attempts"`
+	// RetryDelay is the wait before the first retry, doubled on each retry
+	RetryDelay time.Duration `yaml:"retry_delay"`
+	// RetryFailedAfter is the wait before the names that still failed are
+	// tried once more at the end of a run, disabled when 0
+	RetryFailedAfter time.Duration `yaml:"retry_failed_after"`
+	// OfflineRetry is how soon the daemon tries again when DuckDNS can't be
+	// reached, doubled on every further failure until the next regular check
+	OfflineRetry time.Duration `yaml:"offline_retry"`
+
+	// Concurrency is how many names are updated at once
+	Concurrency int `yaml:"concurrency"`
+	// RateLimit caps the requests sent to DuckDNS a minute, unlimited when 0
+	RateLimit int `yaml:"rate_limit"`
+}
+
+const (
+	defaultConnectTimeout = 10 * time.Second
+	defaultTimeout        = 30 * time.Second
+	defaultRetryAttempts  = 3
+	defaultRetryDelay     = 2 * time.Second
+	defaultConcurrency    = 4
+	maxRetryDelay         = time.Minute
+	defaultInterval       = 5 * time.Minute
+	defaultRefresh        = 24 * time.Hour
+	defaultVerifyTimeout  = 2 * time.Minute
+	defaultVerifyInterval = 10 * time.Second
+	defaultOfflineRetry   = 15 * time.Second
+)
+
+// Account is a DuckDNS token together with the names it owns
+type Account struct {
+	Token     string   `yaml:"token"`
+	TokenFile string   `yaml:"token_file"`
+	Names     []string `yaml:"domains"`
+
+	// Provider is the service the names belong to: duckdns (the default),
+	// dynu, afraid or any other dyndns2 service. For the others the token is
+	// the password and the names are full host names.
+	Provider string `yaml:"provider"`
+	Username string `yaml:"username"`
+	// Endpoint overrides the provider's update URL
+	Endpoint string `yaml:"endpoint"`
+}
+
+// accounts returns every account to update, the top level token and names
+// first followed by any from the accounts list
+func (u *Update) accounts() []Account {
+	var accounts []Account
+	if len(u.Names) > 0 {
+		accounts = append(accounts, Account{Token: u.Token, Names: u.Names})
+	}
+	return append(accounts, u.Accounts...)
+}
+
+// GetConfigCLI sets the arguments for an update if they have been passed in on
+// the CLI
+func getConfigCLI(c CLIOptions) Update {
+	var u Update
+
+	u.Token = c.Token
+	if u.Token == "" && c.TokenFile != "" {
+		u.Token = u.readTokenFile(c.TokenFile)
+	}
+	if u.Token == "" && c.TokenStdin {
+		token, err := readTokenInput()
+		if err != nil {
+			fatal(exitConfig, err, "unable to read the token from stdin")
+		}
+		u.Token = token
+	}
+	addSecret(u.Token)
+	logrus.Debugf("Set token from CLI to %s", u.Token)
+	u.TokenFile = c.TokenFile
+	u.Names = c.Names
+	u.NamesFile = c.NamesFile
+	if len(u.Names) == 0 && u.NamesFile != "" {
+		u.Names = u.readNamesFile(u.NamesFile)
+	}
+	logrus.Debugf("Set names from CLI to %s", strings.Join(c.Names, ", "))
+	u.Endpoint = c.Endpoint
+	u.ConnectTimeout = c.ConnectTimeout
+	u.Timeout = c.Timeout
+	u.Proxy = c.Proxy
+	u.TLS = c.TLS
+	u.ForceIPv4 = c.ForceIPv4
+	u.ForceIPv6 = c.ForceIPv6
+	u.SourceIP = c.SourceIP
+	u.BindInterface = c.BindInterface
+	u.RetryAttempts = c.RetryAttempts
+	u.Concurrency = c.Concurrency
+	u.RateLimit = c.RateLimit
+	u.RetryDelay = c.RetryDelay
+	u.RetryFailedAfter = c.RetryFailedAfter
+	u.OfflineRetry = c.OfflineRetry
+	u.DetectIP = c.DetectIP
+	u.DualStack = c.DualStack
+	u.IPProviders = c.IPProviders
+	u.IPQuorum = c.IPQuorum
+	u.Interface = c.Interface
+	u.DataDir = c.DataDir
+	u.CacheFile = c.CacheFile
+	u.Lock = c.Lock
+	u.HistoryFile = c.HistoryFile
+	u.RefreshInterval = c.RefreshInterval
+	u.Daemon = c.Daemon
+	u.Interval = c.Interval
+	u.Schedule = c.Schedule
+	u.Jitter = c.Jitter
+	u.StartupDelay = c.StartupDelay
+	u.Listen = c.Listen
+	u.APIToken = c.APIToken
+	u.Watch = c.Watch
+	u.WatchAddresses = c.WatchAddresses
+	u.Notify.Webhooks = c.NotifyWebhooks
+	u.PingURL = c.PingURL
+	u.OTLPEndpoint = c.OTLPEndpoint
+	u.Verify = c.Verify
+	u.VerifyResolver = c.VerifyResolver
+	u.VerifyTimeout = c.VerifyTimeout
+
+	return u
+}
+
+// GetConfigFile reads the config for DuckDNS. The format is taken from the
+// file extension unless it is given as "yaml" or "json". When profile is set
+// its values take precedence over the top level of the file. A URL is
+// downloaded, checking its signature against key when that is set, and "-"
+// is read from stdin.
+func getConfigFile(ctx context.Context, existing *Update, file, format, profile, key string, explicit bool) {
+
+	var update Update
+
+	var data []byte
+	var err error
+	base := file
+	if file == "-" {
+		if data, err = readStdinConfig(); err != nil {
+			fatal(exitConfig, err, "unable to read the config from stdin")
+		}
+		base = ""
+	} else if isRemoteConfig(file) {
+		if data, err = readRemoteConfig(ctx, *existing, file, key); err != nil {
+			existing.configErrors = append(existing.configErrors, err)
+			return
+		}
+		// relative files of a remote config are relative to the working dir
+		base = ""
+	} else if data, err = ioutil.ReadFile(file); err != nil {
+		// only a file that was asked for has to exist
+		if os.IsNotExist(err) && !explicit {
+			logrus.WithError(err).Debug("error reading file")
+			return
+		}
+		existing.configErrors = append(existing.configErrors,
+			fmt.Errorf("unable to read the config file: %v", err))
+		return
+	}
+	format = configFormat(configPath(file), format)
+	// a config that can't be decrypted is one that was meant to be used
+	if data, err = decryptConfig(data, format); err != nil {
+		existing.configErrors = append(existing.configErrors,
+			fmt.Errorf("unable to decrypt %s: %v", file, err))
+		return
+	}
+	err = decodeConfig(data, format, &update)
+	if err != nil {
+		existing.configErrors = append(existing.configErrors,
+			fmt.Errorf("%s is not a valid %s config: %v", file, format, err))
+		return
+	}
+	update.readFiles(base)
+	existing.configErrors = append(existing.configErrors, update.configErrors...)
+
+	if profile != "" {
+		p, ok := update.Profiles[profile]
+		if !ok {
+			existing.configErrors = append(existing.configErrors,
+				fmt.Errorf("profile %q not found in %s", profile, file))
+			return
+		}
+		logrus.Debugf("Using profile %s from %s", profile, file)
+		p.readFiles(base)
+		existing.configErrors = append(existing.configErrors, p.configErrors...)
+		mergeUpdate(&p, update, file)
+		update = p
+	}
+
+	mergeUpdate(existing, update, file)
+}
+
+// stdinConfig keeps the config read from stdin for the daemon's reloads, as
+// stdin can only be read once
+var stdinConfig struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+func readStdinConfig() ([]byte, error) {
+	stdinConfig.once.Do(func() {
+		stdinConfig.data, stdinConfig.err = ioutil.ReadAll(os.Stdin)
+	})
+	return stdinConfig.data, stdinConfig.err
+}
+
+// readFiles fills tokens and names left empty from their files, which are
+// relative to the config file they were read from. The paths are kept
+// resolved so that the daemon can watch them. Files that can't be read are
+// added to configErrors.
+func (u *Update) readFiles(file string) {
+	resolve := func(path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(filepath.Dir(file), path)
+	}
+
+	u.TokenFile = resolve(u.TokenFile)
+	if u.Token == "" && u.TokenFile != "" {
+		u.Token = u.readTokenFile(u.TokenFile)
+	}
+	u.NamesFile = resolve(u.NamesFile)
+	if len(u.Names) == 0 && u.NamesFile != "" {
+		u.Names = u.readNamesFile(u.NamesFile)
+	}
+	for i, a := range u.Accounts {
+		u.Accounts[i].TokenFile = resolve(a.TokenFile)
+		if a.Token == "" && a.TokenFile != "" {
+			u.Accounts[i].Token = u.readTokenFile(u.Accounts[i].TokenFile)
+		}
+	}
+}
+
+// readNamesFile returns the names listed in path, adding to configErrors if
+// it can't be read
+func (u *Update) readNamesFile(path string) []string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		u.configErrors = append(u.configErrors,
+			fmt.Errorf("unable to read the domains file: %v", err))
+		return nil
+	}
+	return splitList(string(data))
+}
+
+// splitList splits a list of names or the like on commas, semicolons and
+// whitespace, dropping empty entries
+func splitList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ';' || unicode.IsSpace(r)
+	})
+}
+
+// readTokenFile returns the token stored in path, ignoring surrounding
+// whitespace such as a trailing newline, adding to configErrors if it can't
+// be read
+func (u *Update) readTokenFile(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		u.configErrors = append(u.configErrors,
+			fmt.Errorf("unable to read the token file: %v", err))
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// mergeUpdate fills anything not already set in existing from update, which
+// was read from file
+func mergeUpdate(existing *Update, update Update, file string) {
+	// Set the token if it's not empty and doesn't already exist
+	if update.Token == "" {
+		logrus.Debugf("the token is empty after trying to parse %s", file)
+	} else if existing.Token == "" {
+		existing.Token = update.Token
+		existing.TokenFile = update.TokenFile
+	}
+
+	// Set names to if they exist and value is not already set. Names given on
+	// the CLI or environment replace the file's accounts as well.
+	if len(update.Names) == 0 && len(update.Accounts) == 0 {
+		logrus.Debugf("no names/subdomains specified to update from %s", file)
+	} else if len(existing.Names) == 0 && len(existing.Accounts) == 0 {
+		existing.Names = update.Names
+		existing.NamesFile = update.NamesFile
+		existing.Accounts = update.Accounts
+	}
+
+	if existing.Endpoint == "" {
+		existing.Endpoint = update.Endpoint
+	}
+	if existing.ConnectTimeout == 0 {
+		existing.ConnectTimeout = update.ConnectTimeout
+	}
+	if existing.Timeout == 0 {
+		existing.Timeout = update.Timeout
+	}
+	if existing.Proxy == "" {
+		existing.Proxy = update.Proxy
+	}
+	existing.TLS.merge(update.TLS)
+	if !existing.ForceIPv4 && !existing.ForceIPv6 {
+		existing.ForceIPv4 = update.ForceIPv4
+		existing.ForceIPv6 = update.ForceIPv6
+	}
+	if existing.SourceIP == "" {
+		existing.SourceIP = update.SourceIP
+	}
+	if existing.BindInterface == "" {
+		existing.BindInterface = update.BindInterface
+	}
+	if existing.RetryAttempts == 0 {
+		existing.RetryAttempts = update.RetryAttempts
+	}
+	if existing.Concurrency == 0 {
+		existing.Concurrency = update.Concurrency
+	}
+	if existing.RateLimit == 0 {
+		existing.RateLimit = update.RateLimit
+	}
+	if existing.RetryDelay == 0 {
+		existing.RetryDelay = update.RetryDelay
+	}
+	if existing.RetryFailedAfter == 0 {
+		existing.RetryFailedAfter = update.RetryFailedAfter
+	}
+	if existing.OfflineRetry == 0 {
+		existing.OfflineRetry = update.OfflineRetry
+	}
+	if !existing.DetectIP {
+		existing.DetectIP = update.DetectIP
+	}
+	if !existing.DualStack {
+		existing.DualStack = update.DualStack
+	}
+	if len(existing.IPProviders) == 0 {
+		existing.IPProviders = update.IPProviders
+	}
+	if existing.IPQuorum == 0 {
+		existing.IPQuorum = update.IPQuorum
+	}
+	if existing.Interface == "" {
+		existing.Interface = update.Interface
+	}
+	if existing.DataDir == "" {
+		existing.DataDir = update.DataDir
+	}
+	if existing.CacheFile == "" {
+		existing.CacheFile = update.CacheFile
+	}
+	if existing.Lock == "" {
+		existing.Lock = update.Lock
+	}
+	if existing.HistoryFile == "" {
+		existing.HistoryFile = update.HistoryFile
+	}
+	if existing.RefreshInterval == 0 {
+		existing.RefreshInterval = update.RefreshInterval
+	}
+	if !existing.Daemon {
+		existing.Daemon = update.Daemon
+	}
+	if existing.Interval == 0 {
+		existing.Interval = update.Interval
+	}
+	if existing.Schedule == "" {
+		existing.Schedule = update.Schedule
+	}
+	if existing.Jitter == 0 {
+		existing.Jitter = update.Jitter
+	}
+	if existing.StartupDelay == 0 {
+		existing.StartupDelay = update.StartupDelay
+	}
+	if existing.Listen == "" {
+		existing.Listen = update.Listen
+	}
+	if existing.APIToken == "" {
+		existing.APIToken = update.APIToken
+	}
+	if !existing.Watch {
+		existing.Watch = update.Watch
+	}
+	if !existing.WatchAddresses {
+		existing.WatchAddresses = update.WatchAddresses
+	}
+	existing.Notify.merge(update.Notify)
+	if existing.MQTT.Broker == "" {
+		existing.MQTT = update.MQTT
+	}
+	if existing.Addresses == nil {
+		existing.Addresses = update.Addresses
+	}
+	if existing.Metrics.Statsd == "" && existing.Metrics.InfluxDB == "" {
+		existing.Metrics = update.Metrics
+	}
+	existing.Hooks.merge(update.Hooks)
+	existing.Cert.merge(update.Cert)
+	if existing.PingURL == "" {
+		existing.PingURL = update.PingURL
+	}
+	if existing.OTLPEndpoint == "" {
+		existing.OTLPEndpoint = update.OTLPEndpoint
+	}
+	if !existing.Verify {
+		existing.Verify = update.Verify
+	}
+	if existing.VerifyResolver == "" {
+		existing.VerifyResolver = update.VerifyResolver
+	}
+	if existing.VerifyTimeout == 0 {
+		existing.VerifyTimeout = update.VerifyTimeout
+	}
+	if existing.VerifyInterval == 0 {
+		existing.VerifyInterval = update.VerifyInterval
+	}
+}
+
+// configSearchPaths lists where a config file is looked for when --config is
+// not given, in order of preference
+func configSearchPaths() []string {
+	var paths []string
+
+	xdg := env.String("XDG_CONFIG_HOME", "")
+	home, err := os.UserHomeDir()
+	if err != nil {
+		logrus.WithError(err).Debug("unable to find home directory")
+	}
+	if xdg == "" && home != "" {
+		xdg = filepath.Join(home, ".config")
+	}
+	if xdg != "" {
+		paths = append(paths, filepath.Join(xdg, "duckdns", "config.yaml"))
+	}
+	if home != "" {
+		paths = append(paths, filepath.Join(home, ".duckdns.yaml"))
+	}
+
+	return append(paths, filepath.Join("/etc", "duckdns", "config.yaml"))
+}
+
+// findConfigFile returns the config file to read. An explicit --config is
+// used as is, otherwise the first existing search path wins and the --config
+// default is the fallback.
+func findConfigFile(file string, explicit bool) string {
+	if explicit {
+		logrus.Debugf("Using config file %s from the CLI", file)
+		return file
+	}
+
+	for _, p := range configSearchPaths() {
+		if _, err := os.Stat(p); err == nil {
+			logrus.Debugf("Using config file %s", p)
+			return p
+		}
+		logrus.Debugf("No config file at %s", p)
+	}
+
+	logrus.Debugf("Using default config file %s", file)
+	return file
+}
+
+// configPath is the file part of a config location, which for a URL is its path
+func configPath(file string) string {
+	if isRemoteConfig(file) {
+		if u, err := url.Parse(file); err == nil {
+			return u.Path
+		}
+	}
+	return file
+}
+
+// configFormat picks the decoder for file, honoring an explicit format
+func configFormat(file, format string) string {
+	if format != "" {
+		return strings.ToLower(format)
+	}
+	// an encrypted config.json.age is still JSON
+	if filepath.Ext(strings.TrimSuffix(strings.ToLower(file), ".age")) == ".json" {
+		return "json"
+	}
+	return "yaml"
+}
+
+// decodeConfig parses a config document in the given format. JSON is checked
+// strictly, unknown keys are an error and numbers are kept exact, then run
+// through the YAML decoder so that both formats share the same schema,
+// including durations written as "10s".
+func decodeConfig(data []byte, format string, out *Update) error {
+	switch format {
+	case "yaml", "yml":
+		return yaml.Unmarshal(data, out)
+	case "json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var doc interface{}
+		if err := dec.Decode(&doc); err != nil {
+			return err
+		}
+		if _, err := dec.Token(); err != io.EOF {
+			return errors.New("unexpected data after the JSON document")
+		}
+		doc, err := jsonNumbers(doc)
+		if err != nil {
+			return err
+		}
+		b, err := yaml.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		return yaml.UnmarshalStrict(b, out)
+	}
+	return fmt.Errorf("unknown config format %q", format)
+}
+
+// jsonNumbers turns the json.Numbers of a decoded document into integers
+// where they are whole, so they reach YAML as written rather than as floats.
+// An integer too large for any field is an error.
+func jsonNumbers(v interface{}) (interface{}, error) {
+	var err error
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		if u, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			return u, nil
+		}
+		if !strings.ContainsAny(v.String(), ".eE") {
+			return nil, fmt.Errorf("number %s is out of range", v)
+		}
+		return v.Float64()
+	case map[string]interface{}:
+		for k, e := range v {
+			if v[k], err = jsonNumbers(e); err != nil {
+				return nil, fmt.Errorf("%s: %v", k, err)
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			if v[i], err = jsonNumbers(e); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+// GetConfigEnv is for reading items out of the environment if you didn't want
+// to set them on the CLI
+func getConfigEnv(u *Update) {
+	token := env.String("DUCK_TOKEN", "")
+	names := envList("DUCK_NAMES")
+	if tokenFile := env.String("DUCK_TOKEN_FILE", ""); token == "" && tokenFile != "" &&
+		u.Token == "" {
+		token = u.readTokenFile(tokenFile)
+		u.TokenFile = tokenFile
+	}
+	if param := env.String("DUCK_TOKEN_SSM", ""); token == "" && param != "" && u.Token == "" {
+		var err error
+		if token, err = ssmParameter(param); err != nil {
+			u.configErrors = append(u.configErrors,
+				fmt.Errorf("unable to read the token from SSM parameter %s: %v", param, err))
+		}
+	}
+
+	// Set the token if not already set
+	if u.Token == "" {
+		u.Token = token
+		addSecret(token)
+		logrus.Debugf("Set token from environment to %s", token)
+	}
+
+	if len(u.Names) == 0 && len(names) > 0 {
+		u.Names = names
+		logrus.Debugf("Set names from environment to %s",
+			strings.Join(u.Names, ", "))
+	}
+	if namesFile := env.String("DUCK_NAMES_FILE", ""); len(u.Names) == 0 && namesFile != "" {
+		u.NamesFile = namesFile
+		u.Names = u.readNamesFile(namesFile)
+	}
+
+	if u.Endpoint == "" {
+		u.Endpoint = env.String("DUCK_ENDPOINT", "")
+	}
+	if u.ConnectTimeout == 0 {
+		u.ConnectTimeout = envDuration("DUCK_CONNECT_TIMEOUT")
+	}
+	if u.Timeout == 0 {
+		u.Timeout = envDuration("DUCK_TIMEOUT")
+	}
+	if u.Proxy == "" {
+		u.Proxy = env.String("DUCK_PROXY", "")
+	}
+	if u.TLS.CAFile == "" {
+		u.TLS.CAFile = env.String("DUCK_TLS_CA_FILE", "")
+	}
+	if u.TLS.MinVersion == "" {
+		u.TLS.MinVersion = env.String("DUCK_TLS_MIN_VERSION", "")
+	}
+	if len(u.TLS.Pins) == 0 {
+		u.TLS.Pins = envList("DUCK_TLS_PINS")
+	}
+	if !u.ForceIPv4 && !u.ForceIPv6 {
+		u.ForceIPv4 = envBool("DUCK_FORCE_IPV4")
+		u.ForceIPv6 = envBool("DUCK_FORCE_IPV6")
+	}
+	if u.SourceIP == "" {
+		u.SourceIP = env.String("DUCK_SOURCE_IP", "")
+	}
+	if u.BindInterface == "" {
+		u.BindInterface = env.String("DUCK_BIND_INTERFACE", "")
+	}
+	if u.RetryAttempts == 0 {
+		u.RetryAttempts = envInt("DUCK_RETRY_ATTEMPTS")
+	}
+	if u.Concurrency == 0 {
+		u.Concurrency = envInt("DUCK_CONCURRENCY")
+	}
+	if u.RateLimit == 0 {
+		u.RateLimit = envInt("DUCK_RATE_LIMIT")
+	}
+	if u.RetryDelay == 0 {
+		u.RetryDelay = envDuration("DUCK_RETRY_DELAY")
+	}
+	if u.RetryFailedAfter == 0 {
+		u.RetryFailedAfter = envDuration("DUCK_RETRY_FAILED_AFTER")
+	}
+	if u.OfflineRetry == 0 {
+		u.OfflineRetry = envDuration("DUCK_OFFLINE_RETRY")
+	}
+	if !u.DetectIP {
+		u.DetectIP = envBool("DUCK_DETECT_IP")
+	}
+	if !u.DualStack {
+		u.DualStack = envBool("DUCK_DUAL_STACK")
+	}
+	if len(u.IPProviders) == 0 {
+		u.IPProviders = envList("DUCK_IP_PROVIDERS")
+	}
+	if u.IPQuorum == 0 {
+		u.IPQuorum = envInt("DUCK_IP_QUORUM")
+	}
+	if u.Interface == "" {
+		u.Interface = env.String("DUCK_INTERFACE", "")
+	}
+	if u.DataDir == "" {
+		u.DataDir = env.String("DUCK_DATA_DIR", "")
+	}
+	if u.CacheFile == "" {
+		u.CacheFile = env.String("DUCK_CACHE_FILE", "")
+	}
+	if u.Lock == "" {
+		u.Lock = env.String("DUCK_LOCK", "")
+	}
+	if u.HistoryFile == "" {
+		u.HistoryFile = env.String("DUCK_HISTORY_FILE", "")
+	}
+	if u.RefreshInterval == 0 {
+		u.RefreshInterval = envDuration("DUCK_REFRESH_INTERVAL")
+	}
+	if !u.Daemon {
+		u.Daemon = envBool("DUCK_DAEMON")
+	}
+	if !u.Watch {
+		u.Watch = envBool("DUCK_WATCH")
+	}
+	if !u.WatchAddresses {
+		u.WatchAddresses = envBool("DUCK_WATCH_ADDRESSES")
+	}
+	if u.Interval == 0 {
+		u.Interval = envDuration("DUCK_INTERVAL")
+	}
+	if u.Schedule == "" {
+		u.Schedule = env.String("DUCK_SCHEDULE", "")
+	}
+	if u.Jitter == 0 {
+		u.Jitter = envDuration("DUCK_JITTER")
+	}
+	if u.StartupDelay == 0 {
+		u.StartupDelay = envDuration("DUCK_STARTUP_DELAY")
+	}
+	if u.Listen == "" {
+		u.Listen = env.String("DUCK_LISTEN", "")
+	}
+	if u.APIToken == "" {
+		u.APIToken = env.String("DUCK_API_TOKEN", "")
+	}
+	if hooks := env.String("DUCK_NOTIFY_WEBHOOKS", ""); len(u.Notify.Webhooks) == 0 &&
+		hooks != "" {
+		u.Notify.Webhooks = strings.Fields(hooks)
+	}
+	if u.Notify.WebhookTemplate == "" {
+		u.Notify.WebhookTemplate = env.String("DUCK_NOTIFY_WEBHOOK_TEMPLATE", "")
+	}
+	if u.PingURL == "" {
+		u.PingURL = env.String("DUCK_PING_URL", "")
+	}
+	if u.OTLPEndpoint == "" {
+		u.OTLPEndpoint = env.String("DUCK_OTLP_ENDPOINT", "")
+	}
+	if !u.Verify {
+		u.Verify = envBool("DUCK_VERIFY")
+	}
+	if u.VerifyResolver == "" {
+		u.VerifyResolver = env.String("DUCK_VERIFY_RESOLVER", "")
+	}
+	if u.VerifyTimeout == 0 {
+		u.VerifyTimeout = envDuration("DUCK_VERIFY_TIMEOUT")
+	}
+}
+
+// envList reads a list from the environment: key split by splitList, or else
+// key_0, key_1 and so on up to the first one unset, for tools that can't put a
+// list in a single variable
+func envList(key string) []string {
+	if v := env.String(key, ""); v != "" {
+		return splitList(v)
+	}
+	var list []string
+	for i := 0; ; i++ {
+		v := env.String(fmt.Sprintf("%s_%d", key, i), "")
+		if v == "" {
+			return list
+		}
+		list = append(list, splitList(v)...)
+	}
+}
+
+// envBool reads a true/false value from the environment, returning false when
+// it is unset or malformed
+func envBool(key string) bool {
+	v := env.String(key, "")
+	if v == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		logrus.WithError(err).Warnf("ignoring invalid boolean in %s", key)
+		return false
+	}
+	return b
+}
+
+// envInt reads a number from the environment, returning zero when it is unset
+// or malformed
+func envInt(key string) int {
+	v := env.String(key, "")
+	if v == "" {
+		return 0
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		logrus.WithError(err).Warnf("ignoring invalid number in %s", key)
+		return 0
+	}
+	return i
+}
+
+// envDuration reads a duration such as "15s" from the environment, returning
+// zero when it is unset or malformed
+func envDuration(key string) time.Duration {
+	v := env.String(key, "")
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		logrus.WithError(err).Warnf("ignoring invalid duration in %s", key)
+		return 0
+	}
+	return d
+}
+
+// setDefaults fills in any options that no config source provided
+func (u *Update) setDefaults() {
+	if u.Endpoint == "" {
+		u.Endpoint = duckdns.DefaultEndpoint
+	}
+	if u.ConnectTimeout == 0 {
+		u.ConnectTimeout = defaultConnectTimeout
+	}
+	if u.Timeout == 0 {
+		u.Timeout = defaultTimeout
+	}
+	if u.RetryAttempts == 0 {
+		u.RetryAttempts = defaultRetryAttempts
+	}
+	if u.Concurrency == 0 {
+		u.Concurrency = defaultConcurrency
+	}
+	if u.RetryDelay == 0 {
+		u.RetryDelay = defaultRetryDelay
+	}
+	if len(u.IPProviders) == 0 {
+		u.IPProviders = defaultIPProviders
+	}
+	if u.Interface != "" || u.DualStack {
+		u.DetectIP = true
+	}
+	explicitDataDir := u.DataDir != ""
+	if u.DataDir == "" {
+		u.DataDir = defaultDataDir()
+	}
+	if u.CacheFile == "" && !explicitDataDir {
+		// keep using the cache of older versions until the data dir has one
+		if legacy := legacyCacheFile(); legacy != "" && !fileExists(u.dataPath("", "cache.json")) &&
+			fileExists(legacy) {
+			u.CacheFile = legacy
+		}
+	}
+	u.CacheFile = u.dataPath(u.CacheFile, "cache.json")
+	u.HistoryFile = u.dataPath(u.HistoryFile, "")
+	u.Lock = u.dataPath(u.Lock, "")
+	if u.RefreshInterval == 0 {
+		u.RefreshInterval = defaultRefresh
+	}
+	if u.Interval == 0 {
+		u.Interval = defaultInterval
+	}
+	if u.VerifyTimeout == 0 {
+		u.VerifyTimeout = defaultVerifyTimeout
+	}
+	if u.VerifyInterval == 0 {
+		u.VerifyInterval = defaultVerifyInterval
+	}
+	if u.OfflineRetry == 0 {
+		u.OfflineRetry = defaultOfflineRetry
+	}
+}
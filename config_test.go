@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergeUpdate(t *testing.T) {
+	file := Update{
+		Token:    "file-token",
+		Names:    []string{"file"},
+		Accounts: []Account{{Token: "other-token", Names: []string{"other"}}},
+		Endpoint: "https://file.example.com/update",
+		Timeout:  time.Minute,
+		Interval: 10 * time.Minute,
+		DetectIP: true,
+	}
+
+	tests := []struct {
+		name     string
+		existing Update
+		want     Update
+	}{
+		{
+			name:     "everything from the file",
+			existing: Update{},
+			want:     file,
+		},
+		{
+			name: "set values are kept",
+			existing: Update{
+				Token:    "cli-token",
+				Endpoint: "https://cli.example.com/update",
+				Timeout:  time.Second,
+			},
+			want: Update{
+				Token:    "cli-token",
+				Names:    file.Names,
+				Accounts: file.Accounts,
+				Endpoint: "https://cli.example.com/update",
+				Timeout:  time.Second,
+				Interval: file.Interval,
+				DetectIP: true,
+			},
+		},
+		{
+			name:     "names replace the file's accounts",
+			existing: Update{Names: []string{"cli"}},
+			want: Update{
+				Token:    file.Token,
+				Names:    []string{"cli"},
+				Endpoint: file.Endpoint,
+				Timeout:  file.Timeout,
+				Interval: file.Interval,
+				DetectIP: true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.existing
+			mergeUpdate(&got, file, "duckdns.yaml")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeUpdate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"home", []string{"home"}},
+		{"home,office", []string{"home", "office"}},
+		{"home; office\nnas\t", []string{"home", "office", "nas"}},
+		{" , ;\n", []string{}},
+	}
+	for _, tt := range tests {
+		if got := splitList(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitList(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestReadFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "token"), "a7c4d0ad-114e-40ef-ba1d-d217904a50f2\n")
+	writeFile(t, filepath.Join(dir, "domains"), "home, office\n")
+
+	u := Update{TokenFile: "token", NamesFile: "domains"}
+	u.readFiles(filepath.Join(dir, "duckdns.yaml"))
+	if len(u.configErrors) != 0 {
+		t.Fatalf("configErrors = %v", u.configErrors)
+	}
+	if u.Token != "a7c4d0ad-114e-40ef-ba1d-d217904a50f2" {
+		t.Errorf("Token = %q", u.Token)
+	}
+	if want := []string{"home", "office"}; !reflect.DeepEqual(u.Names, want) {
+		t.Errorf("Names = %q, want %q", u.Names, want)
+	}
+	if want := filepath.Join(dir, "token"); u.TokenFile != want {
+		t.Errorf("TokenFile = %q, want %q", u.TokenFile, want)
+	}
+
+	// files that can't be read are problems to report, not a reason to exit
+	u = Update{
+		TokenFile: "missing-token",
+		NamesFile: "missing-domains",
+		Accounts:  []Account{{TokenFile: "missing-account-token"}},
+	}
+	u.readFiles(filepath.Join(dir, "duckdns.yaml"))
+	if len(u.configErrors) != 3 {
+		t.Fatalf("configErrors = %v, want 3 problems", u.configErrors)
+	}
+}
+
+func TestGetConfigFileProblems(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "duckdns.yaml")
+	writeFile(t, file, "domains: [home]\ntoken_file: missing-token\nprofiles:\n  work:\n    domains: [work]\n")
+
+	tests := []struct {
+		name    string
+		profile string
+		want    []string
+	}{
+		{name: "unreadable token file", want: []string{"unable to read the token file"}},
+		{name: "unknown profile", profile: "play", want: []string{
+			"unable to read the token file",
+			`profile "play" not found`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var u Update
+			getConfigFile(context.Background(), &u, file, "", tt.profile, "", true)
+			err := u.check()
+			if err == nil {
+				t.Fatal("check() = nil, want the problems of the file")
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("check() = %q, want it to mention %q", err, w)
+				}
+			}
+		})
+	}
+
+	var u Update
+	getConfigFile(context.Background(), &u, filepath.Join(dir, "missing.yaml"), "", "", "", false)
+	if len(u.configErrors) != 0 {
+		t.Errorf("configErrors = %v, a default config file may be missing", u.configErrors)
+	}
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecodeConfigJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    Update
+		wantErr string
+	}{
+		{
+			name: "durations as strings",
+			json: `{"token": "t", "domains": ["home"], "timeout": "30s"}`,
+			want: Update{Token: "t", Names: []string{"home"}, Timeout: 30 * time.Second},
+		},
+		{
+			name: "integers are exact",
+			json: `{"timeout": 9007199254740993, "ip_quorum": 2}`,
+			want: Update{Timeout: 9007199254740993, IPQuorum: 2},
+		},
+		{
+			name:    "unknown key",
+			json:    `{"domains": ["home"], "tokne": "t"}`,
+			wantErr: "tokne",
+		},
+		{
+			name:    "integer out of range",
+			json:    `{"ip_quorum": 1000000000000000000000}`,
+			wantErr: "ip_quorum",
+		},
+		{
+			name:    "trailing data",
+			json:    `{"token": "t"} {}`,
+			wantErr: "unexpected data",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Update
+			err := decodeConfig([]byte(tt.json), "json", &got)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("decodeConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeConfig() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
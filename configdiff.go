@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// configChanges describes how next differs from old, one line per setting
+// named as in the config file. Tokens, passwords and anything else that may
+// hold a credential are only reported as changed.
+func configChanges(old, next Update) []string {
+	a, b := flattenConfig(old), flattenConfig(next)
+	keys := map[string]bool{}
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+	var sorted []string
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var changes []string
+	for _, k := range sorted {
+		va, vb := a[k], b[k]
+		// an empty string is the same as a setting that is missing
+		if reflect.DeepEqual(va, vb) || settingValue(va) == settingValue(vb) {
+			continue
+		}
+		la, oka := va.([]interface{})
+		lb, okb := vb.([]interface{})
+		switch {
+		case secretSetting(k):
+			changes = append(changes, k+" changed")
+		case oka || okb:
+			changes = append(changes, k+": "+listChanges(la, lb))
+		default:
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", k, settingValue(va), settingValue(vb)))
+		}
+	}
+	return changes
+}
+
+// flattenConfig maps every setting of u to its value, nested keys joined with
+// dots and entries of lists of mappings numbered, like accounts[0].token
+func flattenConfig(u Update) map[string]interface{} {
+	// profiles are already applied
+	u.Profiles = nil
+	out := map[string]interface{}{}
+	data, err := yaml.Marshal(u)
+	if err != nil {
+		return out
+	}
+	var doc interface{}
+	if yaml.Unmarshal(data, &doc) != nil {
+		return out
+	}
+	flatten(out, "", doc)
+	return out
+}
+
+func flatten(out map[string]interface{}, prefix string, v interface{}) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		for k, e := range v {
+			key := fmt.Sprint(k)
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			flatten(out, key, e)
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			return
+		}
+		for _, e := range v {
+			if _, ok := e.(map[interface{}]interface{}); !ok {
+				out[prefix] = v
+				return
+			}
+		}
+		for i, e := range v {
+			flatten(out, fmt.Sprintf("%s[%d]", prefix, i), e)
+		}
+	default:
+		out[prefix] = v
+	}
+}
+
+// secretSetting reports whether the value of key may be a credential:
+// tokens, passwords, keys, webhook URLs and proxies with a user
+func secretSetting(key string) bool {
+	switch last := key[strings.LastIndex(key, ".")+1:]; last {
+	case "token", "password", "webhook", "webhooks", "proxy", "ping_url":
+		return true
+	default:
+		return strings.HasSuffix(last, "_token") || strings.HasSuffix(last, "_key")
+	}
+}
+
+// listChanges lists the entries added to and removed from a list
+func listChanges(old, next []interface{}) string {
+	var parts []string
+	for _, e := range next {
+		if !containsValue(old, e) {
+			parts = append(parts, "+"+settingValue(e))
+		}
+	}
+	for _, e := range old {
+		if !containsValue(next, e) {
+			parts = append(parts, "-"+settingValue(e))
+		}
+	}
+	if len(parts) == 0 {
+		return "reordered"
+	}
+	return strings.Join(parts, " ")
+}
+
+func containsValue(list []interface{}, v interface{}) bool {
+	for _, e := range list {
+		if reflect.DeepEqual(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func settingValue(v interface{}) string {
+	if v == nil || v == "" {
+		return "(unset)"
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/TV4/env"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ageHeader starts every binary age file
+const ageHeader = "age-encryption.org/v1\n"
+
+// decryptConfig returns the plain text of a config file encrypted with age,
+// whole or armored, or with sops. Anything else is returned unchanged.
+func decryptConfig(data []byte, format string) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte(ageHeader)):
+		return decryptAge(bytes.NewReader(data))
+	case bytes.HasPrefix(bytes.TrimSpace(data), []byte(armor.Header)):
+		return decryptAge(armor.NewReader(bytes.NewReader(bytes.TrimSpace(data))))
+	case isSOPS(data):
+		return decryptSOPS(data, format)
+	}
+	return data, nil
+}
+
+// decryptAge decrypts r with the identities of DUCK_AGE_KEY, DUCK_AGE_KEY_FILE
+// or age-key.txt in the duckdns config dir, in that order
+func decryptAge(r io.Reader) ([]byte, error) {
+	ids, err := ageIdentities()
+	if err != nil {
+		return nil, err
+	}
+	plain, err := age.Decrypt(r, ids...)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(plain)
+}
+
+func ageIdentities() ([]age.Identity, error) {
+	if key := env.String("DUCK_AGE_KEY", ""); key != "" {
+		return age.ParseIdentities(bytes.NewBufferString(key))
+	}
+	path := env.String("DUCK_AGE_KEY_FILE", "")
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, errors.New("the config file is encrypted, set DUCK_AGE_KEY or DUCK_AGE_KEY_FILE")
+		}
+		path = filepath.Join(dir, "duckdns", "age-key.txt")
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("the config file is encrypted and there is no age key at %s, set DUCK_AGE_KEY or DUCK_AGE_KEY_FILE", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return age.ParseIdentities(bufio.NewReader(f))
+}
+
+// isSOPS reports whether the document has the metadata sops adds on encrypting
+func isSOPS(data []byte) bool {
+	var doc struct {
+		SOPS interface{} `yaml:"sops"`
+	}
+	return yaml.Unmarshal(data, &doc) == nil && doc.SOPS != nil
+}
+
+// decryptSOPS runs the sops binary, which knows every key service sops
+// encrypts to. DUCK_AGE_KEY is handed over as the sops age key. The data goes
+// through a temporary file as sops can't read stdin everywhere.
+func decryptSOPS(data []byte, format string) ([]byte, error) {
+	if format == "yml" {
+		format = "yaml"
+	}
+	tmp, err := ioutil.TempFile("", "duckdns-sops-*."+format)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command("sops", "--decrypt", "--input-type", format, "--output-type", format, tmp.Name())
+	cmd.Env = os.Environ()
+	if key := env.String("DUCK_AGE_KEY", ""); key != "" && env.String("SOPS_AGE_KEY", "") == "" {
+		cmd.Env = append(cmd.Env, "SOPS_AGE_KEY="+key)
+	}
+	if path := env.String("DUCK_AGE_KEY_FILE", ""); path != "" && env.String("SOPS_AGE_KEY_FILE", "") == "" {
+		cmd.Env = append(cmd.Env, "SOPS_AGE_KEY_FILE="+path)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, errors.New("the config file is encrypted with sops, which is not installed")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sops: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/theag3nt/duckdns/pkg/duckdns"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// runDaemon keeps the records current, checking the public address every
+// interval until ctx is cancelled. DuckDNS is only contacted when the address
+// changed or the last update is older than the refresh interval, thanks to the
+// cache. When reload is set, SIGHUP swaps in the configuration it returns, as
+// does a change to the files it came from with watch: true.
+func runDaemon(ctx context.Context, update Update, reload func() Update) {
+	daemonLoop(ctx, update, reload, newDaemonState(update))
+}
+
+// daemonLoop is runDaemon reporting to state, which `duckdns watch` reads
+func daemonLoop(ctx context.Context, update Update, reload func() Update, state *daemonState) {
+	update.DetectIP = true
+	sched, err := newSchedule(update)
+	if err != nil {
+		fatal(exitConfig, err, "unable to start the daemon")
+	}
+	if update.Schedule != "" {
+		logrus.Infof("Checking the public IP on the schedule %q, refreshing records every %s",
+			update.Schedule, update.RefreshInterval)
+	} else {
+		logrus.Infof("Checking the public IP every %s, refreshing records every %s",
+			update.Interval, update.RefreshInterval)
+	}
+
+	if update.Listen != "" {
+		startServer(ctx, update.Listen, state)
+	}
+
+	sd := newSDNotifier()
+	sd.watchdog()
+	ready := false
+
+	hup := make(chan os.Signal, 1)
+	var changed <-chan struct{}
+	if reload != nil {
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		// the files to watch are fixed on start, so a new token file path
+		// needs a restart
+		if update.Watch {
+			changed = watchFiles(ctx, update.sourceFiles(), watchInterval)
+		}
+	}
+
+	var addresses <-chan struct{}
+	if update.WatchAddresses {
+		iface := update.Interface
+		if iface == "" {
+			iface = update.BindInterface
+		}
+		addresses, err = watchAddresses(ctx, iface)
+		if err != nil {
+			logrus.WithError(err).Warn("not watching for address changes, relying on the interval")
+		} else if iface != "" {
+			logrus.Infof("Updating as soon as the address of %s changes", iface)
+		} else {
+			logrus.Info("Updating as soon as a network address changes")
+		}
+	}
+
+	// spread out devices that all start at once, after a power cut say
+	if delay := randomDelay(update.StartupDelay); delay > 0 {
+		logrus.Infof("Waiting %s before the first update", delay.Round(time.Second))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+
+	var offline offlineBackoff
+	var trigger *updateTrigger
+	for {
+		run := update
+		if trigger != nil {
+			run.ip, run.ipv6 = trigger.ip, trigger.ipv6
+		}
+		sd.busy(true)
+		// every check is a trace of its own
+		runCtx, span := tracer.Start(ctx, "duckdns check", trace.WithNewRoot())
+		results, err := makeUpdate(runCtx, run)
+		endSpan(span, err)
+		sd.busy(false)
+		if ctx.Err() != nil {
+			return
+		}
+		if trigger != nil {
+			res := triggerResult{Results: results}
+			if err != nil {
+				res.Error = redact(err.Error())
+			}
+			trigger.done <- res
+			trigger = nil
+		}
+		next := sched.next(time.Now())
+		var retry time.Duration
+		if e, ok := err.(*updateError); ok && e.network > 0 {
+			wait := offline.failed(update.OfflineRetry)
+			// a throttling service says when to come back
+			var se *duckdns.StatusError
+			if errors.As(err, &se) && se.RetryAfter > wait {
+				wait = se.RetryAfter
+			}
+			if time.Now().Add(wait).Before(next) {
+				retry = wait
+				next = time.Now().Add(wait)
+			}
+		} else if down := offline.recovered(); down > 0 {
+			logrus.Infof("DuckDNS is reachable again after %s", down.Round(time.Second))
+		}
+		state.record(update, results, err, next)
+		if update.MQTT.Broker != "" {
+			if err := publishState(ctx, update, state); err != nil {
+				logrus.WithError(err).Warn("unable to publish to MQTT")
+			}
+		}
+
+		if err != nil {
+			logrus.WithError(err).Error("error updating IP address")
+			if retry > 0 {
+				logrus.Warnf("DuckDNS can't be reached, trying again in %s", retry)
+			}
+			sd.notify(fmt.Sprintf("STATUS=Last update failed at %s",
+				time.Now().Format(time.RFC3339)))
+		} else {
+			ip := ""
+			if len(results) > 0 {
+				ip = results[0].IP
+			}
+			sd.notify(fmt.Sprintf("STATUS=Updated %d name(s) to %s at %s", len(results),
+				ip, time.Now().Format(time.RFC3339)))
+			if !ready {
+				sd.notify("READY=1")
+				ready = true
+			}
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			continue
+		case <-addresses:
+			timer.Stop()
+			logrus.Info("Network address changed, checking the public IP")
+			continue
+		case t := <-state.triggers:
+			timer.Stop()
+			trigger = &t
+			logrus.WithFields(logrus.Fields{"ip": t.ip, "ipv6": t.ipv6}).
+				Infof("Update requested through %s", t.from)
+			continue
+		case <-hup:
+		case <-changed:
+		}
+		timer.Stop()
+		sd.notify("RELOADING=1")
+		update = reloadConfig(update, reload(), state)
+		sched, _ = newSchedule(update)
+		sd.notify("READY=1")
+	}
+}
+
+// offlineBackoff spaces out the tries while DuckDNS can't be reached,
+// starting at OfflineRetry and doubling, so the latest address goes out soon
+// after the network comes back instead of at the next scheduled check
+type offlineBackoff struct {
+	wait  time.Duration
+	since time.Time
+}
+
+// failed returns the wait before trying again after another unreachable run
+func (b *offlineBackoff) failed(first time.Duration) time.Duration {
+	if b.since.IsZero() {
+		b.since = time.Now()
+		b.wait = first
+	} else {
+		b.wait *= 2
+	}
+	return b.wait
+}
+
+// recovered ends the outage, returning how long it lasted
+func (b *offlineBackoff) recovered() time.Duration {
+	if b.since.IsZero() {
+		return 0
+	}
+	down := time.Since(b.since)
+	*b = offlineBackoff{}
+	return down
+}
+
+// reloadConfig returns next if it is usable, and old otherwise so that a
+// broken edit doesn't stop a running daemon. The listener keeps its address
+// until a restart.
+func reloadConfig(old, next Update, state *daemonState) Update {
+	next.DetectIP = true
+	if err := next.check(); err != nil {
+		logrus.WithError(err).Error("keeping the old configuration")
+		return old
+	}
+	if _, err := newSchedule(next); err != nil {
+		logrus.WithError(err).Error("keeping the old configuration")
+		return old
+	}
+	if next.Listen != old.Listen {
+		logrus.Warnf("the listen address only changes on restart, still serving on %s", old.Listen)
+		next.Listen = old.Listen
+	}
+
+	state.mu.Lock()
+	state.interval = next.Interval
+	state.apiToken = next.APIToken
+	state.update = next
+	state.Domains = map[string]domainState{}
+	state.mu.Unlock()
+
+	changes := configChanges(old, next)
+	if len(changes) == 0 {
+		logrus.Infof("Reloaded the configuration from %s, nothing changed", next.configFile)
+		return next
+	}
+	logrus.Infof("Reloaded the configuration from %s", next.configFile)
+	for _, c := range changes {
+		logrus.Infof("  %s", c)
+	}
+	return next
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"html/template"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sparklineLength is how many of the latest history entries the dashboard
+// draws for each name
+const sparklineLength = 48
+
+// dashboardDomain is a row of the dashboard
+type dashboardDomain struct {
+	Name     string
+	Resolved string
+	domainState
+	History []historyEntry
+}
+
+// sparkBar is a bar of a sparkline, tall for a change and red for a failure
+type sparkBar struct {
+	X, Y, Height int
+	Class, Title string
+}
+
+// handleDashboard serves a read-only page with the state of every name, what
+// it resolves to right now and its latest history, for a quick look from a
+// browser. It refreshes itself every minute.
+func (s *daemonState) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	update := s.update
+	page := struct {
+		Version   string
+		IP        string
+		LastRun   time.Time
+		LastError string
+		NextRun   time.Time
+		Domains   []dashboardDomain
+	}{Version: version, IP: s.IP, LastRun: s.LastRun, LastError: s.LastError, NextRun: s.NextRun}
+	for _, a := range update.accounts() {
+		for _, n := range a.Names {
+			page.Domains = append(page.Domains, dashboardDomain{Name: n, domainState: s.Domains[n]})
+		}
+	}
+	s.mu.Unlock()
+
+	history := readRecentHistory(update.HistoryFile, sparklineLength)
+	resolver, err := newResolver(update)
+	if err != nil {
+		logrus.WithError(err).Debug("dashboard falling back to the system resolver")
+		resolver = net.DefaultResolver
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	for i, d := range page.Domains {
+		var ips []net.IP
+		addrs, err := resolver.LookupIPAddr(ctx, fqdn(d.Name))
+		if err != nil {
+			logrus.WithError(err).Debugf("unable to resolve %s", d.Name)
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+		page.Domains[i].Resolved = joinIPs(ips)
+		page.Domains[i].History = history[d.Name]
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, page); err != nil {
+		logrus.WithError(err).Debug("unable to render the dashboard")
+	}
+}
+
+// readRecentHistory returns the last n entries of the history file for each
+// name, oldest first. A missing or unreadable file is no history at all.
+func readRecentHistory(path string, n int) map[string][]historyEntry {
+	history := map[string][]historyEntry{}
+	if path == "" {
+		return history
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.WithError(err).Debugf("unable to read %s", path)
+		}
+		return history
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e historyEntry
+		if json.Unmarshal(sc.Bytes(), &e) != nil {
+			continue
+		}
+		entries := append(history[e.Domain], e)
+		if len(entries) > n {
+			entries = entries[len(entries)-n:]
+		}
+		history[e.Domain] = entries
+	}
+	return history
+}
+
+// sparkline lays out the bars of a name's history
+func sparkline(entries []historyEntry) []sparkBar {
+	var bars []sparkBar
+	for i, e := range entries {
+		b := sparkBar{X: i * 4, Y: 8, Height: 8, Class: "ok"}
+		switch e.Result {
+		case resultUpdated:
+			b.Y, b.Height, b.Class = 0, 16, "changed"
+		case resultFailed, resultError:
+			b.Y, b.Height, b.Class = 0, 16, "bad"
+		}
+		b.Title = e.Time.Local().Format(time.RFC3339) + " " + e.Result
+		if e.IP != "" {
+			b.Title += " " + e.IP
+		}
+		bars = append(bars, b)
+	}
+	return bars
+}
+
+// timeAgo formats how long ago t was, or "never"
+func timeAgo(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
+
+// timeUntil formats how long until t, or "-"
+func timeUntil(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return "in " + time.Until(t).Round(time.Second).String()
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"sparkline": sparkline,
+	"since":     timeAgo,
+	"until":     timeUntil,
+	"width":     func(entries []historyEntry) int { return len(entries) * 4 },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="60">
+<title>duckdns</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em auto; max-width: 60em; padding: 0 1em; color: #222; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: .4em .6em; border-bottom: 1px solid #ddd; vertical-align: middle; }
+.updated, .unchanged { color: #1a7f37; }
+.failed, .error { color: #c62828; }
+.note { color: #666; font-size: .9em; }
+rect.ok { fill: #8bc34a; } rect.changed { fill: #1976d2; } rect.bad { fill: #e53935; }
+</style>
+</head>
+<body>
+<h1>duckdns</h1>
+<p>
+Last run {{since .LastRun}}{{if .LastError}}, <span class="failed">failed: {{.LastError}}</span>{{end}}.
+Next run {{until .NextRun}}.
+{{if .IP}}Public IP {{.IP}}.{{end}}
+</p>
+<table>
+<tr><th>Name</th><th>Result</th><th>Sent</th><th>Resolves to</th><th>Last success</th><th>History</th></tr>
+{{range .Domains}}
+<tr>
+<td>{{.Name}}</td>
+<td class="{{.Result}}">{{if .Result}}{{.Result}}{{else}}-{{end}}{{if .Failures}} ({{.Failures}} in a row){{end}}
+{{if .LastError}}<div class="note">{{.LastError}}</div>{{end}}</td>
+<td>{{if .IP}}{{.IP}}{{end}}{{if .IPv6}}<br>{{.IPv6}}{{end}}</td>
+<td>{{.Resolved}}</td>
+<td>{{since .LastSuccess}}</td>
+<td>{{with .History}}<svg width="{{width .}}" height="16" role="img" aria-label="history">
+{{range sparkline .}}<rect class="{{.Class}}" x="{{.X}}" y="{{.Y}}" width="3" height="{{.Height}}"><title>{{.Title}}</title></rect>{{end}}
+</svg>{{else}}<span class="note">no history</span>{{end}}</td>
+</tr>
+{{end}}
+</table>
+<p class="note">duckdns {{.Version}}, refreshed every minute. Blue bars are changes, red ones failures.</p>
+</body>
+</html>
+`))
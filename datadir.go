@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"syscall"
+
+	"github.com/TV4/env"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultDataDir is where the state, history and lock files go unless
+// --data-dir says otherwise: the XDG state directory or its equivalent on
+// macOS and Windows, or /tmp in a Lambda function, the only place it can
+// write. It is empty when there is no home directory to put it in.
+func defaultDataDir() string {
+	if env.String("AWS_LAMBDA_RUNTIME_API", "") != "" {
+		return filepath.Join(os.TempDir(), "duckdns")
+	}
+	if dir := env.String("XDG_STATE_HOME", ""); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "duckdns")
+	}
+	switch runtime.GOOS {
+	case "windows":
+		if dir := env.String("LocalAppData", ""); dir != "" {
+			return filepath.Join(dir, "duckdns")
+		}
+		return ""
+	case "darwin":
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, "Library", "Application Support", "duckdns")
+		}
+		return ""
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".local", "state", "duckdns")
+}
+
+// dataPath resolves a relative path against the data dir, or gives name in it
+// when path is empty. Without a data dir, an empty path stays empty.
+func (u *Update) dataPath(path, name string) string {
+	if path == "" {
+		if name == "" || u.DataDir == "" {
+			return ""
+		}
+		path = name
+	}
+	if filepath.IsAbs(path) || u.DataDir == "" {
+		return path
+	}
+	return filepath.Join(u.DataDir, path)
+}
+
+// isReadOnly reports whether err comes from writing where nothing can be
+// written, such as a container with readOnlyRootFilesystem
+func isReadOnly(err error) bool {
+	return errors.Is(err, syscall.EROFS) || os.IsPermission(err)
+}
+
+// readOnlyWarned holds the paths already warned about by warnReadOnly
+var readOnlyWarned sync.Map
+
+// warnReadOnly logs, once per path, that path can't be written and why that
+// is fine. It reports whether err was of that kind at all.
+func warnReadOnly(path string, err error, without string) bool {
+	if !isReadOnly(err) {
+		return false
+	}
+	if _, warned := readOnlyWarned.LoadOrStore(path, true); warned {
+		logrus.WithError(err).Debugf("unable to write %s", path)
+	} else {
+		logrus.WithError(err).Warnf("%s can't be written, %s", path, without)
+	}
+	return true
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"text/template"
+)
+
+// DiscordNotify configures messages to a Discord channel webhook
+type DiscordNotify struct {
+	Webhook string `yaml:"webhook"`
+	// Template overrides the message, as for Slack
+	Template string `yaml:"template"`
+}
+
+type discordNotifier struct {
+	webhook  string
+	template *template.Template
+	hc       *http.Client
+}
+
+func newDiscordNotifier(c DiscordNotify, hc *http.Client) (*discordNotifier, error) {
+	t, err := parseTemplate("discord", c.Template)
+	if err != nil {
+		return nil, err
+	}
+	return &discordNotifier{webhook: c.Webhook, template: t, hc: hc}, nil
+}
+
+func (d *discordNotifier) Name() string { return "discord" }
+
+func (d *discordNotifier) Notify(ctx context.Context, ev notifyEvent) error {
+	text, err := message(d.template, ev)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(struct {
+		Content string `json:"content"`
+	}{text})
+	if err != nil {
+		return err
+	}
+	return postNotification(ctx, d.hc, d.webhook, "application/json", body)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultDyndnsAddr is where `duckdns dyndns-server` listens unless told
+// otherwise. A router on the LAN has to reach it, so not just localhost.
+const defaultDyndnsAddr = ":8245"
+
+// dyndnsServer answers dyndns2 updates, such as those of a router, by
+// updating the configured names it asks for
+type dyndnsServer struct {
+	update Update
+	// mu keeps runs one at a time, as they share the cache and history files
+	mu sync.Mutex
+}
+
+// runDyndnsServer serves the dyndns2 protocol on addr until ctx is cancelled.
+// Each request goes through the same update as a run of the daemon, for the
+// names it lists that the configuration has.
+func runDyndnsServer(ctx context.Context, update Update, addr string) {
+	if err := update.check(); err != nil {
+		fatal(exitConfig, err, "not serving")
+	}
+	s := &dyndnsServer{update: update}
+	mux := http.NewServeMux()
+	mux.Handle("/nic/update", s)
+	mux.Handle("/v3/update", s)
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal(exitError, err, "unable to listen on %s", addr)
+	}
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	logrus.Infof("Serving dyndns2 updates on http://%s/nic/update", ln.Addr())
+	if err := srv.Serve(ln); err != http.ErrServerClosed {
+		fatal(exitError, err, "dyndns2 server failed")
+	}
+}
+
+// ServeHTTP handles /nic/update?hostname=<names>&myip=<address>. The password
+// of the basic auth is the API token when one is set, otherwise the token of
+// the account each name belongs to; the username is ignored. The answer has a
+// line per name, such as "good 203.0.113.5" or "nohost".
+func (s *dyndnsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, password, ok := r.BasicAuth()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="duckdns"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, "badauth")
+		return
+	}
+	q := r.URL.Query()
+	var hosts []string
+	for _, h := range strings.Split(q.Get("hostname"), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	if len(hosts) == 0 {
+		fmt.Fprintln(w, "notfqdn")
+		return
+	}
+	ip, ipv6, err := splitAddrs(q.Get("myip") + "," + q.Get("myipv6"))
+	if err != nil {
+		logrus.WithError(err).Warnf("dyndns2 request from %s refused", r.RemoteAddr)
+		fmt.Fprintln(w, "dnserr")
+		return
+	}
+
+	// every name gets its line in the order asked, either an answer right
+	// away or the result of the run below
+	answers := make([]string, len(hosts))
+	names := make([]string, len(hosts))
+	var accounts []Account
+	for i, h := range hosts {
+		a, name, found := s.lookup(h)
+		switch {
+		case !found:
+			answers[i] = "nohost"
+		case !s.authorized(a, password):
+			answers[i] = "badauth"
+		default:
+			names[i] = name
+			accounts = addName(accounts, a, name)
+		}
+	}
+
+	if len(accounts) > 0 {
+		logrus.WithFields(logrus.Fields{"ip": ip, "ipv6": ipv6}).
+			Infof("dyndns2 update from %s for %s", r.RemoteAddr, strings.Join(hosts, ","))
+		run := s.update
+		run.Names, run.Accounts = nil, accounts
+		run.ip, run.ipv6 = ip, ipv6
+		s.mu.Lock()
+		results, _ := makeUpdate(r.Context(), run)
+		s.mu.Unlock()
+		byName := map[string]string{}
+		for _, res := range results {
+			byName[res.Domain] = dyndnsAnswer(res)
+		}
+		for i, name := range names {
+			if name == "" {
+				continue
+			}
+			if answers[i] = byName[name]; answers[i] == "" {
+				answers[i] = "911"
+			}
+		}
+	}
+	fmt.Fprintln(w, strings.Join(answers, "\n"))
+}
+
+// lookup finds the account with host among its names, and the name as sent
+func (s *dyndnsServer) lookup(host string) (Account, string, bool) {
+	for _, a := range s.update.accounts() {
+		name := normalizeName(a, host)
+		if containsName(a.Names, name) {
+			return a, name, true
+		}
+	}
+	return Account{}, "", false
+}
+
+func (s *dyndnsServer) authorized(a Account, password string) bool {
+	want := a.Token
+	if s.update.APIToken != "" {
+		want = s.update.APIToken
+	}
+	return subtle.ConstantTimeCompare([]byte(password), []byte(want)) == 1
+}
+
+// addName adds name to the copy of a in accounts, appending one if needed
+func addName(accounts []Account, a Account, name string) []Account {
+	for i := range accounts {
+		if accounts[i].Token == a.Token && accounts[i].Provider == a.Provider &&
+			accounts[i].Endpoint == a.Endpoint {
+			if !containsName(accounts[i].Names, name) {
+				accounts[i].Names = append(accounts[i].Names, name)
+			}
+			return accounts
+		}
+	}
+	a.Names = []string{name}
+	return append(accounts, a)
+}
+
+// dyndnsAnswer is the dyndns2 line for the result of a name. A refusal by the
+// service is not the client's fault, so it gets dnserr rather than badauth,
+// and errors answer 911 so that the client backs off and retries later.
+func dyndnsAnswer(res Result) string {
+	addr := res.IP
+	if addr == "" {
+		addr = res.IPv6
+	}
+	switch res.Status {
+	case resultUpdated, resultUnverified:
+		return strings.TrimSpace("good " + addr)
+	case resultUnchanged, resultSkipped:
+		return strings.TrimSpace("nochg " + addr)
+	case resultFailed:
+		return "dnserr"
+	}
+	return "911"
+}
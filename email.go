@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// EmailNotify configures summaries sent by email
+type EmailNotify struct {
+	Host string `yaml:"host"`
+	// Port defaults to 465 with TLS set to tls and 587 otherwise
+	Port     int      `yaml:"port"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	// TLS is starttls (the default), tls for a TLS connection from the start,
+	// or none
+	TLS      string `yaml:"tls"`
+	Template string `yaml:"template"`
+}
+
+type emailNotifier struct {
+	EmailNotify
+	template *template.Template
+	timeout  time.Duration
+}
+
+func newEmailNotifier(c EmailNotify, hc *http.Client) (*emailNotifier, error) {
+	switch c.TLS {
+	case "":
+		c.TLS = "starttls"
+	case "starttls", "tls", "none":
+	default:
+		return nil, fmt.Errorf("unknown email tls mode %q, expected starttls, tls or none", c.TLS)
+	}
+	if c.Port == 0 {
+		c.Port = 587
+		if c.TLS == "tls" {
+			c.Port = 465
+		}
+	}
+	if c.From == "" || len(c.To) == 0 {
+		return nil, fmt.Errorf("email notifications need from and to addresses")
+	}
+
+	t, err := parseTemplate("email", c.Template)
+	if err != nil {
+		return nil, err
+	}
+	n := &emailNotifier{EmailNotify: c, template: t, timeout: defaultTimeout}
+	if hc != nil && hc.Timeout > 0 {
+		n.timeout = hc.Timeout
+	}
+	return n, nil
+}
+
+func (e *emailNotifier) Name() string { return "email" }
+
+func (e *emailNotifier) Notify(ctx context.Context, ev notifyEvent) error {
+	return e.NotifySummary(ctx, []notifyEvent{ev})
+}
+
+// NotifySummary sends one email listing every event of the run
+func (e *emailNotifier) NotifySummary(ctx context.Context, events []notifyEvent) error {
+	changed, failed := 0, 0
+	var body strings.Builder
+	for _, ev := range events {
+		if ev.Result == eventFailed {
+			failed++
+		} else {
+			changed++
+		}
+		line, err := message(e.template, ev)
+		if err != nil {
+			return err
+		}
+		body.WriteString(line + "\r\n")
+	}
+
+	var subject []string
+	if changed > 0 {
+		subject = append(subject, fmt.Sprintf("%d name(s) changed", changed))
+	}
+	if failed > 0 {
+		subject = append(subject, fmt.Sprintf("%d name(s) failed", failed))
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: DuckDNS: %s\r\n", strings.Join(subject, ", "))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(body.String())
+
+	return e.send(ctx, msg.Bytes())
+}
+
+// send delivers msg, honoring the TLS mode and the request timeout
+func (e *emailNotifier) send(ctx context.Context, msg []byte) error {
+	addr := net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	deadline := time.Now().Add(e.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	dialer := &net.Dialer{Deadline: deadline}
+	var conn net.Conn
+	var err error
+	if e.TLS == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: e.Host})
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, e.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if e.TLS == "starttls" {
+		if err := c.StartTLS(&tls.Config{ServerName: e.Host}); err != nil {
+			return err
+		}
+	}
+	if e.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", e.Username, e.Password, e.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(e.From); err != nil {
+		return err
+	}
+	for _, to := range e.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// emitTimeout bounds sending the metrics of a run
+const emitTimeout = 5 * time.Second
+
+// MetricsConfig pushes the outcome of every run to statsd or InfluxDB, for
+// the Telegraf and Graphite setups that don't scrape /metrics
+type MetricsConfig struct {
+	// Statsd is the host:port of a statsd server, sent to over UDP
+	Statsd string `yaml:"statsd"`
+	// InfluxDB is the write URL of an InfluxDB, such as
+	// http://nas:8086/api/v2/write?org=home&bucket=duckdns or
+	// http://nas:8086/write?db=duckdns, or udp://host:port for a listener
+	// taking line protocol over UDP
+	InfluxDB      string `yaml:"influxdb"`
+	InfluxDBToken string `yaml:"influxdb_token"`
+	// Prefix starts every statsd metric and InfluxDB measurement, duckdns by
+	// default
+	Prefix string `yaml:"prefix"`
+}
+
+func (m MetricsConfig) prefix() string {
+	if m.Prefix == "" {
+		return "duckdns"
+	}
+	return m.Prefix
+}
+
+// emitMetrics sends the results of a run to the configured emitters. Failures
+// are only logged, like those of notifications.
+func emitMetrics(update Update, results []Result) {
+	m := update.Metrics
+	if len(results) == 0 || (m.Statsd == "" && m.InfluxDB == "") {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), emitTimeout)
+	defer cancel()
+	if m.Statsd != "" {
+		if err := sendDatagram(ctx, m.Statsd, statsdLines(m.prefix(), results)); err != nil {
+			logrus.WithError(err).Warn("unable to send metrics to statsd")
+		}
+	}
+	if m.InfluxDB != "" {
+		if err := writeInflux(ctx, update, influxLines(m.prefix(), results, time.Now())); err != nil {
+			logrus.WithError(err).Warn("unable to send metrics to InfluxDB")
+		}
+	}
+}
+
+// statsdLines are the statsd metrics of results: a counter per name and
+// status, one for address changes and the request time
+func statsdLines(prefix string, results []Result) []string {
+	var lines []string
+	for _, r := range results {
+		name := prefix + "." + strings.Replace(r.Domain, ".", "_", -1)
+		lines = append(lines, fmt.Sprintf("%s.%s:1|c", name, r.Status))
+		if r.Updated {
+			lines = append(lines, name+".ip_changes:1|c")
+		}
+		if r.Duration > 0 {
+			lines = append(lines, fmt.Sprintf("%s.duration:%d|ms", name, r.Duration.Milliseconds()))
+		}
+	}
+	return lines
+}
+
+// influxLines are the InfluxDB line protocol points of results, one per name
+// in the <prefix>_update measurement
+func influxLines(prefix string, results []Result, now time.Time) []string {
+	var lines []string
+	for _, r := range results {
+		provider := r.Provider
+		if provider == "" {
+			provider = "DuckDNS"
+		}
+		fields := []string{
+			fmt.Sprintf("ok=%t", r.OK),
+			fmt.Sprintf("changed=%t", r.Updated),
+			fmt.Sprintf("duration=%g", r.Duration.Seconds()),
+		}
+		if r.IP != "" {
+			fields = append(fields, "ip="+influxString(r.IP))
+		}
+		if r.IPv6 != "" {
+			fields = append(fields, "ipv6="+influxString(r.IPv6))
+		}
+		if r.Message != "" {
+			fields = append(fields, "message="+influxString(redact(r.Message)))
+		}
+		lines = append(lines, fmt.Sprintf("%s_update,domain=%s,provider=%s,status=%s %s %d",
+			influxKey(prefix), influxKey(r.Domain), influxKey(provider), influxKey(r.Status),
+			strings.Join(fields, ","), now.UnixNano()))
+	}
+	return lines
+}
+
+// influxKey escapes a measurement, tag key or tag value
+func influxKey(s string) string {
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace(s)
+}
+
+// influxString quotes a string field value
+func influxString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// sendDatagram sends lines to addr over UDP, as many to a packet as fit in a
+// safe size
+func sendDatagram(ctx context.Context, addr string, lines []string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var packet bytes.Buffer
+	flush := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := conn.Write(packet.Bytes())
+		packet.Reset()
+		return err
+	}
+	for _, l := range lines {
+		if packet.Len() > 0 && packet.Len()+len(l)+1 > 1432 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(l)
+	}
+	return flush()
+}
+
+// writeInflux sends lines to the InfluxDB write URL, or over UDP for a udp://
+// one
+func writeInflux(ctx context.Context, update Update, lines []string) error {
+	m := update.Metrics
+	u, err := url.Parse(m.InfluxDB)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "udp" {
+		return sendDatagram(ctx, u.Host, lines)
+	}
+	hc, err := newHTTPClient(update)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, m.InfluxDB, strings.NewReader(strings.Join(lines, "\n")+"\n"))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if m.InfluxDBToken != "" {
+		req.Header.Set("Authorization", "Token "+m.InfluxDBToken)
+	}
+	return sendNotification(ctx, hc, req)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Exit codes, so that scripts can tell a bad token from a flaky network.
+// They are listed in the README.
+const (
+	exitError   = 1 // anything not covered below
+	exitConfig  = 2 // invalid configuration or usage
+	exitNetwork = 3 // DuckDNS could not be reached
+	exitKO      = 4 // DuckDNS answered KO, usually a bad token or name
+	exitPartial = 5 // some names were updated and others failed
+	exitVerify  = 6 // updates were accepted but did not propagate
+)
+
+// updateError collects the failures of one run by class
+type updateError struct {
+	errs []error
+	// failures maps each failed name to its message
+	failures map[string]string
+
+	names   int
+	ko      int
+	network int
+	verify  int
+}
+
+func (e *updateError) Error() string {
+	return redact(errors.Join(e.errs...).Error())
+}
+
+// Unwrap lets errors.Is and errors.As look at the failure of each name
+func (e *updateError) Unwrap() []error {
+	return e.errs
+}
+
+// fail records a failed name
+func (e *updateError) fail(name string, err error) {
+	if e.failures == nil {
+		e.failures = map[string]string{}
+	}
+	e.failures[name] = redact(err.Error())
+	e.errs = append(e.errs, err)
+}
+
+func (e *updateError) failed() int {
+	return e.ko + e.network + e.verify
+}
+
+// exitCode picks the exit code describing err
+func exitCode(err error) int {
+	e, ok := err.(*updateError)
+	switch {
+	case !ok:
+		return exitError
+	case e.failed() < e.names:
+		return exitPartial
+	case e.ko > 0:
+		return exitKO
+	case e.network > 0:
+		return exitNetwork
+	case e.verify > 0:
+		return exitVerify
+	}
+	return exitError
+}
+
+// fatal logs at fatal level like logrus.Fatal, but exits with code
+func fatal(code int, err error, format string, args ...interface{}) {
+	// several problems read better one to a line
+	if p, ok := err.(configProblems); ok && len(p) > 1 {
+		for _, e := range p {
+			logrus.Error(e)
+		}
+		err = fmt.Errorf("%d configuration problems", len(p))
+	}
+	entry := logrus.NewEntry(logrus.StandardLogger())
+	if err != nil {
+		entry = entry.WithError(err)
+	}
+	entry.Logf(logrus.FatalLevel, format, args...)
+	logrus.Exit(code)
+}
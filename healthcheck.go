@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runHealthcheck exits non-zero unless the last recorded run succeeded within
+// maxAge, for use as a container HEALTHCHECK next to the daemon
+func runHealthcheck(update Update, maxAge time.Duration) {
+	if maxAge <= 0 {
+		maxAge = 3 * update.Interval
+	}
+
+	cache, err := loadCache(update.CacheFile)
+	if err != nil {
+		logrus.WithError(err).Fatalf("unable to read %s", update.CacheFile)
+	}
+
+	switch {
+	case cache.LastRun.IsZero():
+		logrus.Fatal("unhealthy: no update has run yet")
+	case time.Since(cache.LastRun) > maxAge:
+		logrus.Fatalf("unhealthy: last update ran %s ago",
+			time.Since(cache.LastRun).Round(time.Second))
+	case cache.LastError != "":
+		var failing []string
+		for name, d := range cache.Domains {
+			if d.Failures > 0 {
+				failing = append(failing, fmt.Sprintf("%s, %d run(s) in a row: %s",
+					name, d.Failures, d.LastError))
+			}
+		}
+		if len(failing) == 0 {
+			logrus.Fatalf("unhealthy: last update failed: %s", cache.LastError)
+		}
+		sort.Strings(failing)
+		logrus.Fatalf("unhealthy: %s", strings.Join(failing, "; "))
+	}
+
+	logrus.Debugf("healthy: last update succeeded at %s", cache.LastRun.Format(time.RFC3339))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/theag3nt/duckdns/pkg/duckdns"
+)
+
+// historyEntry is one line of the history file, describing a single request
+// to DuckDNS
+type historyEntry struct {
+	Time    time.Time `json:"time"`
+	Domain  string    `json:"domain"`
+	IP      string    `json:"ip,omitempty"`
+	IPv6    string    `json:"ipv6,omitempty"`
+	Result  string    `json:"result"`
+	Error   string    `json:"error,omitempty"`
+	Latency float64   `json:"latency_seconds"`
+}
+
+// recordHistory appends the outcome of one request to the history file, if
+// there is one. The file is JSON lines, so it can only ever grow and is easy
+// to feed to other tools.
+func recordHistory(path string, res duckdns.Result, took time.Duration, err error) {
+	if path == "" {
+		return
+	}
+	e := historyEntry{
+		Time:    time.Now(),
+		Domain:  res.Domain,
+		IP:      res.IP,
+		IPv6:    res.IPv6,
+		Result:  "unchanged",
+		Latency: took.Seconds(),
+	}
+	switch {
+	case isRejected(err):
+		e.Result = "failed"
+	case err != nil:
+		e.Result, e.Error = "error", redact(err.Error())
+	case res.Updated:
+		e.Result = "updated"
+	}
+
+	if err := appendHistory(path, e); err != nil &&
+		!warnReadOnly(path, err, "not recording history") {
+		logrus.WithError(err).Warnf("unable to write history file %s", path)
+	}
+}
+
+func appendHistory(path string, e historyEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runHistory prints the history file, optionally only for some names, entries
+// newer than since, or the requests that moved a name to a new address
+func runHistory(update Update, names []string, since time.Duration, changes bool) {
+	if update.HistoryFile == "" {
+		fatal(exitConfig, nil, "no history file configured, set --history-file")
+	}
+	f, err := os.Open(update.HistoryFile)
+	if err != nil {
+		fatal(exitError, err, "unable to read the history")
+	}
+	defer f.Close()
+
+	wanted := map[string]bool{}
+	for _, n := range names {
+		wanted[n] = true
+	}
+	var cutoff time.Time
+	if since > 0 {
+		cutoff = time.Now().Add(-since)
+	}
+	lastIP := map[string]string{}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tNAME\tIP\tRESULT\tLATENCY")
+
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		var e historyEntry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			logrus.WithError(err).Debugf("skipping line %d of %s", line, update.HistoryFile)
+			continue
+		}
+		if len(wanted) > 0 && !wanted[e.Domain] {
+			continue
+		}
+		if changes {
+			// compare against every earlier entry, not only the ones shown
+			if e.IP == "" || e.Result == "failed" || e.Result == "error" {
+				continue
+			}
+			previous, seen := lastIP[e.Domain]
+			lastIP[e.Domain] = e.IP
+			if seen && previous == e.IP {
+				continue
+			}
+		}
+		if e.Time.Before(cutoff) {
+			continue
+		}
+
+		ip := e.IP
+		if ip == "" {
+			ip = "-"
+		}
+		result := e.Result
+		if e.Error != "" {
+			result += ": " + e.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.Time.Local().Format(time.RFC3339),
+			e.Domain, ip, result, time.Duration(e.Latency*float64(time.Second)).Round(time.Millisecond))
+	}
+	w.Flush()
+	if err := s.Err(); err != nil {
+		fatal(exitError, err, "unable to read the history")
+	}
+}
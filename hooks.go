@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultHookTimeout is how long a hook may run unless configured otherwise
+const defaultHookTimeout = time.Minute
+
+// Hooks are shell commands run around updates, described by DUCKDNS_*
+// environment variables
+type Hooks struct {
+	// Pre runs before every update run; when it fails the run is skipped
+	Pre string `yaml:"pre"`
+	// OnChange runs for every name DuckDNS moved to a new address
+	OnChange string `yaml:"on_change"`
+	// OnFailure runs for every name that reached notify's failure_threshold
+	// failures in a row, once per streak
+	OnFailure string `yaml:"on_failure"`
+	// Timeout bounds each command, a minute by default
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// merge fills the settings left unset in h from o
+func (h *Hooks) merge(o Hooks) {
+	if h.Pre == "" {
+		h.Pre = o.Pre
+	}
+	if h.OnChange == "" {
+		h.OnChange = o.OnChange
+	}
+	if h.OnFailure == "" {
+		h.OnFailure = o.OnFailure
+	}
+	if h.Timeout == 0 {
+		h.Timeout = o.Timeout
+	}
+}
+
+// runPreHook runs the pre hook, if any, for the names about to be updated
+func runPreHook(ctx context.Context, update Update) error {
+	if update.Hooks.Pre == "" {
+		return nil
+	}
+	var names []string
+	for _, a := range update.accounts() {
+		names = append(names, a.Names...)
+	}
+	err := runHook(ctx, update.Hooks, "pre", update.Hooks.Pre, []string{
+		"DUCKDNS_DOMAINS=" + strings.Join(names, ","),
+	})
+	if err != nil {
+		return fmt.Errorf("pre hook: %v", err)
+	}
+	return nil
+}
+
+// runEventHooks runs on_change or on_failure for each event. Failures are
+// only logged, like those of notifications.
+func runEventHooks(ctx context.Context, hooks Hooks, events []notifyEvent) {
+	for _, ev := range events {
+		name, command := "on_change", hooks.OnChange
+		if ev.Result == eventFailed {
+			name, command = "on_failure", hooks.OnFailure
+		}
+		if command == "" {
+			continue
+		}
+		err := runHook(ctx, hooks, name, command, []string{
+			"DUCKDNS_DOMAIN=" + ev.Domain,
+			"DUCKDNS_OLD_IP=" + ev.OldIP,
+			"DUCKDNS_NEW_IP=" + ev.NewIP,
+			"DUCKDNS_RESULT=" + ev.Result,
+			"DUCKDNS_ERROR=" + ev.Error,
+			"DUCKDNS_FAILURES=" + strconv.Itoa(ev.Failures),
+		})
+		if err != nil {
+			logrus.WithError(err).Warnf("%s hook failed for %s", name, ev.Domain)
+		}
+	}
+}
+
+// runHook runs command through the shell with env added to ours, logging its
+// output at debug
+func runHook(ctx context.Context, hooks Hooks, name, command string, env []string) error {
+	timeout := hooks.Timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	cmd.Env = append(os.Environ(), env...)
+	logrus.Debugf("Running the %s hook: %s", name, command)
+	out, err := cmd.CombinedOutput()
+	if len(out) > 0 {
+		logrus.Debugf("%s hook output: %s", name, strings.TrimSpace(string(out)))
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	if err != nil && len(out) > 0 {
+		return fmt.Errorf("%v: %s", err, lastLine(out))
+	}
+	return err
+}
+
+// lastLine is the last non-empty line of out, usually the error message
+func lastLine(out []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultHotplugInterfaces are the OpenWrt interfaces whose events update the
+// names unless --wan says otherwise
+var defaultHotplugInterfaces = []string{"wan", "wan6"}
+
+// cgnat is the shared address space of carrier-grade NAT, RFC 6598
+var cgnat = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// runHotplug updates the names with the address a router script hands over,
+// right away and without detecting anything. It suits an OpenWrt hotplug.d
+// script or pppd's ip-up:
+//
+//	duckdns hotplug 203.0.113.5
+//	duckdns hotplug ppp0 /dev/ttyS0 115200 203.0.113.5 198.51.100.1
+//
+// Without arguments the address comes from DUCK_IP and DUCK_IPV6, pppd's
+// IPLOCAL or PPP_LOCAL, or for an OpenWrt hotplug event from ubus.
+func runHotplug(ctx context.Context, cli CLIOptions, update Update, args []string) {
+	if err := update.check(); err != nil {
+		fatal(exitConfig, err, "not updating")
+	}
+	// hotplug.d runs every script for every interface going up or down
+	if action := os.Getenv("ACTION"); action != "" && action != "ifup" && action != "ifupdate" {
+		logrus.Debugf("ignoring hotplug action %s", action)
+		return
+	}
+	iface := os.Getenv("INTERFACE")
+	if iface != "" && len(args) == 0 && !containsName(cli.HotplugInterfaces, iface) {
+		logrus.Debugf("ignoring hotplug event of interface %s", iface)
+		return
+	}
+
+	ip, ipv6, err := hotplugAddrs(ctx, args, iface)
+	if err != nil {
+		fatal(exitConfig, err, "no address to update with")
+	}
+	for _, a := range []string{ip, ipv6} {
+		if parsed := net.ParseIP(a); parsed != nil && !isPublicAddr(parsed) {
+			logrus.Warnf("%s is not a public address, the router is probably behind another NAT", a)
+		}
+	}
+	logrus.WithFields(logrus.Fields{"ip": ip, "ipv6": ipv6}).Info("Updating with the address from the router")
+
+	defer lock(update).Close()
+	update.ip, update.ipv6 = ip, ipv6
+	results, err := makeUpdate(ctx, update)
+	printOutput(cli, results)
+	if ctx.Err() != nil {
+		fatal(exitError, nil, "interrupted")
+	}
+	if err != nil {
+		fatal(exitCode(err), err, "error updating IP address")
+	}
+}
+
+// hotplugAddrs finds the addresses to send in args, the environment or ubus
+func hotplugAddrs(ctx context.Context, args []string, iface string) (ip, ipv6 string, err error) {
+	// pppd runs ip-up with the interface, tty, speed, local and remote
+	// addresses, and ipparam
+	if len(args) >= 4 && net.ParseIP(args[0]) == nil {
+		args = args[3:4]
+	}
+	if len(args) > 0 {
+		return splitAddrs(strings.Join(args, ","))
+	}
+	for _, keys := range [][]string{{"DUCK_IP", "DUCK_IPV6"}, {"IPLOCAL"}, {"PPP_LOCAL"}} {
+		var values []string
+		for _, k := range keys {
+			values = append(values, os.Getenv(k))
+		}
+		if list := strings.Join(values, ","); strings.Trim(list, ",") != "" {
+			return splitAddrs(list)
+		}
+	}
+	if iface != "" {
+		return ubusAddrs(ctx, iface)
+	}
+	return "", "", fmt.Errorf("give the address as an argument, or in DUCK_IP or DUCK_IPV6")
+}
+
+// ubusAddrs reads the addresses of an OpenWrt interface from netifd, as
+// hotplug events don't carry them
+func ubusAddrs(ctx context.Context, iface string) (ip, ipv6 string, err error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "ubus", "call", "network.interface."+iface, "status").Output()
+	if err != nil {
+		return "", "", fmt.Errorf("unable to ask ubus for the addresses of %s: %v", iface, err)
+	}
+	var status struct {
+		IPv4 []struct {
+			Address string `json:"address"`
+		} `json:"ipv4-address"`
+		IPv6 []struct {
+			Address string `json:"address"`
+		} `json:"ipv6-address"`
+	}
+	if err := json.Unmarshal(out, &status); err != nil {
+		return "", "", fmt.Errorf("unexpected ubus answer for %s: %v", iface, err)
+	}
+	if len(status.IPv4) > 0 {
+		ip = status.IPv4[0].Address
+	}
+	if len(status.IPv6) > 0 {
+		ipv6 = status.IPv6[0].Address
+	}
+	if ip == "" && ipv6 == "" {
+		return "", "", fmt.Errorf("interface %s has no address", iface)
+	}
+	return splitAddrs(ip + "," + ipv6)
+}
+
+// isPublicAddr reports whether ip can be reached from the internet, as far as
+// its range tells
+func isPublicAddr(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !cgnat.Contains(ip)
+}
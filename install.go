@@ -0,0 +1,387 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf16"
+
+	"github.com/sirupsen/logrus"
+)
+
+// unitParams fills in the generated service definitions
+type unitParams struct {
+	Binary   string
+	Config   string
+	Interval string
+	User     bool
+}
+
+var systemdService = template.Must(template.New("service").Parse(`[Unit]
+Description=DuckDNS updater
+Documentation=https://github.com/theag3nt/duckdns
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+{{- if .Timer}}
+Type=oneshot
+ExecStart={{.Binary}}{{if .Config}} -c {{.Config}}{{end}}{{if not .User}} --data-dir ${STATE_DIRECTORY}{{end}}
+{{- else}}
+Type=notify
+ExecStart={{.Binary}}{{if .Config}} -c {{.Config}}{{end}}{{if not .User}} --data-dir ${STATE_DIRECTORY}{{end}} daemon
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=on-failure
+RestartSec=30s
+WatchdogSec=15min
+{{- end}}
+{{- if not .User}}
+StateDirectory=duckdns
+CapabilityBoundingSet=
+AmbientCapabilities=
+NoNewPrivileges=yes
+ProtectSystem=strict
+ProtectHome=read-only
+PrivateTmp=yes
+PrivateDevices=yes
+ProtectKernelTunables=yes
+ProtectKernelModules=yes
+ProtectControlGroups=yes
+ProtectClock=yes
+ProtectHostname=yes
+RestrictAddressFamilies=AF_INET AF_INET6 AF_UNIX AF_NETLINK
+RestrictNamespaces=yes
+RestrictRealtime=yes
+LockPersonality=yes
+MemoryDenyWriteExecute=yes
+SystemCallArchitectures=native
+{{- else}}
+NoNewPrivileges=yes
+{{- end}}
+
+[Install]
+WantedBy={{if .User}}default.target{{else}}multi-user.target{{end}}
+`))
+
+var systemdTimer = template.Must(template.New("timer").Parse(`[Unit]
+Description=Run the DuckDNS updater every {{.Interval}}
+
+[Timer]
+OnBootSec=1min
+OnUnitActiveSec={{.Interval}}
+RandomizedDelaySec=30s
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`))
+
+const launchdLabel = "com.github.theag3nt.duckdns"
+
+var launchdAgent = template.Must(template.New("plist").Funcs(template.FuncMap{
+	"xml": xmlEscape,
+}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{.Label | xml}}</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>{{.Binary | xml}}</string>
+{{- if .Config}}
+		<string>-c</string>
+		<string>{{.Config | xml}}</string>
+{{- end}}
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>StartInterval</key>
+	<integer>{{.Seconds}}</integer>
+{{- if .NetworkChange}}
+	<key>WatchPaths</key>
+	<array>
+		<string>/Library/Preferences/SystemConfiguration</string>
+	</array>
+{{- end}}
+	<key>StandardOutPath</key>
+	<string>{{.Log | xml}}</string>
+	<key>StandardErrorPath</key>
+	<string>{{.Log | xml}}</string>
+</dict>
+</plist>
+`))
+
+// taskName is the name of the scheduled task on Windows
+const taskName = "duckdns"
+
+// scheduledTask runs one-shot updates every interval, at logon and when a
+// network connects (event 10000 of the NetworkProfile log), as whoever
+// installed it and only while they are logged on, so that it needs no admin
+var scheduledTask = template.Must(template.New("task").Funcs(template.FuncMap{
+	"xml": xmlEscape,
+}).Parse(`<?xml version="1.0" encoding="UTF-16"?>
+<Task version="1.2" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">
+  <RegistrationInfo>
+    <Description>Updates the DuckDNS names every {{.Interval}}</Description>
+    <URI>\{{.Name}}</URI>
+  </RegistrationInfo>
+  <Triggers>
+    <TimeTrigger>
+      <StartBoundary>{{.Start}}</StartBoundary>
+      <Repetition>
+        <Interval>PT{{.Minutes}}M</Interval>
+      </Repetition>
+      <Enabled>true</Enabled>
+    </TimeTrigger>
+    <LogonTrigger>
+      <UserId>{{.User | xml}}</UserId>
+      <Delay>PT30S</Delay>
+      <Enabled>true</Enabled>
+    </LogonTrigger>
+    <EventTrigger>
+      <Subscription>&lt;QueryList&gt;&lt;Query Id="0" Path="Microsoft-Windows-NetworkProfile/Operational"&gt;&lt;Select Path="Microsoft-Windows-NetworkProfile/Operational"&gt;*[System[EventID=10000]]&lt;/Select&gt;&lt;/Query&gt;&lt;/QueryList&gt;</Subscription>
+      <Delay>PT15S</Delay>
+      <Enabled>true</Enabled>
+    </EventTrigger>
+  </Triggers>
+  <Principals>
+    <Principal id="Author">
+      <UserId>{{.User | xml}}</UserId>
+      <LogonType>InteractiveToken</LogonType>
+      <RunLevel>LeastPrivilege</RunLevel>
+    </Principal>
+  </Principals>
+  <Settings>
+    <MultipleInstancesPolicy>IgnoreNew</MultipleInstancesPolicy>
+    <DisallowStartIfOnBatteries>false</DisallowStartIfOnBatteries>
+    <StopIfGoingOnBatteries>false</StopIfGoingOnBatteries>
+    <StartWhenAvailable>true</StartWhenAvailable>
+    <RunOnlyIfNetworkAvailable>true</RunOnlyIfNetworkAvailable>
+    <ExecutionTimeLimit>PT10M</ExecutionTimeLimit>
+    <Enabled>true</Enabled>
+  </Settings>
+  <Actions Context="Author">
+    <Exec>
+      <Command>{{.Binary | xml}}</Command>
+      <Arguments>{{.Arguments | xml}}</Arguments>
+    </Exec>
+  </Actions>
+</Task>
+`))
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// runInstall writes service definitions for target, pointing at this binary
+// and the config file in use
+func runInstall(update Update, cli CLIOptions, target string) {
+	bin, err := os.Executable()
+	if err == nil {
+		bin, err = filepath.EvalSymlinks(bin)
+	}
+	if err != nil {
+		logrus.WithError(err).Fatal("unable to find the duckdns binary")
+	}
+
+	params := unitParams{
+		Binary:   bin,
+		Interval: update.Interval.String(),
+		User:     cli.InstallUser,
+	}
+	if isRemoteConfig(update.configFile) {
+		params.Config = update.configFile
+	} else if update.configFile != "" {
+		if _, err := os.Stat(update.configFile); err == nil {
+			params.Config, _ = filepath.Abs(update.configFile)
+		}
+	}
+	if params.Config == "" {
+		logrus.Warn("no config file found, the service will need DUCK_* variables")
+	}
+
+	switch target {
+	case "systemd":
+		installSystemd(params, cli.InstallTimer)
+	case "launchd":
+		installLaunchd(params, update.Interval.Seconds(), cli.NetworkChange)
+	case "task":
+		var log string
+		if update.DataDir != "" {
+			log = filepath.Join(update.DataDir, "duckdns.log")
+		}
+		installTask(params, update.Interval, log)
+	default:
+		logrus.Fatalf("unknown install target %q, expected systemd, launchd or task", target)
+	}
+}
+
+func installSystemd(p unitParams, timer bool) {
+	dir := "/etc/systemd/system"
+	systemctl := "systemctl"
+	if p.User {
+		config, err := os.UserConfigDir()
+		if err != nil {
+			logrus.WithError(err).Fatal("unable to find the user config directory")
+		}
+		dir = filepath.Join(config, "systemd", "user")
+		systemctl = "systemctl --user"
+	}
+
+	units := map[string]*template.Template{"duckdns.service": systemdService}
+	enable := "duckdns.service"
+	if timer {
+		units["duckdns.timer"] = systemdTimer
+		enable = "duckdns.timer"
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logrus.WithError(err).Fatalf("unable to create %s", dir)
+	}
+	for name, t := range units {
+		var b strings.Builder
+		data := struct {
+			unitParams
+			Timer bool
+		}{p, timer}
+		if err := t.Execute(&b, data); err != nil {
+			logrus.WithError(err).Fatalf("unable to render %s", name)
+		}
+
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(b.String()), 0644); err != nil {
+			logrus.WithError(err).Fatalf("unable to write %s", path)
+		}
+		logrus.Infof("wrote %s", path)
+	}
+
+	fmt.Printf("Enable it with:\n  %s daemon-reload\n  %s enable --now %s\n",
+		systemctl, systemctl, enable)
+}
+
+// installLaunchd writes a LaunchAgent running one-shot updates every interval,
+// and optionally whenever the network configuration changes, then loads it
+func installLaunchd(p unitParams, seconds float64, networkChange bool) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		logrus.WithError(err).Fatal("unable to find the home directory")
+	}
+	dir := filepath.Join(home, "Library", "LaunchAgents")
+	path := filepath.Join(dir, launchdLabel+".plist")
+
+	var b strings.Builder
+	err = launchdAgent.Execute(&b, struct {
+		unitParams
+		Label         string
+		Seconds       int
+		NetworkChange bool
+		Log           string
+	}{p, launchdLabel, int(seconds), networkChange,
+		filepath.Join(home, "Library", "Logs", "duckdns.log")})
+	if err != nil {
+		logrus.WithError(err).Fatal("unable to render the launch agent")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logrus.WithError(err).Fatalf("unable to create %s", dir)
+	}
+	if err := ioutil.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		logrus.WithError(err).Fatalf("unable to write %s", path)
+	}
+	logrus.Infof("wrote %s", path)
+
+	// reload in case an older version of the agent is already loaded
+	exec.Command("launchctl", "unload", path).Run()
+	out, err := exec.Command("launchctl", "load", "-w", path).CombinedOutput()
+	if err != nil {
+		logrus.WithError(err).Fatalf("unable to load the launch agent: %s",
+			strings.TrimSpace(string(out)))
+	}
+	logrus.Infof("loaded launch agent %s", launchdLabel)
+}
+
+// installTask registers a scheduled task running one-shot updates, for
+// Windows users who can't or won't run the service. The task has no console to
+// write to, so the log goes to log.
+func installTask(p unitParams, interval time.Duration, log string) {
+	if runtime.GOOS != "windows" {
+		logrus.Fatal("scheduled tasks are only for Windows, use systemd or launchd")
+	}
+	u, err := user.Current()
+	if err != nil {
+		logrus.WithError(err).Fatal("unable to find the current user")
+	}
+	var args []string
+	if p.Config != "" {
+		args = append(args, "-c", quoteArg(p.Config))
+	}
+	if log != "" {
+		args = append(args, "--log-file", quoteArg(log))
+	}
+	minutes := int(math.Ceil(interval.Minutes()))
+	if minutes < 1 {
+		minutes = 1
+	}
+
+	var b strings.Builder
+	err = scheduledTask.Execute(&b, struct {
+		unitParams
+		Name      string
+		Start     string
+		Minutes   int
+		User      string
+		Arguments string
+	}{p, taskName, time.Now().Format("2006-01-02T15:04:05"), minutes, u.Username,
+		strings.Join(args, " ")})
+	if err != nil {
+		logrus.WithError(err).Fatal("unable to render the scheduled task")
+	}
+
+	// schtasks only reliably reads task XML as UTF-16 with a byte order mark
+	f, err := ioutil.TempFile("", "duckdns-task-*.xml")
+	if err != nil {
+		logrus.WithError(err).Fatal("unable to write the scheduled task")
+	}
+	defer os.Remove(f.Name())
+	data := []uint16{0xfeff}
+	data = append(data, utf16.Encode([]rune(b.String()))...)
+	err = binary.Write(f, binary.LittleEndian, data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		logrus.WithError(err).Fatal("unable to write the scheduled task")
+	}
+
+	out, err := exec.Command("schtasks", "/Create", "/TN", taskName, "/XML", f.Name(), "/F").CombinedOutput()
+	if err != nil {
+		logrus.WithError(err).Fatalf("unable to register the scheduled task: %s",
+			strings.TrimSpace(string(out)))
+	}
+	logrus.Infof("registered scheduled task %s, running every %dm, at logon and on network changes",
+		taskName, minutes)
+	fmt.Printf("Run it now with:\n  schtasks /Run /TN %s\nRemove it with:\n  schtasks /Delete /TN %s /F\n",
+		taskName, taskName)
+}
+
+// quoteArg quotes a path for a command line, Windows paths not being able to
+// hold quotes themselves
+func quoteArg(s string) string {
+	if strings.ContainsAny(s, " \t") {
+		return `"` + s + `"`
+	}
+	return s
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/theag3nt/duckdns/pkg/duckdns"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// defaultIPProviders is the detection chain used when none is configured
+var defaultIPProviders = []string{"ipify", "icanhazip"}
+
+// ipProvider looks up the public address of this machine
+type ipProvider interface {
+	// Name identifies the provider in logs
+	Name() string
+	// Detect returns the public IPv6 address when v6 is set, IPv4 otherwise
+	Detect(ctx context.Context, v6 bool) (net.IP, error)
+}
+
+// newIPProvider builds a provider from its config name. Anything starting
+// with http:// or https:// is a custom service returning the address as text.
+func newIPProvider(spec string, update Update, hc *http.Client) (ipProvider, error) {
+	switch spec {
+	case "ipify":
+		return &httpProvider{name: spec, hc: hc,
+			v4: "https://api.ipify.org", v6: "https://api6.ipify.org"}, nil
+	case "icanhazip":
+		return &httpProvider{name: spec, hc: hc,
+			v4: "https://ipv4.icanhazip.com", v6: "https://ipv6.icanhazip.com"}, nil
+	case "duckdns":
+		return &duckdnsProvider{update: update, hc: hc}, nil
+	case "upnp":
+		// a Transport of its own, as the default one honors HTTP_PROXY
+		return &upnpProvider{hc: &http.Client{
+			Timeout:   update.Timeout,
+			Transport: &http.Transport{Proxy: nil},
+		}}, nil
+	}
+	if spec == "stun" {
+		return &stunProvider{servers: defaultSTUNServers}, nil
+	}
+	if strings.HasPrefix(spec, "stun:") {
+		return &stunProvider{servers: []string{strings.TrimPrefix(spec, "stun:")}}, nil
+	}
+	if strings.HasPrefix(spec, "interface:") {
+		return &interfaceProvider{iface: strings.TrimPrefix(spec, "interface:")}, nil
+	}
+	if strings.HasPrefix(spec, "env:") {
+		return &envProvider{key: strings.TrimPrefix(spec, "env:")}, nil
+	}
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		return &httpProvider{name: spec, hc: hc, v4: spec, v6: spec}, nil
+	}
+	return nil, fmt.Errorf("unknown IP provider %q", spec)
+}
+
+// newIPProviders builds the configured detection chain. A configured network
+// interface replaces the chain, so no outside service is involved.
+func newIPProviders(update Update, hc *http.Client) ([]ipProvider, error) {
+	if update.Interface != "" {
+		return []ipProvider{&interfaceProvider{iface: update.Interface}}, nil
+	}
+
+	var providers []ipProvider
+	for _, spec := range update.IPProviders {
+		p, err := newIPProvider(spec, update, hc)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+	return providers, nil
+}
+
+// detectIP asks the providers, healthiest first, until quorum of them agree
+// on the address; with a quorum of 1 or less the first answer is taken
+func detectIP(ctx context.Context, providers []ipProvider, v6 bool, quorum int) (ip net.IP, err error) {
+	family := "ipv4"
+	if v6 {
+		family = "ipv6"
+	}
+	ctx, span := tracer.Start(ctx, "detect IP", trace.WithAttributes(attribute.String("net.family", family)))
+	defer func() {
+		if ip != nil {
+			span.SetAttributes(attribute.String("duckdns.ip", ip.String()))
+		}
+		endSpan(span, err)
+	}()
+
+	if quorum < 1 {
+		quorum = 1
+	}
+	var errs []string
+	var answers []string
+	votes := map[string][]string{}
+	for _, p := range byHealth(providers) {
+		start := time.Now()
+		ip, err := p.Detect(ctx, v6)
+		if err == nil && (ip.To4() != nil) != !v6 {
+			err = fmt.Errorf("returned %s for the wrong address family", ip)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		recordDetection(p.Name(), time.Since(start), err)
+		if err != nil {
+			logrus.WithError(err).Debugf("IP provider %s failed", p.Name())
+			errs = append(errs, fmt.Sprintf("%s: %v", p.Name(), err))
+			continue
+		}
+		logrus.Debugf("Detected public IP %s using %s in %s", ip, p.Name(),
+			time.Since(start).Round(time.Millisecond))
+		key := ip.String()
+		votes[key] = append(votes[key], p.Name())
+		answers = append(answers, fmt.Sprintf("%s says %s", p.Name(), key))
+		if len(votes[key]) >= quorum {
+			span.SetAttributes(attribute.String("duckdns.ip_provider", strings.Join(votes[key], ",")))
+			return ip, nil
+		}
+	}
+	switch {
+	case len(answers) > 0:
+		// not enough of them agreed, so no answer can be trusted
+		return nil, fmt.Errorf("IP providers don't agree, %d of them have to: %s",
+			quorum, strings.Join(append(answers, errs...), "; "))
+	case len(errs) == 0:
+		return nil, errors.New("no IP providers configured")
+	}
+	return nil, fmt.Errorf("all IP providers failed: %s", strings.Join(errs, "; "))
+}
+
+// httpProvider is a "what's my IP" service answering with the bare address
+type httpProvider struct {
+	name   string
+	v4, v6 string
+	hc     *http.Client
+}
+
+func (p *httpProvider) Name() string { return p.name }
+
+func (p *httpProvider) Detect(ctx context.Context, v6 bool) (net.IP, error) {
+	lookupURL := p.v4
+	if v6 {
+		lookupURL = p.v6
+	}
+
+	req, err := http.NewRequest(http.MethodGet, lookupURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := p.hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", lookupURL, res.Status)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		return nil, fmt.Errorf("%s returned an invalid address", lookupURL)
+	}
+	return ip, nil
+}
+
+// interfaceProvider reads the address assigned to a local network interface,
+// for hosts and routers that have the public address on the NIC
+type interfaceProvider struct {
+	iface string
+}
+
+func (p *interfaceProvider) Name() string { return "interface:" + p.iface }
+
+func (p *interfaceProvider) Detect(ctx context.Context, v6 bool) (net.IP, error) {
+	iface, err := net.InterfaceByName(p.iface)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	// prefer a public address, but a private one is better than nothing
+	var private net.IP
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || !ipnet.IP.IsGlobalUnicast() || (ipnet.IP.To4() != nil) == v6 {
+			continue
+		}
+		if !ipnet.IP.IsPrivate() {
+			return ipnet.IP, nil
+		}
+		if private == nil {
+			private = ipnet.IP
+		}
+	}
+	if private != nil {
+		logrus.Debugf("%s only has the private address %s", p.iface, private)
+		return private, nil
+	}
+
+	return nil, fmt.Errorf("no usable address on interface %s", p.iface)
+}
+
+// envProvider reads the address from an environment variable, such as one
+// the Kubernetes downward API fills with status.hostIP
+type envProvider struct {
+	key string
+}
+
+func (p *envProvider) Name() string { return "env:" + p.key }
+
+func (p *envProvider) Detect(ctx context.Context, v6 bool) (net.IP, error) {
+	v := os.Getenv(p.key)
+	if v == "" {
+		return nil, fmt.Errorf("%s is not set", p.key)
+	}
+	// the downward API gives every address of a dual-stack node, comma
+	// separated
+	for _, s := range strings.Split(v, ",") {
+		if ip := net.ParseIP(strings.TrimSpace(s)); ip != nil && (ip.To4() != nil) == !v6 {
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("%s holds no usable address", p.key)
+}
+
+// duckdnsProvider asks DuckDNS which address it sees by updating the first
+// configured name without an explicit IP, so it has that update as a side
+// effect
+type duckdnsProvider struct {
+	update Update
+	hc     *http.Client
+}
+
+func (p *duckdnsProvider) Name() string { return "duckdns" }
+
+func (p *duckdnsProvider) Detect(ctx context.Context, v6 bool) (net.IP, error) {
+	if v6 {
+		return nil, errors.New("only IPv4 can be detected through DuckDNS")
+	}
+	accounts := p.update.accounts()
+	if len(accounts) == 0 || len(accounts[0].Names) == 0 {
+		return nil, errors.New("no domains configured")
+	}
+
+	c := duckdns.NewClient(accounts[0].Token)
+	c.HTTPClient = p.hc
+	c.Endpoint = p.update.Endpoint
+	res, err := c.Update(ctx, accounts[0].Names[0], "")
+	if err != nil {
+		return nil, err
+	}
+
+	ip := net.ParseIP(res.IP)
+	if ip == nil {
+		return nil, fmt.Errorf("DuckDNS reported an invalid address %q", res.IP)
+	}
+	return ip, nil
+}
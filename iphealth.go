@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// providerStats is how an IP provider has fared since the process started
+type providerStats struct {
+	// failures counts the failures since the last answer
+	failures int
+	// latency is a moving average of the time taken by answers
+	latency time.Duration
+}
+
+// ipHealth keeps the stats of every IP provider across the daemon's runs, so
+// that a service that keeps failing stops being asked first
+var ipHealth = struct {
+	sync.Mutex
+	stats map[string]*providerStats
+}{stats: map[string]*providerStats{}}
+
+var (
+	metricProviderFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "duckdns",
+		Name:      "ip_provider_failures_total",
+		Help:      "Failed IP detections, by provider.",
+	}, []string{"provider"})
+	metricProviderLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "duckdns",
+		Name:      "ip_provider_latency_seconds",
+		Help:      "Moving average of the time taken by the IP provider to answer.",
+	}, []string{"provider"})
+)
+
+func init() {
+	prometheus.MustRegister(metricProviderFailures, metricProviderLatency)
+}
+
+// recordDetection updates the stats of the provider called name
+func recordDetection(name string, took time.Duration, err error) {
+	ipHealth.Lock()
+	defer ipHealth.Unlock()
+	s := ipHealth.stats[name]
+	if s == nil {
+		s = &providerStats{}
+		ipHealth.stats[name] = s
+	}
+	if err != nil {
+		s.failures++
+		metricProviderFailures.WithLabelValues(name).Inc()
+		return
+	}
+	s.failures = 0
+	if s.latency == 0 {
+		s.latency = took
+	} else {
+		s.latency = (3*s.latency + took) / 4
+	}
+	metricProviderLatency.WithLabelValues(name).Set(s.latency.Seconds())
+}
+
+// byHealth returns providers in the order to ask them: those that answered
+// last time in the configured order, then the failing ones, fewest failures
+// in a row first
+func byHealth(providers []ipProvider) []ipProvider {
+	ipHealth.Lock()
+	failures := make([]int, len(providers))
+	for i, p := range providers {
+		if s := ipHealth.stats[p.Name()]; s != nil {
+			failures[i] = s.failures
+		}
+	}
+	ipHealth.Unlock()
+
+	order := make([]int, len(providers))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return failures[order[i]] < failures[order[j]] })
+	sorted := make([]ipProvider, len(providers))
+	for i, o := range order {
+		sorted[i] = providers[o]
+	}
+	return sorted
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zalando/go-keyring"
+)
+
+// The token is stored in the macOS Keychain, Windows Credential Manager or
+// Secret Service under this service and user
+const (
+	keyringService = "duckdns"
+	keyringUser    = "token"
+)
+
+// keyringToken returns the stored token, or "" when there is none or no
+// keyring is available
+func keyringToken() string {
+	token, err := keyring.Get(keyringService, keyringUser)
+	if err != nil {
+		if err != keyring.ErrNotFound {
+			logrus.WithError(err).Debug("unable to read the OS keyring")
+		}
+		return ""
+	}
+	return token
+}
+
+// runToken manages the token stored in the OS keyring
+func runToken(verb string) {
+	switch verb {
+	case "set":
+		token, err := readTokenInput()
+		if err != nil {
+			fatal(exitError, err, "unable to read the token")
+		}
+		if !tokenPattern.MatchString(token) {
+			logrus.Warn("the token is not a DuckDNS token (expected a UUID), storing it anyway")
+		}
+		if err := keyring.Set(keyringService, keyringUser, token); err != nil {
+			fatal(exitError, err, "unable to store the token in the OS keyring")
+		}
+		logrus.Info("token stored in the OS keyring")
+	case "get":
+		token, err := keyring.Get(keyringService, keyringUser)
+		if err != nil {
+			fatal(exitError, err, "unable to read the token from the OS keyring")
+		}
+		fmt.Println(token)
+	case "delete":
+		if err := keyring.Delete(keyringService, keyringUser); err != nil {
+			fatal(exitError, err, "unable to delete the token from the OS keyring")
+		}
+		logrus.Info("token deleted from the OS keyring")
+	default:
+		fatal(exitConfig, nil, "unknown token command %q, expected set, get or delete", verb)
+	}
+}
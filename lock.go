@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// errLocked means another instance holds the lock file
+var errLocked = errors.New("another instance is running")
+
+// lock takes the configured lock file for the rest of the process, exiting if
+// another instance already holds it, so that overlapping cron runs or a second
+// daemon don't send the same updates twice. On a read-only filesystem it goes
+// on without the lock.
+func lock(update Update) *os.File {
+	if update.Lock == "" {
+		return nil
+	}
+	var f *os.File
+	err := os.MkdirAll(filepath.Dir(update.Lock), 0700)
+	if err == nil {
+		f, err = lockFile(update.Lock)
+	}
+	if err != nil && warnReadOnly(update.Lock, err, "running without the lock") {
+		return nil
+	}
+	if err != nil {
+		fatal(exitError, err, "unable to lock %s", update.Lock)
+	}
+	return f
+}
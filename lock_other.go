@@ -0,0 +1,24 @@
+//go:build !darwin && !dragonfly && !freebsd && !illumos && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!illumos,!linux,!netbsd,!openbsd,!windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// lockFile only writes the PID to path where there is no flock, such as on
+// Solaris or AIX, so overlapping runs are not kept apart there
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Debugf("not locking %s, file locks are not supported here", path)
+	f.Truncate(0)
+	fmt.Fprintf(f, "%d\n", os.Getpid())
+	return f, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"time"
+
+	"github.com/TV4/env"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	defaultLogMaxSize = 10 // megabytes
+	defaultLogMaxAge  = 7 * 24 * time.Hour
+)
+
+// setupLogging applies the logging flags. It runs before the config is
+// loaded so that everything after it is logged in the chosen format.
+func setupLogging(cli CLIOptions) {
+	// first, so that no other hook sees a token
+	logrus.AddHook(redactHook{})
+
+	level := cli.LogLevel
+	switch {
+	case level != "":
+	case cli.Debug:
+		level = "debug"
+	case cli.Quiet:
+		level = "error"
+	default:
+		level = env.String("DUCK_LOG_LEVEL", "info")
+	}
+	switch level {
+	case "trace", "debug", "info", "warn", "error":
+		l, _ := logrus.ParseLevel(level)
+		logrus.SetLevel(l)
+	default:
+		fatal(exitConfig, nil, "unknown log level %q, expected trace, debug, info, warn or error", level)
+	}
+
+	format := cli.LogFormat
+	if format == "" {
+		format = env.String("DUCK_LOG_FORMAT", "text")
+	}
+	switch format {
+	case "text":
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		fatal(exitConfig, nil, "unknown log format %q, expected text or json", format)
+	}
+
+	file := cli.LogFile
+	if file == "" {
+		file = env.String("DUCK_LOG_FILE", "")
+	}
+	if file != "" {
+		logrus.SetOutput(newLogFile(file, cli.LogMaxSize, cli.LogMaxAge))
+	}
+
+	syslog := cli.LogSyslog
+	if syslog == "" {
+		syslog = env.String("DUCK_LOG_SYSLOG", "")
+	}
+	if syslog != "" {
+		facility := cli.LogSyslogFacility
+		if facility == "" {
+			facility = env.String("DUCK_LOG_SYSLOG_FACILITY", "daemon")
+		}
+		tag := cli.LogSyslogTag
+		if tag == "" {
+			tag = env.String("DUCK_LOG_SYSLOG_TAG", "duckdns")
+		}
+		if err := addSyslogHook(syslog, facility, tag); err != nil {
+			fatal(exitConfig, err, "unable to log to syslog")
+		}
+	}
+
+	logrus.Debugf("Logging level: %s", logrus.GetLevel().String())
+}
+
+// newLogFile returns a writer appending to path that starts a new file once
+// the current one reaches maxSize megabytes, and removes files older than
+// maxAge
+func newLogFile(path string, maxSize int, maxAge time.Duration) *lumberjack.Logger {
+	if maxSize <= 0 {
+		maxSize = envInt("DUCK_LOG_MAX_SIZE")
+	}
+	if maxSize <= 0 {
+		maxSize = defaultLogMaxSize
+	}
+	if maxAge <= 0 {
+		maxAge = envDuration("DUCK_LOG_MAX_AGE")
+	}
+	if maxAge <= 0 {
+		maxAge = defaultLogMaxAge
+	}
+
+	// lumberjack counts age in whole days
+	days := int((maxAge + 24*time.Hour - 1) / (24 * time.Hour))
+
+	return &lumberjack.Logger{
+		Filename: path,
+		MaxSize:  maxSize,
+		MaxAge:   days,
+		Compress: true,
+	}
+}
@@ -1,188 +1,864 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"net"
+	"net/url"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/TV4/env"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
-	yaml "gopkg.in/yaml.v2"
+	"github.com/theag3nt/duckdns/pkg/duckdns"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// Update contains everything that DuckDNS will need to update a record
-type Update struct {
-	Token string   `yaml:"token"`
-	Names []string `yaml:"domains"`
-}
-
 // CLIOptions are to set things via CLI
 type CLIOptions struct {
-	Debug bool
-	File  string
-	Token string
-	Names []string
+	Version           bool
+	Debug             bool
+	LogLevel          string
+	Quiet             bool
+	LogFormat         string
+	LogFile           string
+	LogMaxSize        int
+	LogMaxAge         time.Duration
+	LogSyslog         string
+	LogSyslogFacility string
+	LogSyslogTag      string
+	File              string
+	ConfigFormat      string
+	ConfigKey         string
+	Profile           string
+	Token             string
+	TokenFile         string
+	TokenStdin        bool
+	Names             []string
+	NamesFile         string
+	TXT               string
+	ACMEHook          bool
+	DryRun            bool
+	Output            string
+	Endpoint          string
+	ConnectTimeout    time.Duration
+	Timeout           time.Duration
+	Proxy             string
+	TLS               TLSConfig
+	ForceIPv4         bool
+	ForceIPv6         bool
+	DualStack         bool
+	SourceIP          string
+	BindInterface     string
+	RetryAttempts     int
+	RetryDelay        time.Duration
+	RetryFailedAfter  time.Duration
+	OfflineRetry      time.Duration
+	Concurrency       int
+	RateLimit         int
+	DetectIP          bool
+	IPProviders       []string
+	IPQuorum          int
+	Interface         string
+	DataDir           string
+	CacheFile         string
+	Lock              string
+	HistoryFile       string
+	RefreshInterval   time.Duration
+	Daemon            bool
+	Interval          time.Duration
+	Schedule          string
+	Jitter            time.Duration
+	StartupDelay      time.Duration
+	Verify            bool
+	VerifyResolver    string
+	VerifyTimeout     time.Duration
+	InstallUser       bool
+	InstallTimer      bool
+	NetworkChange     bool
+	MaxAge            time.Duration
+	Since             time.Duration
+	Changes           bool
+	UpdateCheck       bool
+	UpdateForce       bool
+	ReleasesURL       string
+	MockAddr          string
+	MockAcceptAny     bool
+	DyndnsAddr        string
+	HandlerAddr       string
+	HotplugInterfaces []string
+	MigrateFrom       string
+	Cert              CertConfig
+	CertStaging       bool
+	CertForce         bool
+	Listen            string
+	APIToken          string
+	Watch             bool
+	WatchAddresses    bool
+	NotifyWebhooks    []string
+	PingURL           string
+	OTLPEndpoint      string
 }
 
-// Valid checks that all parameters are set for an update
-func (u *Update) Valid() bool {
-	if len(u.Names) > 0 && u.Token != "" {
-		return true
+func resultFields(r duckdns.Result) logrus.Fields {
+	f := logrus.Fields{
+		"domain": r.Domain,
+		"result": r.Status(),
+	}
+	if r.TXT != "" {
+		f["txt"] = r.TXT
+	} else {
+		f["ip"] = r.IP
+		f["ipv6"] = r.IPv6
 	}
-	return false
+	return f
 }
 
-// GetConfigCLI sets the arguments for an update if they have been passed in on
-// the CLI
-func getConfigCLI(c CLIOptions) Update {
-	var u Update
+// errUnchanged marks a name that was skipped because the detected address is
+// the one last sent
+var errUnchanged = errors.New("address unchanged since the last update")
+
+func makeUpdate(ctx context.Context, update Update) (results []Result, err error) {
+	ping(update, "/start", "")
+	defer func() {
+		if ctx.Err() == nil {
+			pingFinished(update, err)
+		}
+	}()
+	if err := runPreHook(ctx, update); err != nil {
+		return nil, err
+	}
+
+	cache, err := loadCache(update.CacheFile)
+	if err != nil {
+		logrus.WithError(err).Warnf("ignoring unreadable cache file %s", update.CacheFile)
+	}
+
+	fixed := update.fixedAddrs()
+	var ip, ipv6 string
+	if update.ip != "" || update.ipv6 != "" {
+		ip, ipv6 = update.ip, update.ipv6
+	} else if update.DetectIP && len(fixed) < update.nameCount() {
+		hc, err := newHTTPClient(update)
+		if err != nil {
+			return nil, err
+		}
+		providers, err := newIPProviders(update, hc)
+		if err != nil {
+			return nil, err
+		}
+		detected, err := detectIP(ctx, providers, false, update.IPQuorum)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			logrus.WithError(err).Warn("unable to detect public IP, letting DuckDNS decide")
+		} else {
+			ip = detected.String()
+		}
+		if update.DualStack {
+			detected, err := detectIP(ctx, providers, true, update.IPQuorum)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if err != nil {
+				logrus.WithError(err).Warn("unable to detect public IPv6 address, leaving the AAAA records alone")
+			} else {
+				ipv6 = detected.String()
+			}
+		}
+	}
+
+	results, err = updateNames(ctx, update, func(ctx context.Context, p dnsProvider, name string) (duckdns.Result, error) {
+		ip, ipv6 := ip, ipv6
+		if f, ok := fixed[name]; ok {
+			ip, ipv6 = f.ip, f.ipv6
+		}
+		if cache.unchanged(name, ip, ipv6, update.RefreshInterval) {
+			return duckdns.Result{Domain: name, OK: true, IP: ip, IPv6: ipv6}, errUnchanged
+		}
+		return p.Update(ctx, name, ip, ipv6)
+	})
+	// an interrupted run says nothing about the names, so it is neither
+	// recorded nor reported
+	if ctx.Err() != nil {
+		return results, ctx.Err()
+	}
+
+	if e, ok := err.(*updateError); ok {
+		for name, msg := range e.failures {
+			cache.failed(name, msg)
+		}
+	}
+	events := notifyEvents(cache, results, err, update.Notify.threshold())
+	for _, r := range results {
+		switch {
+		case r.Status == resultSkipped:
+			cache.succeeded(r.Domain)
+		case !r.accepted():
+		case r.IP != "":
+			cache.set(r.Domain, r.IP, r.IPv6)
+		default:
+			cache.succeeded(r.Domain)
+		}
+	}
+	cache.finished(err)
+	if err := cache.save(); err != nil {
+		logrus.WithError(err).Warnf("unable to save cache file %s", update.CacheFile)
+	}
+	notify(ctx, update, events)
+	emitMetrics(update, results)
 
-	u.Token = c.Token
-	logrus.Debugf("Set token from CLI to %s", c.Token)
-	u.Names = c.Names
-	logrus.Debugf("Set names from CLI to %s", strings.Join(c.Names, ", "))
+	return results, err
+}
 
-	return u
+// makeTXTUpdate sets the TXT record of every configured name to txt
+func makeTXTUpdate(ctx context.Context, update Update, txt string) ([]Result, error) {
+	return updateNames(ctx, update, func(ctx context.Context, p dnsProvider, name string) (duckdns.Result, error) {
+		if !p.SupportsTXT() {
+			return duckdns.Result{Domain: name}, fmt.Errorf("%s can't set TXT records", p.Name())
+		}
+		return p.UpdateTXT(ctx, name, txt)
+	})
 }
 
-// GetConfigFile reads the config for DuckDNS
-func getConfigFile(existing *Update, file string) {
+// makeClear removes the addresses of every configured name
+func makeClear(ctx context.Context, update Update) ([]Result, error) {
+	results, err := updateNames(ctx, update, func(ctx context.Context, p dnsProvider, name string) (duckdns.Result, error) {
+		return p.Clear(ctx, name)
+	})
+
+	// forget what was sent, so that the next update sends it again
+	cache, cacheErr := loadCache(update.CacheFile)
+	if cacheErr != nil {
+		logrus.WithError(cacheErr).Warnf("ignoring unreadable cache file %s", update.CacheFile)
+	}
+	for _, r := range results {
+		if r.OK {
+			delete(cache.Domains, r.Domain)
+		}
+	}
+	if err := cache.save(); err != nil {
+		logrus.WithError(err).Warnf("unable to save cache file %s", update.CacheFile)
+	}
 
-	var update Update
+	return results, err
+}
 
-	yamlFile, err := ioutil.ReadFile(file)
+// newClient returns a DuckDNS client set up from the merged configuration
+func newClient(update Update) (*duckdns.Client, error) {
+	hc, err := newHTTPClient(update)
 	if err != nil {
-		logrus.WithError(err).Debug("error reading file")
-		return
+		return nil, err
+	}
+	client := duckdns.NewClient(update.Token)
+	client.HTTPClient = hc
+	client.Endpoint = update.Endpoint
+	client.UserAgent = userAgent()
+	client.Retry = duckdns.RetryPolicy{
+		MaxAttempts:  update.RetryAttempts,
+		InitialDelay: update.RetryDelay,
+		MaxDelay:     maxRetryDelay,
+	}
+	client.OnRetry = func(domain string, attempt int, wait time.Duration, err error) {
+		logrus.WithError(err).Warnf("attempt %d for %s failed, retrying in %s",
+			attempt, domain, wait)
 	}
-	err = yaml.Unmarshal(yamlFile, &update)
+	client.Limiter = rateLimiter(update.RateLimit, update.Concurrency)
+
+	return client, nil
+}
+
+var (
+	limiterMu    sync.Mutex
+	limiter      *duckdns.RateLimiter
+	limiterRate  int
+	limiterBurst int
+)
+
+// rateLimiter returns the limiter shared by every run of the process, so that
+// the daemon keeps to the rate across runs, and nil when unlimited
+func rateLimiter(perMinute, burst int) *duckdns.RateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	limiterMu.Lock()
+	defer limiterMu.Unlock()
+	if limiter == nil || limiterRate != perMinute || limiterBurst != burst {
+		limiter = duckdns.NewRateLimiter(perMinute, burst)
+		limiterRate, limiterBurst = perMinute, burst
+	}
+	return limiter
+}
+
+// dryRun prints the requests that an update would send, with the token
+// redacted, without contacting DuckDNS. requestURL builds the request for a
+// name.
+func dryRun(update Update, requestURL func(p dnsProvider, name string) (string, error)) error {
+	logrus.Debugf("Dumping update params: %#v", update)
+	if err := update.check(); err != nil {
+		fatal(exitConfig, err, "nothing was sent")
+	}
+	client, err := newClient(update)
 	if err != nil {
-		logrus.WithError(err).Debug("error unmarshaling YAML file")
-		return
+		return err
 	}
 
-	// Set the token if it's not empty and doesn't already exist
-	if update.Token == "" {
-		logrus.Debugf("the token is empty after trying to parse %s", file)
-	} else if existing.Token == "" {
-		existing.Token = update.Token
+	logrus.Infof("timeouts: connect %s, request %s; attempts: %d, first retry after %s",
+		update.ConnectTimeout, update.Timeout, update.RetryAttempts, update.RetryDelay)
+	if update.Proxy != "" {
+		logrus.Infof("proxy: %s", redactURL(update.Proxy))
+	}
+	if update.SourceIP != "" {
+		logrus.Infof("source address: %s", update.SourceIP)
+	}
+	if update.BindInterface != "" {
+		logrus.Infof("interface: %s", update.BindInterface)
+	}
+	if network := endpointNetwork(update); network != "tcp" {
+		logrus.Infof("connecting to %s over %s only", endpointHost(update.Endpoint), network)
 	}
 
-	// Set names to if they exist and value is not already set
-	if len(update.Names) == 0 {
-		logrus.Debugf("no names/subdomains specified to update from %s", file)
-	} else if len(existing.Names) == 0 {
-		existing.Names = update.Names
+	for _, a := range update.accounts() {
+		p, err := newDNSProvider(a, client)
+		if err != nil {
+			return err
+		}
+		for _, v := range a.Names {
+			u, err := requestURL(p, v)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("GET %s\n", redact(u))
+		}
 	}
 
+	return nil
 }
 
-// GetConfigEnv is for reading items out of the environment if you didn't want
-// to set them on the CLI
-func getConfigEnv(u *Update) {
-	token := env.String("DUCK_TOKEN", "")
-	name := env.String("DUCK_NAMES", "")
+// redactURL hides any password in a URL such as a proxy address
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	return u.Redacted()
+}
+
+// nameUpdate is the update of a single name, run by one of the workers
+type nameUpdate struct {
+	provider dnsProvider
+	name     string
+
+	res duckdns.Result
+	err error
+	// cause is the error from the service, err the one reported
+	cause error
+	took  time.Duration
+	// kind says how the update went, and so how err is counted
+	kind int
+}
+
+const (
+	updateOK = iota
+	updateSkipped
+	updateKO
+	updateNetwork
+	updateVerify
+)
+
+// updateNames sends the update of every configured name through send and
+// returns their results in configuration order. Names not started when ctx is
+// cancelled fail with its error.
+func updateNames(ctx context.Context, update Update,
+	send func(context.Context, dnsProvider, string) (duckdns.Result, error)) ([]Result, error) {
 
-	// Set the token if not already set
-	if u.Token == "" {
-		u.Token = token
-		logrus.Debugf("Set token from environment to %s", token)
+	logrus.Debugf("Dumping update params: %#v", update)
+	if err := update.check(); err != nil {
+		fatal(exitConfig, err, "nothing was sent")
+	}
+	client, err := newClient(update)
+	if err != nil {
+		return nil, err
+	}
+	resolver, err := newResolver(update)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(u.Names) == 0 && name != "" {
-		// support DUCK_NAME="domain1 domain2" from the environment
-		u.Names = strings.Split(name, " ")
+	var jobs []*nameUpdate
+	for _, a := range update.accounts() {
+		p, err := newDNSProvider(a, client)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range a.Names {
+			jobs = append(jobs, &nameUpdate{provider: p, name: v})
+		}
+	}
+
+	forEach(len(jobs), update.Concurrency, func(i int) {
+		jobs[i].run(ctx, update, send, resolver)
+	})
+	retryFailed(ctx, update, jobs, send, resolver)
+	diagnoseRejections(jobs)
+
+	// tally in configuration order, so messages don't depend on timing
+	errs := &updateError{}
+	var results []Result
+	for _, j := range jobs {
+		errs.names++
+		switch j.kind {
+		case updateKO:
+			errs.ko++
+			errs.fail(j.name, j.err)
+		case updateNetwork:
+			errs.network++
+			errs.fail(j.name, j.err)
+		case updateVerify:
+			errs.verify++
+			errs.fail(j.name, j.err)
+		}
+		results = append(results, newResult(j))
+	}
 
-		logrus.Debugf("Set names from environment to %s",
-			strings.Join(u.Names, ", "))
+	if errs.failed() != 0 {
+		return results, errs
 	}
+
+	return results, nil
 }
 
-func makeUpdate(update Update) error {
-	logrus.Debugf("Dumping update params: %#v", update)
-	if !update.Valid() {
-		logrus.Fatal("Arguments not set for update!")
-		os.Exit(1)
+// run sends the update for one name and verifies it if configured
+func (j *nameUpdate) run(ctx context.Context, update Update,
+	send func(context.Context, dnsProvider, string) (duckdns.Result, error), resolver *net.Resolver) {
+
+	v := j.name
+	if err := ctx.Err(); err != nil {
+		j.kind, j.err = updateNetwork, err
+		return
+	}
+	ctx, span := tracer.Start(ctx, "update "+v, trace.WithAttributes(
+		attribute.String("duckdns.domain", v),
+		attribute.String("duckdns.provider", j.provider.Name()),
+	))
+	defer func() {
+		span.SetAttributes(attribute.String("duckdns.result", newResult(j).Status))
+		err := j.err
+		if err == nil {
+			err = j.cause
+		}
+		endSpan(span, err)
+	}()
+	logrus.Debugf("Updating %s for name %s", j.provider.Name(), v)
+	start := time.Now()
+	res, err := send(ctx, j.provider, v)
+	j.res = res
+	if err == errUnchanged {
+		j.kind = updateSkipped
+		logrus.WithFields(resultFields(res)).Debugf(
+			"skipping %s, address unchanged since the last update", v)
+		return
+	}
+	took := time.Since(start)
+	j.took = took
+	observeUpdate(v, res.Updated, took, err)
+	recordHistory(update.HistoryFile, res, took, err)
+	if isRejected(err) {
+		// the reason is filled in once every name has an answer
+		j.kind, j.cause = updateKO, err
+		return
+	}
+	if err != nil && ctx.Err() != nil {
+		j.kind, j.err = updateNetwork, err
+		logrus.Debugf("update of %s interrupted", v)
+		return
+	}
+	if err != nil {
+		j.kind, j.err = updateNetwork, err
+		entry := logrus.WithError(err).WithFields(logrus.Fields{
+			"domain":   v,
+			"duration": took.Seconds(),
+		})
+		if errors.Is(err, duckdns.ErrUnavailable) {
+			entry.Errorf("%s is throttling or unavailable", j.provider.Name())
+			return
+		}
+		entry.Errorf("Error contacting %s server", j.provider.Name())
+		return
 	}
-	var errs []string
-	stub := "https://www.duckdns.org/update?domains="
-	tokenStub := "&token="
-	ipStub := "&ip="
 
-	for _, v := range update.Names {
+	logrus.WithFields(resultFields(res)).WithField("duration", took.Seconds()).Infof(
+		"updated %s for name %s", j.provider.Name(), v)
 
-		url := fmt.Sprintf("%s%s%s%s%s", stub, v, tokenStub, update.Token, ipStub)
-		logrus.Debugf("Update string: %s", url)
-		res, err := http.Get(url)
+	if update.Verify {
+		err := verifyResult(ctx, resolver, res,
+			update.VerifyTimeout, update.VerifyInterval)
 		if err != nil {
-			errs = append(errs, err.Error())
-			logrus.WithError(err).Error("Error contacting DuckDNS server")
-			continue
+			j.kind, j.err = updateVerify, fmt.Errorf("Verification failed for %s: %v", v, err)
+			logrus.WithError(err).Errorf(
+				"DuckDNS accepted the update for %s but it has not propagated", v)
+			return
 		}
+		logrus.Debugf("verified DNS record for name %s", v)
+	}
+}
 
-		bodyBytes, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			errs = append(errs, err.Error())
-			logrus.WithError(err).Error("Error reading body response")
-			continue
+// retryFailed gives the names that failed one more try after
+// RetryFailedAfter, so that one flaky name doesn't fail the whole run. A KO
+// is left alone, the answer won't change.
+func retryFailed(ctx context.Context, update Update, jobs []*nameUpdate,
+	send func(context.Context, dnsProvider, string) (duckdns.Result, error), resolver *net.Resolver) {
+
+	if update.RetryFailedAfter <= 0 {
+		return
+	}
+	var failed []*nameUpdate
+	for _, j := range jobs {
+		if j.kind == updateNetwork || j.kind == updateVerify {
+			failed = append(failed, j)
 		}
-		res.Body.Close()
+	}
+	if len(failed) == 0 {
+		return
+	}
+
+	logrus.Infof("Trying %d failed name(s) again in %s", len(failed), update.RetryFailedAfter)
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(update.RetryFailedAfter):
+	}
+	forEach(len(failed), update.Concurrency, func(i int) {
+		j := failed[i]
+		*j = nameUpdate{provider: j.provider, name: j.name}
+		j.run(ctx, update, send, resolver)
+	})
+}
 
-		if strings.Contains(string(bodyBytes), "KO") {
-			errs = append(errs, fmt.Sprintf("Error updating %s with DuckDNS", v))
+// diagnoseRejections explains why names were rejected. DuckDNS answers a bare
+// KO both for a bad token and for a name outside the account, so the other
+// names of the same account are what tell the two apart.
+func diagnoseRejections(jobs []*nameUpdate) {
+	accepted := map[dnsProvider]bool{}
+	rejected := map[dnsProvider]int{}
+	names := map[dnsProvider]int{}
+	for _, j := range jobs {
+		names[j.provider]++
+		switch j.kind {
+		case updateOK, updateVerify:
+			accepted[j.provider] = true
+		case updateKO:
+			rejected[j.provider]++
+		}
+	}
+
+	for _, j := range jobs {
+		if j.kind != updateKO {
+			continue
+		}
+		d, ok := j.provider.(*duckdnsDNSProvider)
+		if !ok {
+			// dyndns2 services say why themselves
+			j.err = fmt.Errorf("Error updating %s: %v", j.name, j.cause)
 			continue
 		}
 
-		logrus.Debugf("updated DuckDNS for name %s", v)
+		var why string
+		switch {
+		case !tokenPattern.MatchString(d.Token):
+			why = "the token is not a DuckDNS token, which look like a UUID"
+		case !namePattern.MatchString(strings.TrimSuffix(j.name, duckDNSZone)):
+			why = fmt.Sprintf("%q is not a valid DuckDNS name", j.name)
+		case accepted[j.provider]:
+			why = fmt.Sprintf("the token works for other names, so %s is probably not "+
+				"one of its domains", j.name)
+		case names[j.provider] > 1 && rejected[j.provider] == names[j.provider]:
+			why = fmt.Sprintf("none of the account's %d names were accepted, so the token "+
+				"is probably wrong or was regenerated", names[j.provider])
+		default:
+			why = fmt.Sprintf("either the token is wrong or %s is not one of its domains", j.name)
+		}
+		j.err = fmt.Errorf("Error updating %s with DuckDNS: %s", j.name, why)
+		logrus.Debugf("DuckDNS answered KO for %s", j.name)
+	}
+}
+
+// forEach calls f for every index below n, from at most workers goroutines
+func forEach(n, workers int, f func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				f(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
 
+// loadConfig merges the CLI, environment and config file, in that order of
+// preference, and fills in defaults for anything left unset
+func loadConfig(ctx context.Context, cli CLIOptions) Update {
+	if cli.File == "-" && cli.TokenStdin {
+		fatal(exitConfig, nil, "--config - and --token-stdin can't both read stdin")
 	}
 
-	if len(errs) != 0 {
-		return errors.New(strings.Join(errs, "\n"))
+	// CLI vars
+	update := getConfigCLI(cli)
+
+	// Set things that weren't set by the CLI
+	getConfigEnv(&update)
+
+	// File vars
+	file := findConfigFile(cli.File, pflag.CommandLine.Changed("config"))
+	profile := cli.Profile
+	if profile == "" {
+		profile = env.String("DUCK_PROFILE", "")
+	}
+	key := cli.ConfigKey
+	if key == "" {
+		key = env.String("DUCK_CONFIG_KEY", "")
+	}
+	getConfigFile(ctx, &update, file, cli.ConfigFormat, profile, key,
+		pflag.CommandLine.Changed("config"))
+	update.configFile = file
+
+	// Last resort for the token, stored with `duckdns token set`
+	if update.Token == "" && len(update.Names) > 0 {
+		if update.Token = keyringToken(); update.Token != "" {
+			logrus.Debug("Using the token from the OS keyring")
+		} else if stdinIsTerminal() {
+			token, err := readTokenInput()
+			if err != nil {
+				fatal(exitConfig, err, "unable to read the token")
+			}
+			update.Token = token
+		}
 	}
 
-	return nil
+	update.setDefaults()
+	update.normalizeTokens()
+	update.normalizeNames()
+	update.registerSecrets()
+
+	return update
 }
 
 func main() {
 	var cli CLIOptions
 
-	pflag.BoolVarP(&cli.Debug, "debug", "d", false, "Use debug mode")
-	pflag.StringVarP(&cli.File, "config", "c", "duckdns.yaml",
-		"Config file location")
-	pflag.StringSliceVarP(&cli.Names, "names", "n", nil,
-		"Names to update with DuckDNS. Just the subdomain section. "+
-			"Use the flag multiple times to set multiple values.")
-	pflag.StringVarP(&cli.Token, "token", "t", "",
-		"Token for updating DuckDNS")
-
+	// flags before the command apply to every command, and stop at the first
+	// argument so that commands can have flags of their own
+	addGlobalFlags(pflag.CommandLine, &cli)
+	pflag.CommandLine.SetInterspersed(false)
+	pflag.Usage = usage
 	pflag.Parse()
 
-	if cli.Debug {
-		logrus.SetLevel(logrus.DebugLevel)
+	cmd, args := findCommand(pflag.Args())
+	fs := pflag.NewFlagSet("duckdns "+cmd.name, pflag.ExitOnError)
+	fs.AddFlagSet(pflag.CommandLine)
+	if cmd.flags != nil {
+		cmd.flags(fs, &cli)
 	}
-	logrus.Debugf("Logging level: %s", logrus.GetLevel().String())
+	fs.Usage = func() { commandUsage(cmd, fs) }
+	fs.Parse(args)
 
-	// CLI vars
-	update := getConfigCLI(cli)
-
-	// Set things that weren't set by the CLI
-	if !update.Valid() {
-		getConfigEnv(&update)
+	if cli.Version {
+		printVersion()
+		return
 	}
-
-	// File vars
-	if !update.Valid() {
-		getConfigFile(&update, cli.File)
+	setupLogging(cli)
+	if !validOutput(cli.Output) {
+		fatal(exitConfig, nil, "--output must be json, table or plain")
 	}
+	// the first SIGINT or SIGTERM cancels what is in flight, a second one
+	// kills the process as usual
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if cmd.standalone {
+		cmd.run(ctx, cli, Update{}, fs.Args())
+		return
+	}
+
+	start := time.Now()
+	update := loadConfig(ctx, cli)
+	ctx, flush := startTracing(ctx, update, cmd.name, start, time.Now())
+	defer flush()
 
-	if err := makeUpdate(update); err != nil {
-		logrus.WithError(err).Fatal("error updating IP address")
-		os.Exit(1)
+	if isWindowsService() {
+		defer lock(update).Close()
+		runWindowsService(update)
+		return
+	}
+	// the bootstrap of a Lambda function has no command to run
+	if api := os.Getenv("AWS_LAMBDA_RUNTIME_API"); api != "" {
+		runLambda(ctx, update, api)
+		return
 	}
-	logrus.Debug("IP address updated successfully")
+
+	cmd.run(ctx, cli, update, fs.Args())
+}
+
+// addGlobalFlags registers the flags shared by every command, most of which
+// override a setting of the config file
+func addGlobalFlags(fs *pflag.FlagSet, cli *CLIOptions) {
+	fs.BoolVar(&cli.Version, "version", false, "Print the version and exit")
+	fs.StringVar(&cli.LogLevel, "log-level", "",
+		"Log level: trace, debug, info, warn or error (default info)")
+	fs.BoolVarP(&cli.Debug, "debug", "d", false, "Same as --log-level debug")
+	fs.BoolVarP(&cli.Quiet, "quiet", "q", false,
+		"Only log failures, same as --log-level error")
+	fs.StringVar(&cli.LogFormat, "log-format", "",
+		"Log output format, text or json (default text)")
+	fs.StringVar(&cli.LogFile, "log-file", "",
+		"Write logs to this file instead of stderr, rotating it as it grows")
+	fs.IntVar(&cli.LogMaxSize, "log-max-size", 0,
+		"Size in megabytes at which --log-file is rotated (default 10)")
+	fs.DurationVar(&cli.LogMaxAge, "log-max-age", 0,
+		"How long rotated log files are kept (default 168h0m0s)")
+	fs.StringVar(&cli.LogSyslog, "log-syslog", "",
+		"Also send logs to syslog: local, udp://host:port or tcp://host:port")
+	fs.StringVar(&cli.LogSyslogFacility, "log-syslog-facility", "",
+		"Syslog facility for --log-syslog (default daemon)")
+	fs.StringVar(&cli.LogSyslogTag, "log-syslog-tag", "",
+		"Syslog tag for --log-syslog (default duckdns)")
+	fs.StringVarP(&cli.File, "config", "c", "duckdns.yaml",
+		"Config file location, an http(s):// URL to download it from, or - for stdin")
+	fs.StringVarP(&cli.Profile, "profile", "p", "",
+		"Named profile to use from the config file")
+	fs.StringVar(&cli.ConfigFormat, "config-format", "",
+		"Config file format, yaml or json (default from the file extension)")
+	fs.StringVar(&cli.ConfigKey, "config-key", "",
+		"Minisign public key, or its file, that a downloaded config must be signed with")
+	fs.StringSliceVarP(&cli.Names, "names", "n", nil,
+		"Names to update with DuckDNS. Just the subdomain section. "+
+			"Use the flag multiple times to set multiple values.")
+	fs.StringVar(&cli.NamesFile, "names-file", "",
+		"File listing the names, one per line, such as a mounted ConfigMap key")
+	fs.StringVarP(&cli.Token, "token", "t", "",
+		"Token for updating DuckDNS")
+	fs.StringVar(&cli.TokenFile, "token-file", "",
+		"File containing the token, such as a Docker secret")
+	fs.BoolVar(&cli.TokenStdin, "token-stdin", false,
+		"Read the token from stdin")
+	fs.StringVar(&cli.Endpoint, "endpoint", "",
+		"DuckDNS update URL (default \""+duckdns.DefaultEndpoint+"\")")
+	fs.DurationVar(&cli.ConnectTimeout, "connect-timeout", 0,
+		"Timeout for connecting to DuckDNS (default 10s)")
+	fs.DurationVar(&cli.Timeout, "timeout", 0,
+		"Timeout for each request to DuckDNS (default 30s)")
+	fs.StringVar(&cli.Proxy, "proxy", "",
+		"Proxy URL for DuckDNS requests (http://, https:// or socks5://)")
+	fs.StringVar(&cli.TLS.CAFile, "tls-ca-file", "",
+		"PEM file of root certificates to trust besides the system ones")
+	fs.StringVar(&cli.TLS.MinVersion, "tls-min-version", "",
+		"Oldest TLS version to accept: 1.0, 1.1, 1.2 or 1.3 (default 1.2)")
+	fs.StringSliceVar(&cli.TLS.Pins, "tls-pin", nil,
+		"Key the endpoint must present, as sha256/<base64> of its public key or a certificate fingerprint")
+	fs.BoolVarP(&cli.ForceIPv4, "force-ipv4", "4", false,
+		"Connect to DuckDNS over IPv4 only, so the A record is the one updated")
+	fs.BoolVarP(&cli.ForceIPv6, "force-ipv6", "6", false,
+		"Connect to DuckDNS over IPv6 only, so the AAAA record is the one updated")
+	fs.StringVar(&cli.SourceIP, "source-ip", "",
+		"Local address to send requests from, on hosts with more than one uplink")
+	fs.StringVar(&cli.BindInterface, "bind-interface", "",
+		"Network interface to send requests through, e.g. wan2")
+	fs.IntVar(&cli.RetryAttempts, "retry-attempts", 0,
+		"Times to try each name before giving up, 1 disables retries (default 3)")
+	fs.DurationVar(&cli.RetryDelay, "retry-delay", 0,
+		"Wait before the first retry, doubled on each retry (default 2s)")
+	fs.DurationVar(&cli.OfflineRetry, "offline-retry", 0,
+		"How soon the daemon tries again when DuckDNS can't be reached, doubling each time (default 15s)")
+	fs.DurationVar(&cli.RetryFailedAfter, "retry-failed-after", 0,
+		"Try the names that failed once more this long after the rest of the run (default off)")
+	fs.IntVar(&cli.Concurrency, "concurrency", 0,
+		"How many names to update at once (default 4)")
+	fs.IntVar(&cli.RateLimit, "rate-limit", 0,
+		"Most requests to send DuckDNS a minute, across all names and retries (default unlimited)")
+	fs.BoolVar(&cli.DetectIP, "detect-ip", false,
+		"Look up the public IP and send it, skipping names where it hasn't changed")
+	fs.BoolVar(&cli.DualStack, "dual-stack", false,
+		"Detect the public IPv4 and IPv6 addresses and send both, turns on --detect-ip")
+	fs.StringSliceVar(&cli.IPProviders, "ip-provider", nil,
+		"IP detection services to try in order: ipify, icanhazip, duckdns, stun, stun:<host>:<port>, "+
+			"upnp, interface:<name>, env:<VAR> or a URL (default ipify,icanhazip)")
+	fs.IntVar(&cli.IPQuorum, "ip-quorum", 0,
+		"How many IP providers have to agree on the address before it is sent (default 1)")
+	fs.StringVar(&cli.Interface, "interface", "",
+		"Read the IP from this network interface instead of using a service")
+	fs.StringVar(&cli.DataDir, "data-dir", "",
+		"Directory of the cache, history and lock files (default $XDG_STATE_HOME/duckdns)")
+	fs.StringVar(&cli.CacheFile, "cache-file", "",
+		"File recording the last IP sent for each name (default cache.json in --data-dir)")
+	fs.StringVar(&cli.HistoryFile, "history-file", "",
+		"JSON lines file recording every request to DuckDNS, for the history subcommand")
+	fs.StringVar(&cli.Lock, "lock", "",
+		"Lock file that stops a second instance from updating at the same time, e.g. /run/duckdns.lock")
+	fs.DurationVar(&cli.RefreshInterval, "refresh-interval", 0,
+		"Update names even if the IP is unchanged once this old (default 24h0m0s)")
+	fs.BoolVar(&cli.Daemon, "daemon", false,
+		"Keep running and update whenever the public IP changes")
+	fs.DurationVar(&cli.Interval, "interval", 0,
+		"How often the daemon checks the public IP (default 5m0s)")
+	fs.StringVar(&cli.Schedule, "schedule", "",
+		"Crontab expression for when the daemon checks the public IP, instead of --interval")
+	fs.DurationVar(&cli.Jitter, "jitter", 0,
+		"Delay each scheduled check by a random amount up to this long")
+	fs.DurationVar(&cli.StartupDelay, "startup-delay", 0,
+		"Delay the daemon's first check by a random amount up to this long")
+	fs.StringVar(&cli.Listen, "listen", "",
+		"Address for the daemon's HTTP server with a dashboard, /metrics, /healthz and /status, e.g. :8053")
+	fs.StringVar(&cli.APIToken, "api-token", "",
+		"Bearer token for POST /update on the --listen server, which triggers an update")
+	fs.BoolVar(&cli.Watch, "watch", false,
+		"Reload the daemon when the config, token or names files change")
+	fs.BoolVar(&cli.WatchAddresses, "watch-addresses", false,
+		"Check the public IP as soon as a network address changes, Linux only")
+	fs.StringSliceVar(&cli.NotifyWebhooks, "notify-webhook", nil,
+		"URL to POST a JSON event to when an address changes or an update fails")
+	fs.StringVar(&cli.PingURL, "ping-url", "",
+		"Health check URL pinged at the start and end of each run, e.g. https://hc-ping.com/<uuid>")
+	fs.StringVar(&cli.OTLPEndpoint, "otlp-endpoint", "",
+		"OTLP/HTTP collector to export traces to, e.g. http://localhost:4318")
+	fs.BoolVar(&cli.Verify, "verify", false,
+		"Check that updated records resolve to the new value")
+	fs.StringVar(&cli.VerifyResolver, "verify-resolver", "",
+		"DNS server used by --verify and status, as host[:port], tls://host[:port] or an https:// DoH URL (default system resolver)")
+	fs.DurationVar(&cli.VerifyTimeout, "verify-timeout", 0,
+		"How long --verify waits for a record to propagate (default 2m0s)")
+	fs.BoolVar(&cli.DryRun, "dry-run", false,
+		"With update, txt or clear, print the requests that would be sent without contacting DuckDNS")
+	fs.StringVar(&cli.Output, "output", "",
+		"With update, txt or clear, print the result of each name to stdout as json, table or plain")
+	fs.StringVar(&cli.TXT, "txt", "",
+		"Set a TXT record on the names instead of updating the IP address")
+	fs.MarkDeprecated("txt", "use the txt command instead")
+	fs.BoolVar(&cli.ACMEHook, "acme-hook", false,
+		"Run as a certbot --manual-auth-hook or --manual-cleanup-hook, setting or clearing "+
+			"the TXT record of CERTBOT_DOMAIN and waiting for it to propagate")
 }
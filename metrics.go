@@ -0,0 +1,63 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	metricAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "duckdns",
+		Name:      "update_attempts_total",
+		Help:      "Updates sent to DuckDNS, by domain.",
+	}, []string{"domain"})
+	metricSuccesses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "duckdns",
+		Name:      "update_successes_total",
+		Help:      "Updates accepted by DuckDNS, by domain.",
+	}, []string{"domain"})
+	metricFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "duckdns",
+		Name:      "update_failures_total",
+		Help:      "Updates that failed after all retries, by domain.",
+	}, []string{"domain"})
+	metricLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "duckdns",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "When DuckDNS last accepted an update for the domain.",
+	}, []string{"domain"})
+	metricLastChange = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "duckdns",
+		Name:      "last_ip_change_timestamp_seconds",
+		Help:      "When DuckDNS last reported the domain's address as changed.",
+	}, []string{"domain"})
+	metricDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "duckdns",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken by update requests, including retries.",
+		Buckets:   []float64{.1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"domain"})
+)
+
+func init() {
+	prometheus.MustRegister(metricAttempts, metricSuccesses, metricFailures,
+		metricLastSuccess, metricLastChange, metricDuration)
+}
+
+// observeUpdate records the outcome of one update request
+func observeUpdate(domain string, updated bool, took time.Duration, err error) {
+	metricAttempts.WithLabelValues(domain).Inc()
+	metricDuration.WithLabelValues(domain).Observe(took.Seconds())
+	if err != nil {
+		metricFailures.WithLabelValues(domain).Inc()
+		return
+	}
+
+	now := float64(time.Now().Unix())
+	metricSuccesses.WithLabelValues(domain).Inc()
+	metricLastSuccess.WithLabelValues(domain).Set(now)
+	if updated {
+		metricLastChange.WithLabelValues(domain).Set(now)
+	}
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// migrateSearchPaths are where `duckdns migrate` looks for a config of
+// ddclient or inadyn when not given one
+var migrateSearchPaths = []string{
+	"/etc/ddclient.conf",
+	"/etc/ddclient/ddclient.conf",
+	"/usr/local/etc/ddclient.conf",
+	"/etc/inadyn.conf",
+	"/usr/local/etc/inadyn.conf",
+}
+
+// migration is what a config of another updater says, as far as duckdns goes
+type migration struct {
+	// accounts holds the DuckDNS entries in the order found, merged by token
+	accounts  []Account
+	interval  time.Duration
+	iface     string
+	dualStack bool
+	detectIP  bool
+	proxy     string
+	addresses map[string]string
+}
+
+// addNames adds the names of token, merging them into an earlier entry with
+// the same token
+func (m *migration) addNames(token string, names []string) {
+	if token == "" {
+		logrus.Warnf("no token for %s, fill it in", strings.Join(names, ", "))
+	}
+	for i := range m.accounts {
+		if m.accounts[i].Token == token {
+			for _, n := range names {
+				if !containsName(m.accounts[i].Names, n) {
+					m.accounts[i].Names = append(m.accounts[i].Names, n)
+				}
+			}
+			return
+		}
+	}
+	m.accounts = append(m.accounts, Account{Token: token, Names: names})
+}
+
+// runMigrate prints the duckdns config equivalent to the DuckDNS entries of a
+// ddclient or inadyn config, so that a switch doesn't start from scratch.
+// Settings without an equivalent are left out with a warning.
+func runMigrate(path, from string) {
+	if path == "" {
+		for _, p := range migrateSearchPaths {
+			if fileExists(p) {
+				path = p
+				break
+			}
+		}
+		if path == "" {
+			fatal(exitConfig, nil, "no ddclient or inadyn config found, give its path")
+		}
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		fatal(exitConfig, err, "unable to read %s", path)
+	}
+	if from == "" {
+		from = "ddclient"
+		if strings.Contains(filepath.Base(path), "inadyn") || inadynProvider.Match(data) {
+			from = "inadyn"
+		}
+	}
+
+	var m *migration
+	switch from {
+	case "ddclient":
+		m = parseDdclient(string(data))
+	case "inadyn":
+		m, err = parseInadyn(string(data))
+	default:
+		fatal(exitConfig, nil, "--from must be ddclient or inadyn")
+	}
+	if err != nil {
+		fatal(exitConfig, err, "unable to parse %s", path)
+	}
+	if len(m.accounts) == 0 {
+		fatal(exitConfig, nil, "no DuckDNS entries in %s", path)
+	}
+
+	out, err := yaml.Marshal(m.config())
+	if err != nil {
+		fatal(exitError, err, "unable to write the config")
+	}
+	fmt.Printf("# migrated from the %s config %s\n---\n%s", from, path, out)
+}
+
+// config lays the migration out like duckdns.yaml.sample, the first account
+// at the top level
+func (m *migration) config() yaml.MapSlice {
+	first := m.accounts[0]
+	c := yaml.MapSlice{{Key: "token", Value: first.Token}, {Key: "domains", Value: first.Names}}
+	if len(m.accounts) > 1 {
+		var accounts []yaml.MapSlice
+		for _, a := range m.accounts[1:] {
+			accounts = append(accounts, yaml.MapSlice{{Key: "token", Value: a.Token}, {Key: "domains", Value: a.Names}})
+		}
+		c = append(c, yaml.MapItem{Key: "accounts", Value: accounts})
+	}
+	if m.interval > 0 {
+		c = append(c, yaml.MapItem{Key: "daemon", Value: true},
+			yaml.MapItem{Key: "interval", Value: m.interval.String()})
+	}
+	if m.detectIP || m.iface != "" {
+		c = append(c, yaml.MapItem{Key: "detect_ip", Value: true})
+	}
+	if m.iface != "" {
+		c = append(c, yaml.MapItem{Key: "interface", Value: m.iface})
+	}
+	if m.dualStack {
+		c = append(c, yaml.MapItem{Key: "dual_stack", Value: true})
+	}
+	if m.proxy != "" {
+		c = append(c, yaml.MapItem{Key: "proxy", Value: m.proxy})
+	}
+	if len(m.addresses) > 0 {
+		c = append(c, yaml.MapItem{Key: "addresses", Value: m.addresses})
+	}
+	return c
+}
+
+// migratedName is a host name of another updater as duckdns writes it
+func migratedName(host string) string {
+	return normalizeName(Account{}, strings.Trim(host, `"'`))
+}
+
+// migratedInterval reads a period in seconds, or with a unit as ddclient
+// allows, such as 5m
+func migratedInterval(v string) (time.Duration, bool) {
+	if n, err := strconv.Atoi(v); err == nil {
+		return time.Duration(n) * time.Second, n > 0
+	}
+	if strings.HasSuffix(v, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(v, "d"))
+		return time.Duration(n) * 24 * time.Hour, err == nil && n > 0
+	}
+	d, err := time.ParseDuration(v)
+	return d, err == nil && d > 0
+}
+
+// parseDdclient reads a ddclient.conf: lines of key=value settings separated
+// by commas or spaces, continued by a trailing backslash. A line ending in
+// host names is an entry taking the settings of earlier lines without hosts
+// and its own.
+func parseDdclient(data string) *migration {
+	m := &migration{}
+	globals := map[string]string{}
+	var logical []string
+	var current string
+	for _, line := range strings.Split(data, "\n") {
+		line = stripComment(line)
+		if strings.HasSuffix(line, `\`) {
+			current += strings.TrimSuffix(line, `\`) + " "
+			continue
+		}
+		logical = append(logical, current+line)
+		current = ""
+	}
+	logical = append(logical, current)
+
+	for _, line := range logical {
+		settings := map[string]string{}
+		var hosts []string
+		for _, field := range splitDdclientFields(line) {
+			if i := strings.Index(field, "="); i > 0 {
+				settings[strings.ToLower(strings.TrimSpace(field[:i]))] = strings.Trim(strings.TrimSpace(field[i+1:]), `"'`)
+			} else {
+				hosts = append(hosts, field)
+			}
+		}
+		if len(hosts) == 0 {
+			for k, v := range settings {
+				globals[k] = v
+			}
+			continue
+		}
+		entry := map[string]string{}
+		for k, v := range globals {
+			entry[k] = v
+		}
+		for k, v := range settings {
+			entry[k] = v
+		}
+		m.ddclientEntry(entry, hosts)
+	}
+	return m
+}
+
+// ddclientEntry adds the hosts of an entry when it is a DuckDNS one, and the
+// settings that go with it
+func (m *migration) ddclientEntry(s map[string]string, hosts []string) {
+	if p := strings.ToLower(s["protocol"]); p != "duckdns" {
+		logrus.Warnf("leaving out %s, updated with ddclient's %s protocol", strings.Join(hosts, ", "), p)
+		return
+	}
+	var names []string
+	for _, h := range hosts {
+		names = append(names, migratedName(h))
+	}
+	m.addNames(s["password"], names)
+
+	if v, ok := s["daemon"]; ok {
+		if d, ok := migratedInterval(v); ok {
+			m.interval = d
+		} else {
+			logrus.Warnf("leaving out daemon=%s, not a period", v)
+		}
+	}
+	use := strings.ToLower(s["use"])
+	if use == "" {
+		use = strings.ToLower(s["usev4"])
+	}
+	switch use {
+	case "", "web", "webv4":
+		// ddclient checks the address with a web service by default, the
+		// default IP providers do the same
+		m.detectIP = true
+	case "if", "ifv4":
+		m.iface = s["if"]
+		if m.iface == "" {
+			m.iface = s["ifv4"]
+		}
+	case "ip", "ipv4":
+		ip := s["ip"]
+		if ip == "" {
+			ip = s["ipv4"]
+		}
+		if m.addresses == nil {
+			m.addresses = map[string]string{}
+		}
+		for _, n := range names {
+			m.addresses[n] = ip
+		}
+	default:
+		logrus.Warnf("leaving out use=%s, detecting the address with the default IP providers", use)
+		m.detectIP = true
+	}
+	if s["usev6"] != "" {
+		m.dualStack = true
+	}
+	if p := s["proxy"]; p != "" {
+		if !strings.Contains(p, "://") {
+			p = "http://" + p
+		}
+		m.proxy = p
+	}
+}
+
+// splitDdclientFields splits a ddclient line on commas and spaces, keeping
+// quoted values whole
+func splitDdclientFields(line string) []string {
+	var fields []string
+	var b strings.Builder
+	var quote rune
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+			b.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			b.WriteRune(r)
+		case r == ',' || r == ' ' || r == '\t' || r == '\r':
+			if b.Len() > 0 {
+				fields = append(fields, b.String())
+				b.Reset()
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() > 0 {
+		fields = append(fields, b.String())
+	}
+	// "key = value" is written with spaces at times
+	var joined []string
+	for i := 0; i < len(fields); i++ {
+		switch {
+		case fields[i] == "=" && len(joined) > 0 && i+1 < len(fields):
+			joined[len(joined)-1] += "=" + fields[i+1]
+			i++
+		case strings.HasSuffix(fields[i], "=") && i+1 < len(fields):
+			joined = append(joined, fields[i]+fields[i+1])
+			i++
+		default:
+			joined = append(joined, fields[i])
+		}
+	}
+	return joined
+}
+
+// stripComment drops a # comment, but not a quoted #
+func stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return strings.TrimSpace(line[:i])
+		}
+	}
+	return strings.TrimSpace(line)
+}
+
+// inadynProvider finds the provider sections of an inadyn 2 config
+var inadynProvider = regexp.MustCompile(`(?m)^\s*provider\s+\S+\s*\{`)
+
+// inadynToken splits an inadyn config into words, strings and punctuation
+var inadynToken = regexp.MustCompile(`"(?:[^"\\]|\\.)*"|'[^']*'|[{}=,]|[^\s{}=,"']+`)
+
+// parseInadyn reads an inadyn 2 config: key = value settings, some with a
+// { list }, and provider sections for each service. The DuckDNS plugin takes
+// the token as the username.
+func parseInadyn(data string) (*migration, error) {
+	var tokens []string
+	for _, line := range strings.Split(data, "\n") {
+		tokens = append(tokens, inadynToken.FindAllString(stripComment(line), -1)...)
+	}
+	m := &migration{}
+	pos := 0
+	next := func() string {
+		if pos >= len(tokens) {
+			return ""
+		}
+		pos++
+		return tokens[pos-1]
+	}
+	// value reads what follows key: a single value or a { list }
+	value := func(key string) ([]string, error) {
+		if next() != "=" {
+			return nil, fmt.Errorf("expected = after %s", key)
+		}
+		v := next()
+		if v == "" {
+			return nil, fmt.Errorf("%s needs a value", key)
+		}
+		if v != "{" {
+			return []string{strings.Trim(v, `"'`)}, nil
+		}
+		var list []string
+		for v = next(); v != "}"; v = next() {
+			if v == "" {
+				return nil, fmt.Errorf("unterminated list")
+			}
+			if v != "," {
+				list = append(list, strings.Trim(v, `"'`))
+			}
+		}
+		if len(list) == 0 {
+			return nil, fmt.Errorf("%s needs a value", key)
+		}
+		return list, nil
+	}
+
+	for pos < len(tokens) {
+		key := strings.ToLower(next())
+		if key == "provider" || key == "custom" {
+			name := strings.ToLower(next())
+			if next() != "{" {
+				return nil, fmt.Errorf("expected { after %s %s", key, name)
+			}
+			section := map[string][]string{}
+			for {
+				k := strings.ToLower(next())
+				if k == "}" {
+					break
+				}
+				if k == "" {
+					return nil, fmt.Errorf("unterminated %s %s", key, name)
+				}
+				v, err := value(k)
+				if err != nil {
+					return nil, err
+				}
+				section[k] = v
+			}
+			m.inadynProvider(key, name, section)
+			continue
+		}
+		v, err := value(key)
+		if err != nil {
+			return nil, err
+		}
+		switch key {
+		case "period":
+			if d, ok := migratedInterval(v[0]); ok {
+				m.interval = d
+			}
+		case "iface":
+			m.iface = v[0]
+		case "allow-ipv6":
+			m.dualStack = v[0] == "true"
+		}
+	}
+	return m, nil
+}
+
+// inadynProvider adds the hostnames of a provider section when it is DuckDNS
+func (m *migration) inadynProvider(kind, name string, s map[string][]string) {
+	if kind != "provider" || !strings.Contains(name, "duckdns.org") {
+		logrus.Warnf("leaving out %s %s, not DuckDNS", kind, name)
+		return
+	}
+	first := func(k string) string {
+		if len(s[k]) == 0 {
+			return ""
+		}
+		return s[k][0]
+	}
+	token := first("username")
+	if p := first("password"); !tokenPattern.MatchString(token) && tokenPattern.MatchString(p) {
+		token = p
+	}
+	var names []string
+	for _, h := range s["hostname"] {
+		names = append(names, migratedName(h))
+	}
+	m.addNames(token, names)
+	// inadyn always checks the address itself before updating
+	m.detectIP = true
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const migrateToken = "a7c4d0ad-114e-40ef-ba1d-d217904a50f2"
+
+func TestParseDdclient(t *testing.T) {
+	tests := []struct {
+		name string
+		conf string
+		want *migration
+	}{
+		{
+			name: "single entry",
+			conf: `
+daemon=300
+protocol=duckdns
+use=web
+password=` + migrateToken + `
+home.duckdns.org
+`,
+			want: &migration{
+				accounts: []Account{{Token: migrateToken, Names: []string{"home"}}},
+				interval: 5 * time.Minute,
+				detectIP: true,
+			},
+		},
+		{
+			name: "continued lines and spaced settings",
+			conf: `
+protocol = duckdns, \
+  use=if, if=eth0, \
+  password='` + migrateToken + `' \
+  home,office # two names
+`,
+			want: &migration{
+				accounts: []Account{{Token: migrateToken, Names: []string{"home", "office"}}},
+				iface:    "eth0",
+			},
+		},
+		{
+			name: "entries merged by token",
+			conf: `
+protocol=duckdns, password=` + migrateToken + ` home
+protocol=duckdns, password=` + migrateToken + ` home.duckdns.org, office
+`,
+			want: &migration{
+				accounts: []Account{{Token: migrateToken, Names: []string{"home", "office"}}},
+				detectIP: true,
+			},
+		},
+		{
+			name: "fixed address and other protocols",
+			conf: `
+protocol=dyndns2, login=me, password=secret example.dyndns.org
+protocol=duckdns, use=ip, ip=192.0.2.1, usev6=webv6, password=` + migrateToken + ` home
+`,
+			want: &migration{
+				accounts:  []Account{{Token: migrateToken, Names: []string{"home"}}},
+				dualStack: true,
+				addresses: map[string]string{"home": "192.0.2.1"},
+			},
+		},
+		{
+			name: "proxy and period in days",
+			conf: `
+daemon=1d
+proxy=proxy.lan:3128
+protocol=duckdns, password=` + migrateToken + ` home
+`,
+			want: &migration{
+				accounts: []Account{{Token: migrateToken, Names: []string{"home"}}},
+				interval: 24 * time.Hour,
+				detectIP: true,
+				proxy:    "http://proxy.lan:3128",
+			},
+		},
+		{
+			name: "no DuckDNS entries",
+			conf: "protocol=dyndns2, password=secret example.dyndns.org\n",
+			want: &migration{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseDdclient(tt.conf)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDdclient() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInadyn(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    string
+		want    *migration
+		wantErr string
+	}{
+		{
+			name: "single provider",
+			conf: `
+period = 600
+iface = eth0
+allow-ipv6 = true
+provider default@duckdns.org {
+    username = ` + migrateToken + `
+    password = nopasswd
+    hostname = { "home.duckdns.org", office }
+}
+`,
+			want: &migration{
+				accounts:  []Account{{Token: migrateToken, Names: []string{"home", "office"}}},
+				interval:  10 * time.Minute,
+				iface:     "eth0",
+				dualStack: true,
+				detectIP:  true,
+			},
+		},
+		{
+			name: "token as the password",
+			conf: `
+provider duckdns.org {
+    username = me
+    password = "` + migrateToken + `"
+    hostname = home
+}
+custom example {
+    hostname = example.com
+}
+`,
+			want: &migration{
+				accounts: []Account{{Token: migrateToken, Names: []string{"home"}}},
+				detectIP: true,
+			},
+		},
+		{
+			name:    "key without a value",
+			conf:    "period",
+			wantErr: "expected = after period",
+		},
+		{
+			name:    "missing value at the end",
+			conf:    "period =",
+			wantErr: "period needs a value",
+		},
+		{
+			name:    "empty list",
+			conf:    "period = {}",
+			wantErr: "period needs a value",
+		},
+		{
+			name:    "empty list with spaces",
+			conf:    "iface = { }",
+			wantErr: "iface needs a value",
+		},
+		{
+			name:    "empty list in a provider",
+			conf:    "provider duckdns.org {\n hostname = { }\n}",
+			wantErr: "hostname needs a value",
+		},
+		{
+			name:    "unterminated list",
+			conf:    "iface = { eth0,",
+			wantErr: "unterminated list",
+		},
+		{
+			name:    "unterminated provider",
+			conf:    "provider duckdns.org {\n hostname = home\n",
+			wantErr: "unterminated provider duckdns.org",
+		},
+		{
+			name:    "provider without a section",
+			conf:    "provider duckdns.org hostname = home",
+			wantErr: "expected { after provider duckdns.org",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseInadyn(tt.conf)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseInadyn() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseInadyn() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseInadyn() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigratedInterval(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"300", 5 * time.Minute, true},
+		{"5m", 5 * time.Minute, true},
+		{"2d", 48 * time.Hour, true},
+		{"0", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := migratedInterval(tt.in)
+		if ok != tt.wantOK || (ok && got != tt.want) {
+			t.Errorf("migratedInterval(%q) = %s, %t, want %s, %t", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/theag3nt/duckdns/pkg/duckdns/duckdnstest"
+)
+
+// defaultMockAddr is where `duckdns mock-server` listens unless told otherwise
+const defaultMockAddr = "localhost:8080"
+
+// runMockServer serves the DuckDNS update API from memory on addr until ctx
+// is cancelled, for trying a configuration end to end. The DuckDNS accounts
+// of the configuration own their names; without any, every token and name is
+// accepted. The records are listed as JSON at /records.
+func runMockServer(ctx context.Context, update Update, addr string, acceptAny bool) {
+	mock := duckdnstest.NewServer("")
+	mock.AcceptAny = acceptAny
+	for _, a := range update.accounts() {
+		if providerName(a) == "duckdns" {
+			mock.Add(a.Token, a.Names...)
+		}
+	}
+	if len(mock.Domains()) == 0 {
+		mock.AcceptAny = true
+	}
+	mock.OnRequest = func(r duckdnstest.Request) {
+		result := "OK"
+		if !r.OK {
+			result = "KO"
+		}
+		entry := logrus.WithField("result", result)
+		names := strings.Join(r.Domains, ",")
+		switch {
+		case r.TXT != "":
+			entry.WithField("txt", r.TXT).Infof("TXT update for %s", names)
+		case r.Clear:
+			entry.Infof("Clear for %s", names)
+		default:
+			entry.WithFields(logrus.Fields{"ip": r.IP, "ipv6": r.IPv6}).Infof("Update for %s", names)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(duckdnstest.Path, mock)
+	mux.HandleFunc("/records", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(mock.Records())
+	})
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal(exitError, err, "unable to listen on %s", addr)
+	}
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	endpoint := "http://" + ln.Addr().String() + duckdnstest.Path
+	if mock.AcceptAny {
+		logrus.Infof("Mock DuckDNS listening on %s, accepting any token and name", endpoint)
+	} else {
+		logrus.Infof("Mock DuckDNS listening on %s for %s", endpoint,
+			strings.Join(mock.Domains(), ", "))
+	}
+	if err := srv.Serve(ln); err != http.ErrServerClosed {
+		fatal(exitError, err, "mock server failed")
+	}
+}
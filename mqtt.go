@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// MQTTConfig publishes the daemon's state to an MQTT broker
+type MQTTConfig struct {
+	// Broker is tcp://host:port, or tls://host:port for MQTT over TLS
+	Broker   string `yaml:"broker"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	ClientID string `yaml:"client_id"`
+	// Topic is the prefix of every published topic, duckdns by default
+	Topic string `yaml:"topic"`
+	// Discovery also publishes Home Assistant discovery configs under
+	// DiscoveryPrefix, homeassistant by default
+	Discovery       bool   `yaml:"discovery"`
+	DiscoveryPrefix string `yaml:"discovery_prefix"`
+}
+
+const (
+	mqttConnect    = 0x10
+	mqttConnAck    = 0x20
+	mqttPublish    = 0x30
+	mqttDisconnect = 0xe0
+	mqttRetain     = 0x01
+	mqttKeepAlive  = 60
+)
+
+// mqttMessage is a retained message to publish
+type mqttMessage struct {
+	topic   string
+	payload []byte
+}
+
+// publishState publishes the public address and each name's latest result,
+// plus the discovery configs when enabled
+func publishState(ctx context.Context, update Update, state *daemonState) error {
+	c := update.MQTT
+	topic := c.Topic
+	if topic == "" {
+		topic = "duckdns"
+	}
+
+	state.mu.Lock()
+	msgs := []mqttMessage{{topic: topic + "/ip", payload: []byte(state.IP)}}
+	var domains []string
+	for name, d := range state.Domains {
+		payload, err := json.Marshal(struct {
+			domainState
+			LastRun time.Time `json:"last_run"`
+		}{d, state.LastRun})
+		if err != nil {
+			state.mu.Unlock()
+			return err
+		}
+		msgs = append(msgs, mqttMessage{topic: topic + "/" + name, payload: payload})
+		domains = append(domains, name)
+	}
+	state.mu.Unlock()
+
+	if c.Discovery {
+		discovery, err := discoveryMessages(c, topic, domains)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, discovery...)
+	}
+
+	return mqttSend(ctx, c, update.ConnectTimeout, update.Timeout, msgs)
+}
+
+// discoveryMessages describes a sensor for the public address and one per
+// name, so Home Assistant picks them up without any YAML of its own
+func discoveryMessages(c MQTTConfig, topic string, domains []string) ([]mqttMessage, error) {
+	prefix := c.DiscoveryPrefix
+	if prefix == "" {
+		prefix = "homeassistant"
+	}
+	// the node ID keeps several daemons on one broker apart
+	node := strings.Replace(topic, "/", "_", -1)
+	device := map[string]interface{}{
+		"identifiers": []string{node},
+		"name":        "DuckDNS",
+	}
+
+	configs := map[string]map[string]interface{}{
+		"public_ip": {
+			"name":        "Public IP",
+			"state_topic": topic + "/ip",
+		},
+	}
+	for _, d := range domains {
+		configs[d] = map[string]interface{}{
+			"name":                  fqdn(d),
+			"state_topic":           topic + "/" + d,
+			"value_template":        "{{ value_json.result }}",
+			"json_attributes_topic": topic + "/" + d,
+		}
+	}
+
+	var msgs []mqttMessage
+	for id, cfg := range configs {
+		cfg["unique_id"] = node + "_" + id
+		cfg["icon"] = "mdi:ip-network"
+		cfg["device"] = device
+		payload, err := json.Marshal(cfg)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, mqttMessage{
+			topic:   fmt.Sprintf("%s/sensor/%s/%s/config", prefix, node, id),
+			payload: payload,
+		})
+	}
+	return msgs, nil
+}
+
+// mqttSend connects to the broker, publishes msgs as retained QoS 0 messages
+// and disconnects. That is all the daemon needs, so there is no full client.
+func mqttSend(ctx context.Context, c MQTTConfig, connectTimeout, timeout time.Duration,
+	msgs []mqttMessage) error {
+
+	u, err := url.Parse(c.Broker)
+	if err != nil {
+		return err
+	}
+	dialer := &net.Dialer{Timeout: connectTimeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		conn, err = dialer.DialContext(ctx, "tcp", hostPort(u, "1883"))
+	case "tls", "ssl", "mqtts":
+		conn, err = tls.DialWithDialer(dialer, "tcp", hostPort(u, "8883"),
+			&tls.Config{ServerName: u.Hostname()})
+	default:
+		return fmt.Errorf("MQTT broker %q must be a tcp:// or tls:// URL", c.Broker)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	w := bufio.NewWriter(conn)
+	clientID := c.ClientID
+	if clientID == "" {
+		clientID = "duckdns"
+	}
+	w.Write(mqttConnectPacket(clientID, c.Username, c.Password))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	ack := make([]byte, 4)
+	if _, err := io.ReadFull(conn, ack); err != nil {
+		return fmt.Errorf("reading CONNACK: %v", err)
+	}
+	if ack[0] != mqttConnAck || ack[1] != 2 {
+		return errors.New("broker did not answer with a CONNACK")
+	}
+	if ack[3] != 0 {
+		return fmt.Errorf("broker refused the connection, return code %d", ack[3])
+	}
+
+	for _, m := range msgs {
+		var p []byte
+		p = appendMQTTString(p, m.topic)
+		p = append(p, m.payload...)
+		w.Write(mqttPacket(mqttPublish|mqttRetain, p))
+	}
+	w.Write([]byte{mqttDisconnect, 0})
+	return w.Flush()
+}
+
+// hostPort returns the host:port of u, adding port if it has none
+func hostPort(u *url.URL, port string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func mqttConnectPacket(clientID, username, password string) []byte {
+	flags := byte(0x02) // clean session
+	if username != "" {
+		flags |= 0x80
+		if password != "" {
+			flags |= 0x40
+		}
+	}
+
+	var p []byte
+	p = appendMQTTString(p, "MQTT")
+	p = append(p, 4, flags, 0, mqttKeepAlive)
+	p = appendMQTTString(p, clientID)
+	if username != "" {
+		p = appendMQTTString(p, username)
+		if password != "" {
+			p = appendMQTTString(p, password)
+		}
+	}
+	return mqttPacket(mqttConnect, p)
+}
+
+// mqttPacket prefixes body with the fixed header, whose remaining length is
+// encoded seven bits at a time
+func mqttPacket(header byte, body []byte) []byte {
+	p := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		p = append(p, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(p, body...)
+}
+
+func appendMQTTString(p []byte, s string) []byte {
+	return append(append(p, byte(len(s)>>8), byte(len(s))), s...)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDiscoveryMessages(t *testing.T) {
+	msgs, err := discoveryMessages(MQTTConfig{}, "duckdns", []string{"home", "home.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		topic string
+		name  string
+	}{
+		{"homeassistant/sensor/duckdns/public_ip/config", "Public IP"},
+		{"homeassistant/sensor/duckdns/home/config", "home.duckdns.org"},
+		{"homeassistant/sensor/duckdns/home.example.com/config", "home.example.com"},
+	}
+	if len(msgs) != len(tests) {
+		t.Fatalf("discoveryMessages() = %d messages, want %d", len(msgs), len(tests))
+	}
+	payloads := make(map[string][]byte)
+	for _, m := range msgs {
+		payloads[m.topic] = m.payload
+	}
+	for _, tt := range tests {
+		payload, ok := payloads[tt.topic]
+		if !ok {
+			t.Errorf("no message on %s", tt.topic)
+			continue
+		}
+		var cfg struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(payload, &cfg); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Name != tt.name {
+			t.Errorf("name on %s = %q, want %q", tt.topic, cfg.Name, tt.name)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// normalizeNames tidies the names of every account before they are used:
+// lowercased, without a trailing dot or, for DuckDNS, the .duckdns.org
+// suffix, empty entries dropped and each name kept once
+func (u *Update) normalizeNames() {
+	u.Names = normalizeNames(Account{}, u.Names)
+	for i := range u.Accounts {
+		u.Accounts[i].Names = normalizeNames(u.Accounts[i], u.Accounts[i].Names)
+	}
+
+	seen := map[string]bool{}
+	for _, a := range u.accounts() {
+		for _, n := range a.Names {
+			key := providerName(a) + "/" + n
+			if seen[key] {
+				logrus.Warnf("%s is listed under more than one account", n)
+			}
+			seen[key] = true
+		}
+	}
+}
+
+func normalizeNames(a Account, names []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, n := range names {
+		n = normalizeName(a, n)
+		if n == "" {
+			continue
+		}
+		if seen[n] {
+			logrus.Debugf("ignoring %s, listed twice", n)
+			continue
+		}
+		seen[n] = true
+		out = append(out, n)
+	}
+	return out
+}
+
+// normalizeName is n as sent to the provider of a
+func normalizeName(a Account, n string) string {
+	n = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(n)), ".")
+	if providerName(a) == "duckdns" {
+		n = strings.TrimSuffix(n, duckDNSZone)
+	}
+	return n
+}
+
+// validName reports whether the provider of a accepts n, a single label for
+// DuckDNS and a full host name for the others
+func validName(a Account, n string) bool {
+	if providerName(a) == "duckdns" {
+		return namePattern.MatchString(n)
+	}
+	return hostPattern.MatchString(n)
+}
+
+// checkNames returns an error naming every name that the provider would
+// reject, so that a typo fails before anything is sent rather than as a KO
+func (u *Update) checkNames() error {
+	var invalid []string
+	for _, a := range u.accounts() {
+		for _, n := range a.Names {
+			if !validName(a, n) {
+				invalid = append(invalid, fmt.Sprintf("%q", n))
+			}
+		}
+	}
+	if len(invalid) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid domain name(s) %s: DuckDNS names are letters, digits and "+
+		"inner hyphens, other providers expect full host names", strings.Join(invalid, ", "))
+}
+
+// hasName reports whether some account has n among its names
+func (u *Update) hasName(n string) bool {
+	for _, a := range u.accounts() {
+		if containsName(a.Names, normalizeName(a, n)) {
+			return true
+		}
+	}
+	return false
+}
+
+// nameCount is the number of names across all accounts
+func (u *Update) nameCount() int {
+	n := 0
+	for _, a := range u.accounts() {
+		n += len(a.Names)
+	}
+	return n
+}
+
+// fixedAddr is an address set in the config for a name, sent instead of the
+// detected one
+type fixedAddr struct {
+	ip, ipv6 string
+}
+
+// fixedAddrs maps the names with an entry in addresses to what they are set
+// to. The keys are matched the way names are normalized.
+func (u *Update) fixedAddrs() map[string]fixedAddr {
+	if len(u.Addresses) == 0 {
+		return nil
+	}
+	fixed := map[string]fixedAddr{}
+	for _, a := range u.accounts() {
+		for key, value := range u.Addresses {
+			name := normalizeName(a, key)
+			if !containsName(a.Names, name) {
+				continue
+			}
+			ip, ipv6, err := splitAddrs(value)
+			if err != nil {
+				// validate reports it, the name is left to detection meanwhile
+				logrus.WithError(err).Warnf("ignoring the address of %s", name)
+				continue
+			}
+			fixed[name] = fixedAddr{ip, ipv6}
+		}
+	}
+	return fixed
+}
+
+// splitAddrs sorts a comma separated list of addresses into the IPv4 and
+// IPv6 one, the last of each family winning
+func splitAddrs(list string) (ip, ipv6 string, err error) {
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		parsed := net.ParseIP(v)
+		switch {
+		case parsed == nil:
+			return "", "", fmt.Errorf("%q is not an IP address", v)
+		case parsed.To4() != nil:
+			ip = parsed.String()
+		default:
+			ipv6 = parsed.String()
+		}
+	}
+	return ip, ipv6, nil
+}
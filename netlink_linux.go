@@ -0,0 +1,162 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/sirupsen/logrus"
+)
+
+// addressSettle is the wait after an address or route event before updating,
+// as a reconnecting uplink sends a burst of them and a new IPv6 address is
+// only usable once duplicate address detection is done
+const addressSettle = 2 * time.Second
+
+// ifaFlagTentative marks an IPv6 address still going through duplicate
+// address detection
+const ifaFlagTentative = 0x40
+
+// rtnetlink multicast groups for address and route changes, which syscall
+// doesn't define
+const (
+	rtmgrpIPv4Ifaddr = 0x10
+	rtmgrpIPv4Route  = 0x40
+	rtmgrpIPv6Ifaddr = 0x100
+	rtmgrpIPv6Route  = 0x400
+)
+
+// watchAddresses signals on the returned channel when an address is added to
+// or removed from iface, or its default route changes, until ctx is done.
+// With no interface every one but loopback counts. The events come from
+// rtnetlink, so the daemon reacts within seconds instead of at the next check.
+func watchAddresses(ctx context.Context, iface string) (<-chan struct{}, error) {
+	index := 0
+	if iface != "" {
+		ifi, err := net.InterfaceByName(iface)
+		if err != nil {
+			return nil, err
+		}
+		index = ifi.Index
+	}
+
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+	groups := uint32(rtmgrpIPv4Ifaddr | rtmgrpIPv6Ifaddr | rtmgrpIPv4Route | rtmgrpIPv6Route)
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: groups}); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	// wake up every second to notice ctx being done
+	tv := syscall.Timeval{Sec: 1}
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	events := make(chan struct{}, 1)
+	go func() {
+		defer syscall.Close(fd)
+		buf := make([]byte, 1<<16)
+		for ctx.Err() == nil {
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				continue
+			}
+			if err != nil {
+				logrus.WithError(err).Warn("no longer watching for address changes")
+				return
+			}
+			msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, m := range msgs {
+				if addressEvent(m, index) {
+					select {
+					case events <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+
+	changed := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-events:
+			}
+			// let the burst pass, the events in it only need one update
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(addressSettle):
+			}
+			select {
+			case <-events:
+			default:
+			}
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changed, nil
+}
+
+// addressEvent reports whether m is about a usable address or the default
+// route of the interface with index, or of any interface but loopback when
+// index is 0
+func addressEvent(m syscall.NetlinkMessage, index int) bool {
+	switch m.Header.Type {
+	case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+		if len(m.Data) < syscall.SizeofIfAddrmsg {
+			return false
+		}
+		// netlink structs are in host byte order
+		msg := (*syscall.IfAddrmsg)(unsafe.Pointer(&m.Data[0]))
+		if msg.Scope != syscall.RT_SCOPE_UNIVERSE || msg.Flags&ifaFlagTentative != 0 {
+			return false
+		}
+		return matchesInterface(int(msg.Index), index)
+	case syscall.RTM_NEWROUTE, syscall.RTM_DELROUTE:
+		if len(m.Data) < syscall.SizeofRtMsg {
+			return false
+		}
+		msg := (*syscall.RtMsg)(unsafe.Pointer(&m.Data[0]))
+		if msg.Dst_len != 0 || msg.Table != syscall.RT_TABLE_MAIN {
+			return false
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+		if err != nil {
+			return false
+		}
+		for _, a := range attrs {
+			if a.Attr.Type == syscall.RTA_OIF && len(a.Value) >= 4 {
+				return matchesInterface(int(*(*uint32)(unsafe.Pointer(&a.Value[0]))), index)
+			}
+		}
+		return index == 0
+	}
+	return false
+}
+
+func matchesInterface(ifindex, index int) bool {
+	if index != 0 {
+		return ifindex == index
+	}
+	ifi, err := net.InterfaceByIndex(ifindex)
+	return err != nil || ifi.Flags&net.FlagLoopback == 0
+}
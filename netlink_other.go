@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+func watchAddresses(ctx context.Context, iface string) (<-chan struct{}, error) {
+	return nil, errors.New("watching for address changes needs rtnetlink, which only Linux has")
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Notify configures who is told about address changes and failed updates
+type Notify struct {
+	// FailureThreshold is how many runs in a row a name must fail before the
+	// failure is reported, once per streak. It defaults to 1.
+	FailureThreshold int `yaml:"failure_threshold"`
+
+	// Webhooks each receive a JSON POST for every event
+	Webhooks []string `yaml:"webhooks"`
+	// WebhookTemplate replaces the JSON of the event posted to Webhooks
+	WebhookTemplate string `yaml:"webhook_template"`
+	// WebhookContentType is sent with WebhookTemplate, application/json by
+	// default
+	WebhookContentType string `yaml:"webhook_content_type"`
+	// Slack posts messages through an incoming webhook
+	Slack SlackNotify `yaml:"slack"`
+	// Discord posts messages through a channel webhook
+	Discord DiscordNotify `yaml:"discord"`
+	// Telegram sends messages from a bot to a chat
+	Telegram TelegramNotify `yaml:"telegram"`
+	// Email sends a summary of each run over SMTP
+	Email EmailNotify `yaml:"email"`
+	// Ntfy publishes push messages to an ntfy topic
+	Ntfy NtfyNotify `yaml:"ntfy"`
+	// Pushover sends push messages through a Pushover application
+	Pushover PushoverNotify `yaml:"pushover"`
+}
+
+// merge fills the settings left unset in n from o
+func (n *Notify) merge(o Notify) {
+	if n.FailureThreshold == 0 {
+		n.FailureThreshold = o.FailureThreshold
+	}
+	if len(n.Webhooks) == 0 {
+		n.Webhooks = o.Webhooks
+	}
+	if n.WebhookTemplate == "" {
+		n.WebhookTemplate = o.WebhookTemplate
+	}
+	if n.WebhookContentType == "" {
+		n.WebhookContentType = o.WebhookContentType
+	}
+	if n.Slack.Webhook == "" {
+		n.Slack = o.Slack
+	}
+	if n.Discord.Webhook == "" {
+		n.Discord = o.Discord
+	}
+	if n.Telegram.BotToken == "" {
+		n.Telegram = o.Telegram
+	}
+	if n.Email.Host == "" {
+		n.Email = o.Email
+	}
+	if n.Ntfy.Topic == "" {
+		n.Ntfy = o.Ntfy
+	}
+	if n.Pushover.AppToken == "" {
+		n.Pushover = o.Pushover
+	}
+}
+
+func (n *Notify) threshold() int {
+	if n.FailureThreshold <= 0 {
+		return 1
+	}
+	return n.FailureThreshold
+}
+
+// notifyEvent is one thing worth telling someone about, for a single name
+type notifyEvent struct {
+	Domain string `json:"domain"`
+	// FQDN is the full host name of Domain, under duckdns.org or another
+	// provider's zone
+	FQDN    string `json:"fqdn"`
+	OldIP   string `json:"old_ip,omitempty"`
+	NewIP   string `json:"new_ip,omitempty"`
+	OldIPv6 string `json:"old_ipv6,omitempty"`
+	NewIPv6 string `json:"new_ipv6,omitempty"`
+	Result  string `json:"result"`
+	Error   string `json:"error,omitempty"`
+	// Failures is how many runs in a row failed for the name
+	Failures int `json:"failures,omitempty"`
+	// Hostname is the machine the update ran on
+	Hostname string    `json:"hostname,omitempty"`
+	Time     time.Time `json:"timestamp"`
+}
+
+// defaultTemplate is the message chat notifiers send unless configured
+// otherwise
+const defaultTemplate = `{{if eq .Result "changed"}}{{.FQDN}} now points at {{.NewIP}}` +
+	`{{if .OldIP}} (was {{.OldIP}}){{end}}` +
+	`{{else}}Updating {{.FQDN}} failed {{.Failures}} time(s) in a row: {{.Error}}{{end}}`
+
+// templateFuncs are available to every template: json quotes a value for a
+// JSON payload, join joins a list
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"join": strings.Join,
+}
+
+// parseTemplate parses a message template, falling back to defaultTemplate
+func parseTemplate(name, text string) (*template.Template, error) {
+	if text == "" {
+		text = defaultTemplate
+	}
+	t, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template: %v", name, err)
+	}
+	return t, nil
+}
+
+// message renders the text describing ev
+func message(t *template.Template, ev notifyEvent) (string, error) {
+	var b bytes.Buffer
+	if err := t.Execute(&b, ev); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+const (
+	eventChanged = "changed"
+	eventFailed  = "failed"
+)
+
+// notifier delivers events to one destination
+type notifier interface {
+	Name() string
+	Notify(ctx context.Context, ev notifyEvent) error
+}
+
+// newNotifiers returns a notifier for every configured destination
+func newNotifiers(n Notify, hc *http.Client) ([]notifier, error) {
+	var notifiers []notifier
+	var webhookTemplate *template.Template
+	if n.WebhookTemplate != "" && len(n.Webhooks) > 0 {
+		t, err := parseTemplate("webhook", n.WebhookTemplate)
+		if err != nil {
+			return nil, err
+		}
+		webhookTemplate = t
+	}
+	contentType := n.WebhookContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	for _, u := range n.Webhooks {
+		if _, err := url.Parse(u); err != nil {
+			return nil, fmt.Errorf("invalid webhook URL: %v", err)
+		}
+		notifiers = append(notifiers, &webhookNotifier{url: u, template: webhookTemplate,
+			contentType: contentType, hc: hc})
+	}
+	if n.Slack.Webhook != "" {
+		s, err := newSlackNotifier(n.Slack, hc)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, s)
+	}
+	if n.Discord.Webhook != "" {
+		d, err := newDiscordNotifier(n.Discord, hc)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, d)
+	}
+	if n.Telegram.BotToken != "" {
+		if n.Telegram.ChatID == "" {
+			return nil, fmt.Errorf("telegram notifications need a chat_id")
+		}
+		t, err := newTelegramNotifier(n.Telegram, hc)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, t)
+	}
+	if n.Email.Host != "" {
+		e, err := newEmailNotifier(n.Email, hc)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, e)
+	}
+	if n.Ntfy.Topic != "" {
+		nt, err := newNtfyNotifier(n.Ntfy, hc)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, nt)
+	}
+	if n.Pushover.AppToken != "" {
+		if n.Pushover.UserKey == "" {
+			return nil, fmt.Errorf("pushover notifications need a user_key")
+		}
+		p, err := newPushoverNotifier(n.Pushover, hc)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, p)
+	}
+	return notifiers, nil
+}
+
+// notifyEvents lists what a run should report: names DuckDNS moved to a new
+// address and names that just reached threshold failures in a row. cache must
+// still hold the previous addresses.
+func notifyEvents(cache *ipCache, results []Result, err error, threshold int) []notifyEvent {
+	now := time.Now()
+	hostname, _ := os.Hostname()
+
+	var events []notifyEvent
+	for _, r := range results {
+		last := cache.Domains[r.Domain]
+		if r.Updated && (r.IP != last.IP || r.IPv6 != last.IPv6) {
+			events = append(events, notifyEvent{
+				Domain:   r.Domain,
+				FQDN:     fqdn(r.Domain),
+				OldIP:    last.IP,
+				NewIP:    r.IP,
+				OldIPv6:  last.IPv6,
+				NewIPv6:  r.IPv6,
+				Result:   eventChanged,
+				Hostname: hostname,
+				Time:     now,
+			})
+		}
+	}
+
+	if e, ok := err.(*updateError); ok {
+		var domains []string
+		for d := range e.failures {
+			if cache.Domains[d].Failures == threshold {
+				domains = append(domains, d)
+			}
+		}
+		sort.Strings(domains)
+		for _, d := range domains {
+			events = append(events, notifyEvent{
+				Domain:   d,
+				FQDN:     fqdn(d),
+				OldIP:    cache.Domains[d].IP,
+				OldIPv6:  cache.Domains[d].IPv6,
+				Result:   eventFailed,
+				Error:    e.failures[d],
+				Failures: cache.Domains[d].Failures,
+				Hostname: hostname,
+				Time:     now,
+			})
+		}
+	}
+
+	return events
+}
+
+// summaryNotifier is a notifier that prefers one message covering the whole
+// run over one per event
+type summaryNotifier interface {
+	notifier
+	NotifySummary(ctx context.Context, events []notifyEvent) error
+}
+
+// sendNotifications hands every event to every notifier. Failures are only
+// logged, a broken webhook shouldn't fail the update itself.
+func sendNotifications(ctx context.Context, notifiers []notifier, events []notifyEvent) {
+	for _, n := range notifiers {
+		if s, ok := n.(summaryNotifier); ok {
+			if err := s.NotifySummary(ctx, events); err != nil {
+				logrus.WithError(err).Warnf("unable to notify %s", n.Name())
+				continue
+			}
+			logrus.Debugf("notified %s of %d event(s)", n.Name(), len(events))
+			continue
+		}
+
+		for _, ev := range events {
+			if err := n.Notify(ctx, ev); err != nil {
+				logrus.WithError(err).Warnf("unable to notify %s about %s", n.Name(), ev.Domain)
+				continue
+			}
+			logrus.Debugf("notified %s that %s %s", n.Name(), ev.Domain, ev.Result)
+		}
+	}
+}
+
+// notify runs the hooks for the events of a run and sends them to the
+// configured destinations, giving up on both once ctx is done
+func notify(ctx context.Context, update Update, events []notifyEvent) {
+	if len(events) == 0 {
+		return
+	}
+	runEventHooks(ctx, update.Hooks, events)
+	hc, err := newHTTPClient(update)
+	if err != nil {
+		logrus.WithError(err).Warn("unable to send notifications")
+		return
+	}
+	notifiers, err := newNotifiers(update.Notify, hc)
+	if err != nil {
+		logrus.WithError(err).Warn("unable to send notifications")
+		return
+	}
+	sendNotifications(ctx, notifiers, events)
+}
+
+// webhookNotifier POSTs each event as JSON, or as rendered by template
+type webhookNotifier struct {
+	url         string
+	template    *template.Template
+	contentType string
+	hc          *http.Client
+}
+
+func (w *webhookNotifier) Name() string {
+	u, err := url.Parse(w.url)
+	if err != nil {
+		return "webhook"
+	}
+	// the path of a webhook URL is often its secret
+	return "webhook " + u.Host
+}
+
+func (w *webhookNotifier) Notify(ctx context.Context, ev notifyEvent) error {
+	if w.template != nil {
+		body, err := message(w.template, ev)
+		if err != nil {
+			return err
+		}
+		return postNotification(ctx, w.hc, w.url, w.contentType, []byte(body))
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return postNotification(ctx, w.hc, w.url, "application/json", body)
+}
+
+// postNotification sends body to u
+func postNotification(ctx context.Context, hc *http.Client, u, contentType string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return sendNotification(ctx, hc, req)
+}
+
+// sendNotification sends req and checks for a 2xx answer
+func sendNotification(ctx context.Context, hc *http.Client, req *http.Request) error {
+	resp, err := hc.Do(req.WithContext(ctx))
+	if ue, ok := err.(*url.Error); ok {
+		// drop the URL, which may carry a secret
+		return ue.Err
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestDefaultTemplate(t *testing.T) {
+	tmpl, err := parseTemplate("default", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := &ipCache{Domains: map[string]cachedIP{
+		"home":             {IP: "203.0.113.5"},
+		"home.example.com": {IP: "203.0.113.5", Failures: 3},
+	}}
+	tests := []struct {
+		name    string
+		results []Result
+		err     error
+		want    string
+	}{
+		{
+			name:    "DuckDNS subdomain",
+			results: []Result{{Domain: "home", IP: "203.0.113.9", Updated: true}},
+			want:    "home.duckdns.org now points at 203.0.113.9 (was 203.0.113.5)",
+		},
+		{
+			name:    "host of another provider",
+			results: []Result{{Domain: "home.example.com", IP: "203.0.113.9", Updated: true}},
+			want:    "home.example.com now points at 203.0.113.9 (was 203.0.113.5)",
+		},
+		{
+			name: "failure",
+			err:  &updateError{failures: map[string]string{"home.example.com": "badauth"}},
+			want: "Updating home.example.com failed 3 time(s) in a row: badauth",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := notifyEvents(cache, tt.results, tt.err, 3)
+			if len(events) != 1 {
+				t.Fatalf("notifyEvents() = %+v, want one event", events)
+			}
+			got, err := message(tmpl, events[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("message() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// NtfyNotify configures messages published to an ntfy topic
+type NtfyNotify struct {
+	// Topic is the full topic URL, such as https://ntfy.sh/mytopic
+	Topic string `yaml:"topic"`
+	// Token is an access token for protected topics
+	Token    string `yaml:"token"`
+	Template string `yaml:"template"`
+}
+
+type ntfyNotifier struct {
+	topic    string
+	token    string
+	template *template.Template
+	hc       *http.Client
+}
+
+func newNtfyNotifier(c NtfyNotify, hc *http.Client) (*ntfyNotifier, error) {
+	if u, err := url.Parse(c.Topic); err != nil || u.Host == "" {
+		return nil, fmt.Errorf("ntfy topic %q is not a URL", c.Topic)
+	}
+	t, err := parseTemplate("ntfy", c.Template)
+	if err != nil {
+		return nil, err
+	}
+	return &ntfyNotifier{topic: c.Topic, token: c.Token, template: t, hc: hc}, nil
+}
+
+func (n *ntfyNotifier) Name() string { return "ntfy" }
+
+func (n *ntfyNotifier) Notify(ctx context.Context, ev notifyEvent) error {
+	text, err := message(n.template, ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, n.topic, strings.NewReader(text))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", "DuckDNS")
+	if ev.Result == eventFailed {
+		req.Header.Set("Priority", "high")
+		req.Header.Set("Tags", "warning")
+	}
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+	return sendNotification(ctx, n.hc, req)
+}
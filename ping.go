@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ping tells a healthchecks.io style check about a run. suffix is "/start",
+// "" for success or "/fail", and body ends up in the check's log. Failures are
+// only logged, the monitor noticing the missing ping is the point.
+func ping(update Update, suffix, body string) {
+	if update.PingURL == "" {
+		return
+	}
+	hc, err := newHTTPClient(update)
+	if err != nil {
+		logrus.WithError(err).Warn("unable to ping the health check")
+		return
+	}
+
+	u := strings.TrimSuffix(update.PingURL, "/") + suffix
+	err = postNotification(context.Background(), hc, u, "text/plain", []byte(body))
+	if err != nil {
+		logrus.WithError(err).Warnf("unable to ping the health check%s", suffix)
+		return
+	}
+	logrus.Debugf("pinged the health check%s", suffix)
+}
+
+// pingFinished pings success or failure depending on err
+func pingFinished(update Update, err error) {
+	if err != nil {
+		ping(update, "/fail", redact(err.Error()))
+		return
+	}
+	ping(update, "", "")
+}
@@ -0,0 +1,234 @@
+// Package duckdns is a client for the DuckDNS update API at
+// https://www.duckdns.org/spec.jsp
+package duckdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultEndpoint is the update URL of the public DuckDNS service
+const DefaultEndpoint = "https://www.duckdns.org/update"
+
+// ErrKO is returned when DuckDNS rejects an update, which happens for a bad
+// token or a domain that does not belong to the account
+var ErrKO = errors.New("duckdns: update rejected")
+
+// ErrUnavailable matches, through errors.Is, a StatusError for 429 Too Many
+// Requests or a 5xx status, which mean the service is throttling or in
+// trouble rather than the request being wrong
+var ErrUnavailable = errors.New("duckdns: service unavailable")
+
+// StatusError is returned when the endpoint answers with an HTTP status other
+// than 200 OK. DuckDNS itself reports rejected updates as KO, so this points
+// at an outage, a proxy or a wrong endpoint instead.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	// RetryAfter is the wait the server asked for in Retry-After, 0 without
+	RetryAfter time.Duration
+}
+
+// NewStatusError returns the StatusError for res, for clients of other
+// services that retry under a RetryPolicy as well
+func NewStatusError(res *http.Response) *StatusError {
+	return &StatusError{
+		StatusCode: res.StatusCode,
+		Status:     res.Status,
+		RetryAfter: parseRetryAfter(res.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+func (e *StatusError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("duckdns: unexpected HTTP status %s, retry after %s", e.Status, e.RetryAfter)
+	}
+	return "duckdns: unexpected HTTP status " + e.Status
+}
+
+// Is lets errors.Is(err, ErrUnavailable) pick out throttling and outages
+func (e *StatusError) Is(target error) bool {
+	return target == ErrUnavailable &&
+		(e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500)
+}
+
+// parseRetryAfter reads a Retry-After header, given in seconds or as a date
+func parseRetryAfter(h string, now time.Time) time.Duration {
+	if h == "" {
+		return 0
+	}
+	if s, err := strconv.Atoi(strings.TrimSpace(h)); err == nil {
+		if s < 0 {
+			return 0
+		}
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil && t.After(now) {
+		return t.Sub(now).Round(time.Second)
+	}
+	return 0
+}
+
+// Client sends updates to DuckDNS on behalf of a single account
+type Client struct {
+	// Token is the account token shown on the DuckDNS dashboard
+	Token string
+	// Endpoint is the update URL, DefaultEndpoint when empty
+	Endpoint string
+	// HTTPClient is used for requests, http.DefaultClient when nil
+	HTTPClient *http.Client
+	// UserAgent is sent with every request when set
+	UserAgent string
+	// Retry is applied to every request, the zero value makes a single try
+	Retry RetryPolicy
+	// OnRetry is called, when set, before waiting to retry a failed request
+	OnRetry func(domain string, attempt int, wait time.Duration, err error)
+	// Limiter, when set, is waited on before every request including retries
+	Limiter *RateLimiter
+}
+
+// NewClient returns a Client for the account owning token
+func NewClient(token string) *Client {
+	return &Client{Token: token}
+}
+
+// Update sets the address of domain to ip. An empty ip lets DuckDNS use the
+// address the request came from.
+func (c *Client) Update(ctx context.Context, domain, ip string) (Result, error) {
+	return c.do(ctx, c.UpdateURL(domain, ip), domain, parseResponse)
+}
+
+// UpdateDualStack sets the IPv4 and IPv6 addresses of domain in one request,
+// keeping the A and AAAA records in step. An empty ip lets DuckDNS use the
+// address the request came from, an empty ipv6 leaves the AAAA record alone.
+func (c *Client) UpdateDualStack(ctx context.Context, domain, ip, ipv6 string) (Result, error) {
+	return c.do(ctx, c.UpdateDualStackURL(domain, ip, ipv6), domain, parseResponse)
+}
+
+// UpdateTXT sets the TXT record of domain to txt
+func (c *Client) UpdateTXT(ctx context.Context, domain, txt string) (Result, error) {
+	return c.do(ctx, c.UpdateTXTURL(domain, txt), domain, parseTXTResponse)
+}
+
+// ClearTXT removes the TXT record of domain
+func (c *Client) ClearTXT(ctx context.Context, domain string) (Result, error) {
+	return c.do(ctx, c.ClearTXTURL(domain), domain, parseTXTResponse)
+}
+
+// Clear removes the IPv4 and IPv6 addresses of domain
+func (c *Client) Clear(ctx context.Context, domain string) (Result, error) {
+	return c.do(ctx, c.ClearURL(domain), domain, parseResponse)
+}
+
+// UpdateURL returns the request URL that Update would use
+func (c *Client) UpdateURL(domain, ip string) string {
+	q := url.Values{}
+	q.Set("ip", ip)
+	return c.url(domain, q)
+}
+
+// UpdateDualStackURL returns the request URL that UpdateDualStack would use
+func (c *Client) UpdateDualStackURL(domain, ip, ipv6 string) string {
+	q := url.Values{}
+	q.Set("ip", ip)
+	if ipv6 != "" {
+		q.Set("ipv6", ipv6)
+	}
+	return c.url(domain, q)
+}
+
+// UpdateTXTURL returns the request URL that UpdateTXT would use
+func (c *Client) UpdateTXTURL(domain, txt string) string {
+	q := url.Values{}
+	q.Set("txt", txt)
+	return c.url(domain, q)
+}
+
+// ClearURL returns the request URL that Clear would use
+func (c *Client) ClearURL(domain string) string {
+	q := url.Values{}
+	q.Set("clear", "true")
+	return c.url(domain, q)
+}
+
+// ClearTXTURL returns the request URL that ClearTXT would use
+func (c *Client) ClearTXTURL(domain string) string {
+	q := url.Values{}
+	q.Set("txt", "")
+	q.Set("clear", "true")
+	return c.url(domain, q)
+}
+
+func (c *Client) url(domain string, q url.Values) string {
+	q.Set("domains", domain)
+	q.Set("token", c.Token)
+	q.Set("verbose", "true")
+
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	return endpoint + "?" + q.Encode()
+}
+
+func (c *Client) do(ctx context.Context, u, domain string,
+	parse func(string) (Result, error)) (Result, error) {
+
+	return c.retry(ctx, func() (Result, error) {
+		return c.get(ctx, u, domain, parse)
+	})
+}
+
+func (c *Client) get(ctx context.Context, u, domain string,
+	parse func(string) (Result, error)) (Result, error) {
+
+	if err := c.Limiter.Wait(ctx); err != nil {
+		return Result{Domain: domain}, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return Result{Domain: domain}, err
+	}
+	req = req.WithContext(ctx)
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+
+	res, err := hc.Do(req)
+	if err != nil {
+		return Result{Domain: domain}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return Result{Domain: domain}, NewStatusError(res)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return Result{Domain: domain}, fmt.Errorf("duckdns: reading response: %v", err)
+	}
+
+	r, err := parse(string(body))
+	r.Domain = domain
+	if err != nil {
+		return r, err
+	}
+	if !r.OK {
+		return r, ErrKO
+	}
+
+	return r, nil
+}
@@ -0,0 +1,246 @@
+// Package duckdnstest is an in-memory stand-in for the DuckDNS update API, to
+// run clients against in tests and CI without touching real records
+package duckdnstest
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Path is where the update API is served, as on www.duckdns.org
+const Path = "/update"
+
+// Record is what the server holds for one domain
+type Record struct {
+	IP   string `json:"ip,omitempty"`
+	IPv6 string `json:"ipv6,omitempty"`
+	TXT  string `json:"txt,omitempty"`
+}
+
+// Request is an update the server answered
+type Request struct {
+	Domains []string
+	IP      string
+	IPv6    string
+	TXT     string
+	Clear   bool
+	// OK is false when the update was answered with KO
+	OK bool
+}
+
+// Server answers update requests the way DuckDNS does: KO for an unknown
+// token, a domain of another account or an invalid address, OK otherwise, and
+// the verbose form with the addresses or TXT value and UPDATED or NOCHANGE
+// when verbose=true is set. A missing ip is taken from the address the
+// request came from. Several domains can be given at once, separated by
+// commas, with or without the .duckdns.org suffix.
+type Server struct {
+	// AcceptAny accepts every token and domain, adding domains on first use
+	AcceptAny bool
+	// OnRequest is called, when set, after every answered update
+	OnRequest func(Request)
+
+	mu       sync.Mutex
+	owners   map[string]string // domain to token
+	records  map[string]*Record
+	requests []Request
+}
+
+// NewServer returns a Server where token owns domains
+func NewServer(token string, domains ...string) *Server {
+	s := &Server{owners: map[string]string{}, records: map[string]*Record{}}
+	s.Add(token, domains...)
+	return s
+}
+
+// Add gives the account of token more domains, creating it if needed
+func (s *Server) Add(token string, domains ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range domains {
+		d = domainName(d)
+		s.owners[d] = token
+		if s.records[d] == nil {
+			s.records[d] = &Record{}
+		}
+	}
+}
+
+// Record returns what domain is currently set to
+func (s *Server) Record(domain string) (Record, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.records[domainName(domain)]
+	if !ok {
+		return Record{}, false
+	}
+	return *r, true
+}
+
+// Records returns every domain and what it is set to
+func (s *Server) Records() map[string]Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]Record, len(s.records))
+	for d, r := range s.records {
+		out[d] = *r
+	}
+	return out
+}
+
+// Domains lists the domains the server knows, sorted
+func (s *Server) Domains() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []string
+	for d := range s.records {
+		out = append(out, d)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Requests returns the updates answered so far, oldest first
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Start serves s on a random local port. The endpoint for clients is the
+// returned server's URL followed by Path; close the server when done.
+func (s *Server) Start() *httptest.Server {
+	return httptest.NewServer(s)
+}
+
+// ServeHTTP answers requests to Path and 404s anything else
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != Path {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	req := Request{
+		IP:    q.Get("ip"),
+		IPv6:  q.Get("ipv6"),
+		TXT:   q.Get("txt"),
+		Clear: q.Get("clear") == "true",
+	}
+	for _, d := range strings.Split(q.Get("domains"), ",") {
+		if d = domainName(d); d != "" {
+			req.Domains = append(req.Domains, d)
+		}
+	}
+	_, isTXT := q["txt"]
+	if !isTXT && !req.Clear && req.IP == "" {
+		if ip := remoteIP(r); net.ParseIP(ip).To4() != nil || req.IPv6 == "" {
+			req.IP = ip
+		}
+	}
+	// DuckDNS moves an IPv6 address given as ip to ipv6
+	if ip := net.ParseIP(req.IP); ip != nil && ip.To4() == nil && req.IPv6 == "" {
+		req.IP, req.IPv6 = "", req.IP
+	}
+
+	body, ok := s.update(q.Get("token"), req, isTXT)
+	if q.Get("verbose") != "true" {
+		body = strings.SplitN(body, "\n", 2)[0]
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	fmt.Fprint(w, body)
+
+	req.OK = ok
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	onRequest := s.OnRequest
+	s.mu.Unlock()
+	if onRequest != nil {
+		onRequest(req)
+	}
+}
+
+// update applies req for token and returns the verbose answer
+func (s *Server) update(token string, req Request, isTXT bool) (string, bool) {
+	if len(req.Domains) == 0 || token == "" || !validIP(req.IP, false) || !validIP(req.IPv6, true) {
+		return "KO", false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range req.Domains {
+		owner, ok := s.owners[d]
+		if s.AcceptAny && !ok {
+			continue
+		}
+		if !ok || owner != token {
+			return "KO", false
+		}
+	}
+
+	updated := false
+	var last Record
+	for _, d := range req.Domains {
+		r := s.records[d]
+		if r == nil {
+			s.owners[d] = token
+			r = &Record{}
+			s.records[d] = r
+		}
+		before := *r
+		switch {
+		case isTXT && req.Clear:
+			r.TXT = ""
+		case isTXT:
+			r.TXT = req.TXT
+		case req.Clear:
+			r.IP, r.IPv6 = "", ""
+		default:
+			if req.IP != "" {
+				r.IP = req.IP
+			}
+			if req.IPv6 != "" {
+				r.IPv6 = req.IPv6
+			}
+		}
+		updated = updated || *r != before
+		last = *r
+	}
+
+	status := "NOCHANGE"
+	if updated {
+		status = "UPDATED"
+	}
+	if isTXT {
+		return fmt.Sprintf("OK\n%s\n%s", last.TXT, status), true
+	}
+	return fmt.Sprintf("OK\n%s\n%s\n%s", last.IP, last.IPv6, status), true
+}
+
+// domainName is the bare subdomain DuckDNS keys records by
+func domainName(d string) string {
+	d = strings.ToLower(strings.TrimSpace(d))
+	return strings.TrimSuffix(strings.TrimSuffix(d, "."), ".duckdns.org")
+}
+
+// validIP reports whether s is empty or an address of the family
+func validIP(s string, v6 bool) bool {
+	if s == "" {
+		return true
+	}
+	ip := net.ParseIP(s)
+	return ip != nil && (ip.To4() == nil) == v6
+}
+
+// remoteIP is the address the request came from
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	return host
+}
@@ -0,0 +1,95 @@
+// Package provider implements the libdns interfaces on top of the DuckDNS TXT
+// API, so that Caddy and other libdns consumers can solve ACME DNS challenges
+// for duckdns.org names
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/theag3nt/duckdns/pkg/duckdns"
+)
+
+// ttl is the fixed TTL of every DuckDNS record
+const ttl = 60 * time.Second
+
+// Provider manages the TXT records of the names owned by one DuckDNS account.
+// DuckDNS keeps a single TXT value per name, shared by every name below it, so
+// appending a record replaces any value already there.
+type Provider struct {
+	// APIToken is the account token shown on the DuckDNS dashboard
+	APIToken string `json:"api_token,omitempty"`
+	// Endpoint overrides the update URL, duckdns.DefaultEndpoint when empty
+	Endpoint string `json:"endpoint,omitempty"`
+	// HTTPClient is used for requests, http.DefaultClient when nil
+	HTTPClient *http.Client `json:"-"`
+}
+
+var (
+	_ libdns.RecordAppender = (*Provider)(nil)
+	_ libdns.RecordDeleter  = (*Provider)(nil)
+)
+
+// AppendRecords sets the TXT record of the DuckDNS name each record falls
+// under. Only TXT records are supported.
+func (p *Provider) AppendRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	c := p.client()
+	var added []libdns.Record
+	for _, r := range recs {
+		name, err := p.name(r, zone)
+		if err != nil {
+			return added, err
+		}
+		if _, err := c.UpdateTXT(ctx, name, r.Value); err != nil {
+			return added, fmt.Errorf("setting TXT record of %s: %w", name, err)
+		}
+		r.TTL = ttl
+		added = append(added, r)
+	}
+	return added, nil
+}
+
+// DeleteRecords clears the TXT record of the DuckDNS name each record falls
+// under. Only TXT records are supported.
+func (p *Provider) DeleteRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	c := p.client()
+	var deleted []libdns.Record
+	for _, r := range recs {
+		name, err := p.name(r, zone)
+		if err != nil {
+			return deleted, err
+		}
+		if _, err := c.ClearTXT(ctx, name); err != nil {
+			return deleted, fmt.Errorf("clearing TXT record of %s: %w", name, err)
+		}
+		deleted = append(deleted, r)
+	}
+	return deleted, nil
+}
+
+func (p *Provider) client() *duckdns.Client {
+	c := duckdns.NewClient(p.APIToken)
+	c.Endpoint = p.Endpoint
+	c.HTTPClient = p.HTTPClient
+	return c
+}
+
+// name finds the DuckDNS name a record belongs to, the label just before
+// duckdns.org: both _acme-challenge.example.duckdns.org and
+// example.duckdns.org give example
+func (p *Provider) name(r libdns.Record, zone string) (string, error) {
+	if r.Type != "TXT" {
+		return "", fmt.Errorf("DuckDNS only supports TXT records here, not %s", r.Type)
+	}
+	fqdn := strings.ToLower(strings.TrimSuffix(libdns.AbsoluteName(r.Name, zone), "."))
+	rest := strings.TrimSuffix(fqdn, ".duckdns.org")
+	if rest == fqdn || rest == "" {
+		return "", fmt.Errorf("%s is not a duckdns.org name", fqdn)
+	}
+	labels := strings.Split(rest, ".")
+	return labels[len(labels)-1], nil
+}
@@ -0,0 +1,60 @@
+package duckdns
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	tests := []struct {
+		name     string
+		limiter  *RateLimiter
+		requests int
+		atLeast  time.Duration
+	}{
+		{name: "nil limiter", limiter: nil, requests: 5},
+		{name: "unlimited", limiter: NewRateLimiter(0, 1), requests: 5},
+		{name: "burst", limiter: NewRateLimiter(60, 3), requests: 3},
+		// 6000 a minute is a request every 10ms once the burst is spent, with
+		// some slack for the time since the limiter was made
+		{name: "beyond the burst", limiter: NewRateLimiter(6000, 2), requests: 5, atLeast: 25 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			for i := 0; i < tt.requests; i++ {
+				if err := tt.limiter.Wait(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			elapsed := time.Since(start)
+			if elapsed < tt.atLeast {
+				t.Errorf("%d requests took %s, want at least %s", tt.requests, elapsed, tt.atLeast)
+			}
+			if tt.atLeast == 0 && elapsed > 100*time.Millisecond {
+				t.Errorf("%d requests took %s, want no wait", tt.requests, elapsed)
+			}
+		})
+	}
+}
+
+func TestRateLimiterCanceled(t *testing.T) {
+	l := NewRateLimiter(1, 1)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Wait() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	// the canceled caller gave its token back, so the next one waits a
+	// minute rather than two
+	l.mu.Lock()
+	tokens := l.tokens
+	l.mu.Unlock()
+	if tokens < -0.01 {
+		t.Errorf("tokens = %f after a canceled wait, want the token returned", tokens)
+	}
+}
@@ -0,0 +1,88 @@
+package duckdns
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Result is the parsed reply to a verbose update request for one domain
+type Result struct {
+	Domain  string
+	OK      bool
+	IP      string
+	IPv6    string
+	TXT     string
+	Updated bool
+}
+
+// Status returns the update status as reported by DuckDNS
+func (r Result) Status() string {
+	if r.Updated {
+		return "UPDATED"
+	}
+	return "NOCHANGE"
+}
+
+// parseResponse reads the body returned when verbose=true is set. The first
+// line is OK or KO, followed by the IPv4 address, the IPv6 address and either
+// UPDATED or NOCHANGE.
+func parseResponse(body string) (Result, error) {
+	lines, r, err := splitResponse(body, 4)
+	if err != nil || !r.OK {
+		return r, err
+	}
+	r.IP = lines[1]
+	r.IPv6 = lines[2]
+	r.Updated, err = parseStatus(lines[3])
+
+	return r, err
+}
+
+// parseTXTResponse reads the verbose body returned for a TXT update, which
+// carries the TXT value in place of the addresses.
+func parseTXTResponse(body string) (Result, error) {
+	lines, r, err := splitResponse(body, 3)
+	if err != nil || !r.OK {
+		return r, err
+	}
+	r.TXT = lines[1]
+	r.Updated, err = parseStatus(lines[len(lines)-1])
+
+	return r, err
+}
+
+// splitResponse breaks a verbose body into trimmed lines and checks the OK/KO
+// marker. A KO response is not an error here, it is reported through r.OK.
+func splitResponse(body string, want int) ([]string, Result, error) {
+	var r Result
+
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+
+	switch lines[0] {
+	case "OK":
+		r.OK = true
+	case "KO":
+		return lines, r, nil
+	default:
+		return lines, r, fmt.Errorf("duckdns: unexpected response %q", body)
+	}
+
+	if len(lines) < want {
+		return lines, r, fmt.Errorf("duckdns: short verbose response %q", body)
+	}
+
+	return lines, r, nil
+}
+
+func parseStatus(status string) (bool, error) {
+	switch status {
+	case "UPDATED":
+		return true, nil
+	case "NOCHANGE":
+		return false, nil
+	}
+	return false, fmt.Errorf("duckdns: unexpected update status %q", status)
+}
@@ -0,0 +1,108 @@
+package duckdns
+
+import "testing"
+
+func TestParseResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    Result
+		wantErr bool
+	}{
+		{
+			name: "updated",
+			body: "OK\n203.0.113.5\n2001:db8::1\nUPDATED",
+			want: Result{OK: true, IP: "203.0.113.5", IPv6: "2001:db8::1", Updated: true},
+		},
+		{
+			name: "no change without IPv6",
+			body: "OK\n203.0.113.5\n\nNOCHANGE\n",
+			want: Result{OK: true, IP: "203.0.113.5"},
+		},
+		{
+			name: "windows line endings",
+			body: "OK\r\n203.0.113.5\r\n\r\nUPDATED\r\n",
+			want: Result{OK: true, IP: "203.0.113.5", Updated: true},
+		},
+		{
+			name: "KO",
+			body: "KO",
+			want: Result{},
+		},
+		{
+			name:    "short",
+			body:    "OK\n203.0.113.5",
+			want:    Result{OK: true},
+			wantErr: true,
+		},
+		{
+			name:    "unknown status",
+			body:    "OK\n203.0.113.5\n\nMAYBE",
+			want:    Result{OK: true, IP: "203.0.113.5"},
+			wantErr: true,
+		},
+		{
+			name:    "not DuckDNS",
+			body:    "<html>Bad Gateway</html>",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			body:    "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseResponse(tt.body)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseResponse() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseResponse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTXTResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    Result
+		wantErr bool
+	}{
+		{
+			name: "updated",
+			body: "OK\nchallenge-value\nUPDATED",
+			want: Result{OK: true, TXT: "challenge-value", Updated: true},
+		},
+		{
+			name: "cleared",
+			body: "OK\n\nNOCHANGE\n",
+			want: Result{OK: true},
+		},
+		{
+			name: "KO",
+			body: "KO\n",
+			want: Result{},
+		},
+		{
+			name:    "short",
+			body:    "OK\nUPDATED",
+			want:    Result{OK: true},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTXTResponse(tt.body)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTXTResponse() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseTXTResponse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
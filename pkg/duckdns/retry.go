@@ -0,0 +1,94 @@
+package duckdns
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how requests that fail for transient reasons are
+// retried. A KO from DuckDNS is never retried since repeating it won't help.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of tries, values below 2 disable retries
+	MaxAttempts int
+	// InitialDelay is the wait before the first retry, doubled on each retry
+	InitialDelay time.Duration
+	// MaxDelay caps the wait between retries, unlimited when zero
+	MaxDelay time.Duration
+}
+
+// backoff returns the wait before retry number n (starting at 1) with jitter
+// applied, so that clients failing together don't retry in lockstep
+func (p RetryPolicy) backoff(n int) time.Duration {
+	d := p.InitialDelay
+	for i := 1; i < n; i++ {
+		d *= 2
+		if p.MaxDelay > 0 && d >= p.MaxDelay {
+			d = p.MaxDelay
+			break
+		}
+	}
+	if d <= 0 {
+		return 0
+	}
+	// somewhere between half and the full delay
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Backoff returns the wait before retry number n (starting at 1), for callers
+// retrying requests of their own under the same policy
+func (p RetryPolicy) Backoff(n int) time.Duration {
+	return p.backoff(n)
+}
+
+// Delay returns the wait before retry number n (starting at 1) after err, and
+// false when retrying won't help. A throttled or failing service gets twice
+// the usual wait, and at least what it asked for in Retry-After; when that is
+// beyond MaxDelay the request fails right away rather than retrying early.
+func (p RetryPolicy) Delay(n int, err error) (time.Duration, bool) {
+	if err == nil || errors.Is(err, ErrKO) {
+		return 0, false
+	}
+	var se *StatusError
+	if !errors.As(err, &se) {
+		return p.backoff(n), true
+	}
+	// a client error such as 404 will be the same next time
+	if !errors.Is(se, ErrUnavailable) {
+		return 0, false
+	}
+	if p.MaxDelay > 0 && se.RetryAfter > p.MaxDelay {
+		return 0, false
+	}
+	wait := p.backoff(n + 1)
+	if wait < se.RetryAfter {
+		wait = se.RetryAfter
+	}
+	return wait, true
+}
+
+// retry runs fn until it succeeds, fails permanently or runs out of attempts
+func (c *Client) retry(ctx context.Context, fn func() (Result, error)) (Result, error) {
+	r, err := fn()
+	for n := 1; n < c.Retry.MaxAttempts && ctx.Err() == nil; n++ {
+		wait, ok := c.Retry.Delay(n, err)
+		if !ok {
+			break
+		}
+		if c.OnRetry != nil {
+			c.OnRetry(r.Domain, n, wait, err)
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return r, ctx.Err()
+		case <-t.C:
+		}
+
+		r, err = fn()
+	}
+	return r, err
+}
@@ -0,0 +1,67 @@
+package duckdns
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, InitialDelay: time.Second, MaxDelay: 5 * time.Second}
+	tests := []struct {
+		n    int
+		full time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{10, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		// jitter puts the wait between half and the full delay
+		for i := 0; i < 20; i++ {
+			if got := p.Backoff(tt.n); got < tt.full/2 || got > tt.full {
+				t.Errorf("Backoff(%d) = %s, want between %s and %s", tt.n, got, tt.full/2, tt.full)
+				break
+			}
+		}
+	}
+
+	if got := (RetryPolicy{}).Backoff(1); got != 0 {
+		t.Errorf("Backoff() without a delay = %s, want 0", got)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3, InitialDelay: time.Second, MaxDelay: time.Minute}
+	tests := []struct {
+		name    string
+		err     error
+		wantOK  bool
+		atLeast time.Duration
+		atMost  time.Duration
+	}{
+		{name: "success", err: nil},
+		{name: "rejected", err: ErrKO},
+		{name: "network error", err: errors.New("connection reset"), wantOK: true,
+			atLeast: time.Second / 2, atMost: time.Second},
+		{name: "not found", err: &StatusError{StatusCode: 404}},
+		{name: "throttled backs off harder", err: &StatusError{StatusCode: 429}, wantOK: true,
+			atLeast: time.Second, atMost: 2 * time.Second},
+		{name: "Retry-After is honored", err: &StatusError{StatusCode: 503, RetryAfter: 30 * time.Second},
+			wantOK: true, atLeast: 30 * time.Second, atMost: 30 * time.Second},
+		{name: "Retry-After beyond MaxDelay", err: &StatusError{StatusCode: 503, RetryAfter: time.Hour}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := p.Delay(1, tt.err)
+			if ok != tt.wantOK {
+				t.Fatalf("Delay(%v) retries = %t, want %t", tt.err, ok, tt.wantOK)
+			}
+			if ok && (got < tt.atLeast || got > tt.atMost) {
+				t.Errorf("Delay(%v) = %s, want between %s and %s", tt.err, got, tt.atLeast, tt.atMost)
+			}
+		})
+	}
+}
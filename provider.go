@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/theag3nt/duckdns/pkg/duckdns"
+)
+
+// dnsProvider updates the records of one account at a dynamic DNS service.
+// Results are reported in the shape DuckDNS uses, since it came first.
+type dnsProvider interface {
+	Name() string
+	// Update sets the addresses of domain, leaving the IPv6 address alone
+	// when ipv6 is empty
+	Update(ctx context.Context, domain, ip, ipv6 string) (duckdns.Result, error)
+	Clear(ctx context.Context, domain string) (duckdns.Result, error)
+	// SupportsTXT reports whether UpdateTXT can work at all
+	SupportsTXT() bool
+	UpdateTXT(ctx context.Context, domain, txt string) (duckdns.Result, error)
+}
+
+// errRejected is returned by providers other than DuckDNS when the service
+// refuses an update, the equivalent of duckdns.ErrKO
+var errRejected = errors.New("update rejected")
+
+// isRejected reports whether err means the service refused the update, so that
+// retrying won't help
+func isRejected(err error) bool {
+	return errors.Is(err, duckdns.ErrKO) || errors.Is(err, errRejected)
+}
+
+// dyndns2Services are the services speaking the dyndns2 protocol that can be
+// picked by name, with their update URLs
+var dyndns2Services = map[string]struct{ title, endpoint string }{
+	"dynu":   {"Dynu", "https://api.dynu.com/nic/update"},
+	"afraid": {"FreeDNS", "https://freedns.afraid.org/nic/update"},
+}
+
+// providerName returns the configured provider of an account, duckdns unless
+// another one is set
+func providerName(a Account) string {
+	if a.Provider == "" {
+		return "duckdns"
+	}
+	return strings.ToLower(a.Provider)
+}
+
+// newDNSProvider returns the provider for an account. client is the DuckDNS
+// client set up from the rest of the configuration.
+func newDNSProvider(a Account, client *duckdns.Client) (dnsProvider, error) {
+	switch name := providerName(a); name {
+	case "duckdns":
+		c := *client
+		c.Token = a.Token
+		if a.Endpoint != "" {
+			c.Endpoint = a.Endpoint
+		}
+		return &duckdnsDNSProvider{&c}, nil
+	case "dynu", "afraid", "dyndns2":
+		service := dyndns2Services[name]
+		endpoint := a.Endpoint
+		if endpoint == "" {
+			endpoint = service.endpoint
+		}
+		e, err := url.Parse(endpoint)
+		if err != nil || e.Host == "" {
+			return nil, fmt.Errorf("the %s provider needs an endpoint URL", name)
+		}
+		if service.title == "" {
+			service.title = e.Host
+		}
+		if a.Username == "" {
+			return nil, fmt.Errorf("the %s provider needs a username", name)
+		}
+		return &dyndns2Provider{
+			name:     service.title,
+			endpoint: endpoint,
+			username: a.Username,
+			password: a.Token,
+			hc:       client.HTTPClient,
+			retry:    client.Retry,
+			onRetry:  client.OnRetry,
+			limiter:  client.Limiter,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q, expected duckdns, dynu, afraid or dyndns2", a.Provider)
+	}
+}
+
+// duckdnsDNSProvider is the library client, which already has the methods
+type duckdnsDNSProvider struct {
+	*duckdns.Client
+}
+
+func (p *duckdnsDNSProvider) Name() string      { return "DuckDNS" }
+func (p *duckdnsDNSProvider) SupportsTXT() bool { return true }
+
+func (p *duckdnsDNSProvider) Update(ctx context.Context, domain, ip, ipv6 string) (duckdns.Result, error) {
+	if ipv6 != "" {
+		return p.Client.UpdateDualStack(ctx, domain, ip, ipv6)
+	}
+	return p.Client.Update(ctx, domain, ip)
+}
+
+// dyndns2Provider speaks the dyndns2 protocol that Dynu, FreeDNS at afraid.org
+// and many others accept. The account token is the password.
+type dyndns2Provider struct {
+	// name is what logs call the service
+	name     string
+	endpoint string
+	username string
+	password string
+
+	hc      *http.Client
+	retry   duckdns.RetryPolicy
+	onRetry func(domain string, attempt int, wait time.Duration, err error)
+	limiter *duckdns.RateLimiter
+}
+
+func (p *dyndns2Provider) Name() string      { return p.name }
+func (p *dyndns2Provider) SupportsTXT() bool { return false }
+
+// updateURL returns the request URL that Update would use. dyndns2 takes
+// both addresses in myip, separated by a comma.
+func (p *dyndns2Provider) updateURL(domain, ip, ipv6 string) string {
+	q := url.Values{}
+	q.Set("hostname", domain)
+	switch {
+	case ip != "" && ipv6 != "":
+		q.Set("myip", ip+","+ipv6)
+	case ip != "" || ipv6 != "":
+		q.Set("myip", ip+ipv6)
+	}
+	return p.endpoint + "?" + q.Encode()
+}
+
+func (p *dyndns2Provider) Update(ctx context.Context, domain, ip, ipv6 string) (duckdns.Result, error) {
+	u := p.updateURL(domain, ip, ipv6)
+	r, err := p.get(ctx, u, domain)
+	for n := 1; n < p.retry.MaxAttempts && !isRejected(err) && ctx.Err() == nil; n++ {
+		wait, ok := p.retry.Delay(n, err)
+		if !ok {
+			break
+		}
+		if p.onRetry != nil {
+			p.onRetry(domain, n, wait, err)
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return r, ctx.Err()
+		case <-t.C:
+		}
+		r, err = p.get(ctx, u, domain)
+	}
+	return r, err
+}
+
+// updateURL returns the request an update of domain to ip and ipv6 would
+// send, for --dry-run
+func updateURL(p dnsProvider, domain, ip, ipv6 string) string {
+	switch p := p.(type) {
+	case *duckdnsDNSProvider:
+		// the same choice as Update
+		if ipv6 != "" {
+			return p.UpdateDualStackURL(domain, ip, ipv6)
+		}
+		return p.UpdateURL(domain, ip)
+	case *dyndns2Provider:
+		return p.updateURL(domain, ip, ipv6)
+	}
+	return ""
+}
+
+func (p *dyndns2Provider) Clear(ctx context.Context, domain string) (duckdns.Result, error) {
+	return duckdns.Result{Domain: domain}, fmt.Errorf("%s can't clear records", p.name)
+}
+
+func (p *dyndns2Provider) UpdateTXT(ctx context.Context, domain, txt string) (duckdns.Result, error) {
+	return duckdns.Result{Domain: domain}, fmt.Errorf("%s can't set TXT records", p.name)
+}
+
+func (p *dyndns2Provider) get(ctx context.Context, u, domain string) (duckdns.Result, error) {
+	r := duckdns.Result{Domain: domain}
+	if err := p.limiter.Wait(ctx); err != nil {
+		return r, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return r, err
+	}
+	req.SetBasicAuth(p.username, p.password)
+	req.Header.Set("User-Agent", userAgent())
+	resp, err := p.hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return r, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return r, fmt.Errorf("%s: reading response: %v", p.name, err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return r, fmt.Errorf("%w by %s: bad username or password", errRejected, p.name)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return r, fmt.Errorf("%s: %w", p.name, duckdns.NewStatusError(resp))
+	}
+	return parseDyndns2(p.name, domain, string(body))
+}
+
+// parseDyndns2 reads the answer to a dyndns2 update, such as "good 203.0.113.5"
+// or "nochg 203.0.113.5". 911 and dnserr are the service's own trouble and
+// worth retrying; everything else is a refusal.
+func parseDyndns2(name, domain, body string) (duckdns.Result, error) {
+	r := duckdns.Result{Domain: domain}
+	fields := strings.Fields(body)
+	if len(fields) == 0 {
+		return r, fmt.Errorf("%s: empty response", name)
+	}
+
+	switch fields[0] {
+	case "good", "nochg":
+		r.OK = true
+		r.Updated = fields[0] == "good"
+		if len(fields) > 1 {
+			if ip := net.ParseIP(fields[1]); ip != nil && ip.To4() == nil {
+				r.IPv6 = ip.String()
+			} else if ip != nil {
+				r.IP = ip.String()
+			}
+		}
+		return r, nil
+	case "911", "dnserr":
+		return r, fmt.Errorf("%s: server error %q", name, fields[0])
+	}
+	logrus.Debugf("%s answered %q for %s", name, body, domain)
+	if why, ok := dyndns2Codes[fields[0]]; ok {
+		return r, fmt.Errorf("%w by %s: %s (%s)", errRejected, name, fields[0], why)
+	}
+	return r, fmt.Errorf("%w by %s: %s", errRejected, name, fields[0])
+}
+
+// dyndns2Codes explains the refusals defined by the dyndns2 protocol
+var dyndns2Codes = map[string]string{
+	"badauth":  "the username or password is wrong",
+	"nohost":   "the host name is not in the account",
+	"notfqdn":  "the host name is not fully qualified",
+	"numhost":  "too many host names in one update",
+	"abuse":    "the host name is blocked for abuse",
+	"badagent": "the service refused this client",
+	"!donator": "the update needs a paid account",
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/theag3nt/duckdns/pkg/duckdns"
+)
+
+func TestParseDyndns2(t *testing.T) {
+	const host = "home.example.com"
+	tests := []struct {
+		name         string
+		body         string
+		want         duckdns.Result
+		wantErr      string
+		wantRejected bool
+	}{
+		{
+			name: "good",
+			body: "good 203.0.113.5\n",
+			want: duckdns.Result{Domain: host, OK: true, Updated: true, IP: "203.0.113.5"},
+		},
+		{
+			name: "nochg IPv6",
+			body: "nochg 2001:db8::1",
+			want: duckdns.Result{Domain: host, OK: true, IPv6: "2001:db8::1"},
+		},
+		{
+			name: "good without an address",
+			body: "good",
+			want: duckdns.Result{Domain: host, OK: true, Updated: true},
+		},
+		{
+			name:    "server error",
+			body:    "911",
+			want:    duckdns.Result{Domain: host},
+			wantErr: `server error "911"`,
+		},
+		{
+			name:         "known refusal",
+			body:         "badauth",
+			want:         duckdns.Result{Domain: host},
+			wantErr:      "badauth (the username or password is wrong)",
+			wantRejected: true,
+		},
+		{
+			name:         "unknown refusal",
+			body:         "whatever",
+			want:         duckdns.Result{Domain: host},
+			wantErr:      "whatever",
+			wantRejected: true,
+		},
+		{
+			name:    "empty",
+			body:    " \n",
+			want:    duckdns.Result{Domain: host},
+			wantErr: "empty response",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDyndns2("dynu", host, tt.body)
+			if got != tt.want {
+				t.Errorf("parseDyndns2() = %+v, want %+v", got, tt.want)
+			}
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("parseDyndns2() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("parseDyndns2() error = %v, want %q", err, tt.wantErr)
+			}
+			if errors.Is(err, errRejected) != tt.wantRejected {
+				t.Errorf("parseDyndns2() error = %v, rejected %t", err, !tt.wantRejected)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"text/template"
+)
+
+const pushoverAPI = "https://api.pushover.net/1/messages.json"
+
+// PushoverNotify configures messages sent through Pushover
+type PushoverNotify struct {
+	// AppToken is the API token of the Pushover application
+	AppToken string `yaml:"app_token"`
+	// UserKey is the user or group key the messages go to
+	UserKey  string `yaml:"user_key"`
+	Template string `yaml:"template"`
+}
+
+type pushoverNotifier struct {
+	appToken string
+	userKey  string
+	template *template.Template
+	hc       *http.Client
+}
+
+func newPushoverNotifier(c PushoverNotify, hc *http.Client) (*pushoverNotifier, error) {
+	t, err := parseTemplate("pushover", c.Template)
+	if err != nil {
+		return nil, err
+	}
+	return &pushoverNotifier{appToken: c.AppToken, userKey: c.UserKey, template: t, hc: hc}, nil
+}
+
+func (p *pushoverNotifier) Name() string { return "pushover" }
+
+func (p *pushoverNotifier) Notify(ctx context.Context, ev notifyEvent) error {
+	text, err := message(p.template, ev)
+	if err != nil {
+		return err
+	}
+	form := url.Values{
+		"token":   {p.appToken},
+		"user":    {p.userKey},
+		"title":   {"DuckDNS"},
+		"message": {text},
+	}
+	if ev.Result == eventFailed {
+		form.Set("priority", "1")
+	}
+	return postNotification(ctx, p.hc, pushoverAPI, "application/x-www-form-urlencoded",
+		[]byte(form.Encode()))
+}
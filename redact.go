@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// secrets are the tokens and passwords read so far, masked wherever they
+	// would end up in a log line, an error message or a file
+	secretsMu sync.RWMutex
+	secrets   []string
+
+	// tokenParam matches the token of an update URL, so that one that was
+	// never registered doesn't get out either
+	tokenParam = regexp.MustCompile(`(?i)([?&]token=)([^&\s"'\\]+)`)
+)
+
+// addSecret registers s to be masked by redact
+func addSecret(s string) {
+	if len(s) < 4 {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, have := range secrets {
+		if have == s {
+			return
+		}
+	}
+	secrets = append(secrets, s)
+}
+
+// registerSecrets registers the tokens, passwords and keys of u
+func (u *Update) registerSecrets() {
+	for _, s := range []string{u.Token, u.Notify.Telegram.BotToken, u.Notify.Email.Password,
+		u.Notify.Ntfy.Token, u.Notify.Pushover.AppToken, u.Notify.Pushover.UserKey, u.MQTT.Password,
+		u.APIToken, u.Metrics.InfluxDBToken} {
+		addSecret(s)
+	}
+	for _, a := range u.Accounts {
+		addSecret(a.Token)
+	}
+}
+
+// redact replaces every registered secret in s with its fingerprint
+func redact(s string) string {
+	secretsMu.RLock()
+	for _, secret := range secrets {
+		if strings.Contains(s, secret) {
+			s = strings.Replace(s, secret, fingerprint(secret), -1)
+		}
+	}
+	secretsMu.RUnlock()
+	return tokenParam.ReplaceAllStringFunc(s, func(m string) string {
+		p := tokenParam.FindStringSubmatch(m)
+		if strings.HasPrefix(p[2], "REDACTED-") {
+			return m
+		}
+		return p[1] + fingerprint(p[2])
+	})
+}
+
+// fingerprint names a secret without giving it away, the same for every log
+// line so that lines about the same account can be matched up
+func fingerprint(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return "REDACTED-" + hex.EncodeToString(sum[:4])
+}
+
+// redactHook masks secrets in every log entry before it is formatted or
+// handed to the hooks added after it, like syslog
+type redactHook struct{}
+
+func (redactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (redactHook) Fire(e *logrus.Entry) error {
+	e.Message = redact(e.Message)
+	for k, v := range e.Data {
+		switch v := v.(type) {
+		case string:
+			e.Data[k] = redact(v)
+		case error:
+			if msg := redact(v.Error()); msg != v.Error() {
+				e.Data[k] = errors.New(msg)
+			}
+		}
+	}
+	return nil
+}
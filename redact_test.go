@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// withSecrets registers secrets for the length of a test
+func withSecrets(t *testing.T, s ...string) {
+	secretsMu.Lock()
+	saved := secrets
+	secrets = nil
+	secretsMu.Unlock()
+	t.Cleanup(func() {
+		secretsMu.Lock()
+		secrets = saved
+		secretsMu.Unlock()
+	})
+	for _, secret := range s {
+		addSecret(secret)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	const token = "a7c4d0ad-114e-40ef-ba1d-d217904a50f2"
+	withSecrets(t, token, "hunter2", "abc")
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "registered token",
+			in:   "account " + token + " rejected",
+			want: "account " + fingerprint(token) + " rejected",
+		},
+		{
+			name: "every occurrence",
+			in:   token + " " + token,
+			want: fingerprint(token) + " " + fingerprint(token),
+		},
+		{
+			name: "password",
+			in:   "login failed: hunter2",
+			want: "login failed: " + fingerprint("hunter2"),
+		},
+		{
+			name: "short values are not secrets",
+			in:   "abc",
+			want: "abc",
+		},
+		{
+			name: "unregistered token of a URL",
+			in:   `Get "https://www.duckdns.org/update?domains=home&token=other-token&ip=": EOF`,
+			want: `Get "https://www.duckdns.org/update?domains=home&token=` + fingerprint("other-token") + `&ip=": EOF`,
+		},
+		{
+			name: "registered token of a URL",
+			in:   "https://www.duckdns.org/update?token=" + token,
+			want: "https://www.duckdns.org/update?token=" + fingerprint(token),
+		},
+		{
+			name: "nothing to hide",
+			in:   "updated home to 203.0.113.5",
+			want: "updated home to 203.0.113.5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redact(tt.in); got != tt.want {
+				t.Errorf("redact(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	a, b := fingerprint("one-secret"), fingerprint("another-secret")
+	if !strings.HasPrefix(a, "REDACTED-") || len(a) != len("REDACTED-")+8 {
+		t.Errorf("fingerprint() = %q, want REDACTED- and 8 hex digits", a)
+	}
+	if a != fingerprint("one-secret") {
+		t.Error("fingerprint() differs between calls")
+	}
+	if a == b {
+		t.Error("fingerprint() is the same for different secrets")
+	}
+}
+
+func TestRegisterSecrets(t *testing.T) {
+	withSecrets(t)
+	u := Update{
+		Token:    "top-level-token",
+		Accounts: []Account{{Token: "account-token"}},
+	}
+	u.Notify.Telegram.BotToken = "bot-token"
+	u.MQTT.Password = "mqtt-password"
+	u.registerSecrets()
+
+	for _, s := range []string{"top-level-token", "account-token", "bot-token", "mqtt-password"} {
+		if got := redact(s); got != fingerprint(s) {
+			t.Errorf("redact(%q) = %q, not registered", s, got)
+		}
+	}
+}
+
+func TestRedactHook(t *testing.T) {
+	withSecrets(t, "hunter2")
+	e := &logrus.Entry{
+		Message: "using hunter2",
+		Data: logrus.Fields{
+			"password": "hunter2",
+			"error":    errors.New("rejected hunter2"),
+			"count":    3,
+		},
+	}
+	if err := (redactHook{}).Fire(e); err != nil {
+		t.Fatal(err)
+	}
+	fp := fingerprint("hunter2")
+	if e.Message != "using "+fp {
+		t.Errorf("Message = %q", e.Message)
+	}
+	if e.Data["password"] != fp {
+		t.Errorf("password = %v", e.Data["password"])
+	}
+	if err, ok := e.Data["error"].(error); !ok || err.Error() != "rejected "+fp {
+		t.Errorf("error = %v", e.Data["error"])
+	}
+	if e.Data["count"] != 3 {
+		t.Errorf("count = %v", e.Data["count"])
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/blake2b"
+)
+
+// maxRemoteConfig bounds the size of a downloaded config
+const maxRemoteConfig = 1 << 20
+
+// errNotModified is returned when the server says the cached copy is current
+var errNotModified = errors.New("not modified")
+
+// isRemoteConfig reports whether the config location is a URL
+func isRemoteConfig(file string) bool {
+	return strings.HasPrefix(file, "https://") || strings.HasPrefix(file, "http://")
+}
+
+// remoteCache is the last copy of a remote config, sent again with
+// If-None-Match and used when the server can't be reached
+type remoteCache struct {
+	URL       string `json:"url"`
+	ETag      string `json:"etag,omitempty"`
+	Data      []byte `json:"data"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// readRemoteConfig downloads the config at rawurl, or takes it from the cache
+// when it is unchanged or the server is down. With a minisign public key the
+// config must come with a valid signature at rawurl.minisig, and only then can
+// it be downloaded over plain HTTP.
+func readRemoteConfig(ctx context.Context, u Update, rawurl, key string) ([]byte, error) {
+	if strings.HasPrefix(rawurl, "http://") && key == "" {
+		return nil, fmt.Errorf("%s is not HTTPS, set --config-key to download it over plain HTTP", rawurl)
+	}
+	path := remoteCachePath(rawurl)
+	cached := loadRemoteCache(path, rawurl)
+
+	c, err := downloadConfig(ctx, u, rawurl, cached, key != "")
+	switch {
+	case err == errNotModified:
+		logrus.Debugf("%s is unchanged", rawurl)
+		c = cached
+	case err != nil && ctx.Err() != nil:
+		fatal(exitError, nil, "interrupted")
+	case err != nil && cached != nil:
+		logrus.WithError(err).Warnf("unable to download %s, using the copy from %s", rawurl, path)
+		c = cached
+	case err != nil:
+		return nil, fmt.Errorf("unable to download %s: %v", rawurl, err)
+	}
+
+	if key != "" {
+		if err := verifyMinisign(key, c.Data, c.Signature); err != nil {
+			return nil, fmt.Errorf("the signature of %s does not match: %v", rawurl, err)
+		}
+	}
+	if c != cached && path != "" {
+		data, err := json.Marshal(c)
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(path), 0700)
+		}
+		if err == nil {
+			err = writeFileAtomic(path, data, 0600)
+		}
+		if err != nil {
+			logrus.WithError(err).Warnf("unable to cache %s", rawurl)
+		}
+	}
+	return c.Data, nil
+}
+
+// remoteCachePath is where the copy of rawurl lives, empty without a cache dir
+func remoteCachePath(rawurl string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(rawurl))
+	return filepath.Join(dir, "duckdns", "config-"+hex.EncodeToString(sum[:8])+".json")
+}
+
+func loadRemoteCache(path, rawurl string) *remoteCache {
+	if path == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var c remoteCache
+	if err := json.Unmarshal(data, &c); err != nil || c.URL != rawurl {
+		logrus.Debugf("ignoring the cached config %s", path)
+		return nil
+	}
+	return &c
+}
+
+// downloadConfig fetches rawurl, and its signature when signed is set. It
+// returns errNotModified when the ETag of cached still matches.
+func downloadConfig(ctx context.Context, u Update, rawurl string, cached *remoteCache, signed bool) (*remoteCache, error) {
+	if u.ConnectTimeout == 0 {
+		u.ConnectTimeout = defaultConnectTimeout
+	}
+	if u.Timeout == 0 {
+		u.Timeout = defaultTimeout
+	}
+	client, err := newHTTPClient(u)
+	if err != nil {
+		return nil, err
+	}
+
+	// a copy cached before the key was set has no signature to check
+	etag := ""
+	if cached != nil && (cached.Signature != "" || !signed) {
+		etag = cached.ETag
+	}
+	data, etag, err := fetch(ctx, client, rawurl, etag)
+	if err != nil {
+		return nil, err
+	}
+	c := &remoteCache{URL: rawurl, ETag: etag, Data: data}
+	if signed {
+		sigURL, err := url.Parse(rawurl)
+		if err != nil {
+			return nil, err
+		}
+		sigURL.Path += ".minisig"
+		sig, _, err := fetch(ctx, client, sigURL.String(), "")
+		if err != nil {
+			return nil, fmt.Errorf("signature: %v", err)
+		}
+		c.Signature = string(sig)
+	}
+	return c, nil
+}
+
+// fetch GETs rawurl, conditionally when etag is set
+func fetch(ctx context.Context, client *http.Client, rawurl, etag string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawurl, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("User-Agent", userAgent())
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && etag != "":
+		return nil, "", errNotModified
+	case resp.StatusCode != http.StatusOK:
+		return nil, "", fmt.Errorf("%s returned %s", rawurl, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRemoteConfig+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxRemoteConfig {
+		return nil, "", fmt.Errorf("%s is larger than %d bytes", rawurl, maxRemoteConfig)
+	}
+	return data, resp.Header.Get("ETag"), nil
+}
+
+// verifyMinisign checks a minisign signature of data. key is the public key
+// as minisign prints it, or the file holding it.
+func verifyMinisign(key string, data []byte, sig string) error {
+	if _, err := os.Stat(key); err == nil {
+		b, err := ioutil.ReadFile(key)
+		if err != nil {
+			return err
+		}
+		key = string(b)
+	}
+	pub, err := minisignLine(key, 42)
+	if err != nil {
+		return fmt.Errorf("public key: %v", err)
+	}
+	if string(pub[:2]) != "Ed" {
+		return errors.New("public key: not an Ed25519 minisign key")
+	}
+
+	lines := strings.Split(strings.TrimSpace(sig), "\n")
+	if len(lines) < 4 {
+		return errors.New("malformed signature")
+	}
+	s, err := minisignLine(lines[1], 74)
+	if err != nil {
+		return fmt.Errorf("signature: %v", err)
+	}
+	if !bytes.Equal(s[2:10], pub[2:10]) {
+		return errors.New("signed with another key")
+	}
+	msg := data
+	switch string(s[:2]) {
+	case "ED":
+		sum := blake2b.Sum512(data)
+		msg = sum[:]
+	case "Ed":
+	default:
+		return errors.New("unknown signature algorithm")
+	}
+	pk := ed25519.PublicKey(pub[10:])
+	if !ed25519.Verify(pk, msg, s[10:]) {
+		return errors.New("invalid signature")
+	}
+
+	// the trusted comment is signed as well, along with the signature
+	comment := strings.TrimSpace(lines[2])
+	if !strings.HasPrefix(comment, "trusted comment: ") {
+		return errors.New("malformed signature")
+	}
+	global, err := minisignLine(lines[3], 64)
+	if err != nil {
+		return fmt.Errorf("signature: %v", err)
+	}
+	signed := append(append([]byte{}, s[10:]...), strings.TrimPrefix(comment, "trusted comment: ")...)
+	if !ed25519.Verify(pk, signed, global) {
+		return errors.New("invalid trusted comment signature")
+	}
+	return nil
+}
+
+// minisignLine decodes the base64 line of a minisign file, skipping comments
+func minisignLine(text string, size int) ([]byte, error) {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "untrusted comment:") {
+			continue
+		}
+		b, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) != size {
+			return nil, fmt.Errorf("expected %d bytes, got %d", size, len(b))
+		}
+		return b, nil
+	}
+	return nil, errors.New("empty")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+func TestReadRemoteConfigPlainHTTP(t *testing.T) {
+	_, err := readRemoteConfig(context.Background(), Update{}, "http://config.example.com/duckdns.yaml", "")
+	if err == nil || !strings.Contains(err.Error(), "--config-key") {
+		t.Errorf("readRemoteConfig() error = %v, want a plain HTTP URL refused without a key", err)
+	}
+}
+
+// minisignPair returns a public key and a signer writing signatures the way
+// minisign does, prehashed with BLAKE2b unless legacy is set
+func minisignPair(seed byte) (string, func(data []byte, comment string, legacy bool) string) {
+	s := make([]byte, ed25519.SeedSize)
+	s[0] = seed
+	priv := ed25519.NewKeyFromSeed(s)
+	keyID := []byte{seed, 1, 2, 3, 4, 5, 6, 7}
+	pub := append(append([]byte("Ed"), keyID...), priv.Public().(ed25519.PublicKey)...)
+	key := "untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(pub) + "\n"
+
+	sign := func(data []byte, comment string, legacy bool) string {
+		alg, msg := "ED", data
+		if legacy {
+			alg = "Ed"
+		} else {
+			sum := blake2b.Sum512(data)
+			msg = sum[:]
+		}
+		sig := ed25519.Sign(priv, msg)
+		global := ed25519.Sign(priv, append(append([]byte{}, sig...), comment...))
+		return "untrusted comment: signature\n" +
+			base64.StdEncoding.EncodeToString(append(append([]byte(alg), keyID...), sig...)) + "\n" +
+			"trusted comment: " + comment + "\n" +
+			base64.StdEncoding.EncodeToString(global) + "\n"
+	}
+	return key, sign
+}
+
+func TestVerifyMinisign(t *testing.T) {
+	data := []byte("domains: [home]\n")
+	key, sign := minisignPair(0)
+	otherKey, signOther := minisignPair(9)
+	good := sign(data, "timestamp:1714564800", false)
+
+	tests := []struct {
+		name    string
+		key     string
+		data    []byte
+		sig     string
+		wantErr string
+	}{
+		{name: "prehashed", key: key, data: data, sig: good},
+		{name: "legacy", key: key, data: data, sig: sign(data, "timestamp:1714564800", true)},
+		{name: "changed data", key: key, data: []byte("domains: [evil]\n"), sig: good, wantErr: "invalid signature"},
+		{name: "other key", key: key, data: data, sig: signOther(data, "c", false), wantErr: "another key"},
+		{name: "wrong public key", key: otherKey, data: data, sig: good, wantErr: "another key"},
+		{
+			name:    "changed trusted comment",
+			key:     key,
+			data:    data,
+			sig:     strings.Replace(good, "timestamp:1714564800", "timestamp:1", 1),
+			wantErr: "invalid trusted comment signature",
+		},
+		{name: "truncated", key: key, data: data, sig: strings.Join(strings.Split(good, "\n")[:2], "\n"),
+			wantErr: "malformed signature"},
+		{name: "not a key", key: "RWQ", data: data, sig: good, wantErr: "public key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyMinisign(tt.key, tt.data, tt.sig)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("verifyMinisign() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("verifyMinisign() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
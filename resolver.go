@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxDNSMessage is the largest DNS message, bounding DNS over HTTPS answers
+const maxDNSMessage = 65535
+
+// newResolver returns the resolver for --verify and status: the system
+// resolver, or the server in VerifyResolver. That is a DNS server as host or
+// host:port, tls://host[:port] for DNS over TLS or the https:// URL of a DNS
+// over HTTPS service. Local resolvers tend to hold on to stale answers for
+// DuckDNS names.
+func newResolver(update Update) (*net.Resolver, error) {
+	addr := update.VerifyResolver
+	d := &net.Dialer{Timeout: update.ConnectTimeout}
+
+	var dial func(ctx context.Context, network, address string) (net.Conn, error)
+	switch {
+	case addr == "":
+		return net.DefaultResolver, nil
+	case strings.HasPrefix(addr, "https://"):
+		if u, err := url.Parse(addr); err != nil || u.Host == "" {
+			return nil, fmt.Errorf("verify_resolver %q is not a valid URL", addr)
+		}
+		hc, err := newHTTPClient(update)
+		if err != nil {
+			return nil, err
+		}
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return &dohConn{ctx: ctx, hc: hc, url: addr}, nil
+		}
+	case strings.HasPrefix(addr, "tls://"):
+		server := strings.TrimPrefix(addr, "tls://")
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "853")
+		}
+		host, _, _ := net.SplitHostPort(server)
+		config, err := update.TLS.build(endpointHost(update.Endpoint))
+		if err != nil {
+			return nil, err
+		}
+		if config == nil {
+			config = &tls.Config{}
+		}
+		config.ServerName = host
+		td := &tls.Dialer{NetDialer: d, Config: config}
+		// the resolver speaks TCP framing to anything that isn't a PacketConn
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return td.DialContext(ctx, "tcp", server)
+		}
+	case strings.Contains(addr, "://"):
+		return nil, fmt.Errorf("verify_resolver %q is neither a DNS server, tls:// nor https://", addr)
+	default:
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "53")
+		}
+		dial = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return d.DialContext(ctx, network, addr)
+		}
+	}
+	return &net.Resolver{PreferGo: true, Dial: dial}, nil
+}
+
+// dohConn hands the DNS messages the resolver writes, framed as over TCP, to a
+// DNS over HTTPS service (RFC 8484) and frames the answers the same way for
+// the resolver to read
+type dohConn struct {
+	ctx      context.Context
+	hc       *http.Client
+	url      string
+	deadline time.Time
+	in, out  bytes.Buffer
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	c.in.Write(b)
+	for c.in.Len() >= 2 {
+		n := int(binary.BigEndian.Uint16(c.in.Bytes()))
+		if c.in.Len() < 2+n {
+			break
+		}
+		c.in.Next(2)
+		answer, err := c.exchange(c.in.Next(n))
+		if err != nil {
+			return 0, err
+		}
+		var size [2]byte
+		binary.BigEndian.PutUint16(size[:], uint16(len(answer)))
+		c.out.Write(size[:])
+		c.out.Write(answer)
+	}
+	return len(b), nil
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	if c.out.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.out.Read(b)
+}
+
+// exchange POSTs one DNS message and returns the answer
+func (c *dohConn) exchange(msg []byte) ([]byte, error) {
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	req.Header.Set("User-Agent", userAgent())
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", c.url, resp.Status)
+	}
+	answer, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDNSMessage+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(answer) > maxDNSMessage {
+		return nil, fmt.Errorf("%s sent an oversized answer", c.url)
+	}
+	return answer, nil
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return dohAddr(c.url) }
+func (c *dohConn) RemoteAddr() net.Addr               { return dohAddr(c.url) }
+func (c *dohConn) SetDeadline(t time.Time) error      { c.deadline = t; return nil }
+func (c *dohConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { c.deadline = t; return nil }
+
+// dohAddr names the service a dohConn talks to
+type dohAddr string
+
+func (a dohAddr) Network() string { return "https" }
+func (a dohAddr) String() string  { return string(a) }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// Result is the outcome of a run for one name, in configuration order
+type Result struct {
+	Domain   string `json:"domain"`
+	Provider string `json:"provider"`
+	// OK is false when the name needs attention: the update failed, or was
+	// accepted but did not propagate
+	OK     bool   `json:"ok"`
+	Status string `json:"status"`
+	// Updated is set when the service reported a change to the record
+	Updated bool   `json:"updated"`
+	IP      string `json:"ip,omitempty"`
+	IPv6    string `json:"ipv6,omitempty"`
+	TXT     string `json:"txt,omitempty"`
+	// Message explains a failure or a skipped name
+	Message  string        `json:"message,omitempty"`
+	Duration time.Duration `json:"-"`
+}
+
+// Statuses of a Result. The history file uses the same words.
+const (
+	resultUpdated    = "updated"
+	resultUnchanged  = "unchanged"
+	resultSkipped    = "skipped"
+	resultFailed     = "failed"
+	resultError      = "error"
+	resultUnverified = "unverified"
+)
+
+// newResult describes how a finished update went
+func newResult(j *nameUpdate) Result {
+	r := Result{
+		Domain:   j.name,
+		Provider: j.provider.Name(),
+		Updated:  j.res.Updated,
+		IP:       j.res.IP,
+		IPv6:     j.res.IPv6,
+		TXT:      j.res.TXT,
+		Duration: j.took,
+	}
+	if j.err != nil {
+		r.Message = redact(j.err.Error())
+	}
+	switch j.kind {
+	case updateOK:
+		r.OK, r.Status = true, resultUnchanged
+		if j.res.Updated {
+			r.Status = resultUpdated
+		}
+	case updateSkipped:
+		r.OK, r.Status, r.Message = true, resultSkipped, errUnchanged.Error()
+	case updateKO:
+		r.Status = resultFailed
+	case updateNetwork:
+		r.Status = resultError
+	case updateVerify:
+		r.Status = resultUnverified
+	}
+	return r
+}
+
+// accepted reports whether the service took the update, even if it has not
+// propagated yet
+func (r Result) accepted() bool {
+	return r.Status == resultUpdated || r.Status == resultUnchanged || r.Status == resultUnverified
+}
+
+// MarshalJSON gives the duration in seconds, like the history file
+func (r Result) MarshalJSON() ([]byte, error) {
+	type result Result
+	return json.Marshal(struct {
+		result
+		Duration float64 `json:"duration"`
+	}{result(r), r.Duration.Seconds()})
+}
+
+// outputFormats are the values --output takes, the empty default only logging
+var outputFormats = []string{"", "json", "table", "plain"}
+
+func validOutput(format string) bool {
+	for _, f := range outputFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// printResults writes results to w in format: a JSON array, an aligned table,
+// or one "name status ip message" line per name for shell scripts
+func printResults(w io.Writer, format string, results []Result) error {
+	switch format {
+	case "json":
+		if results == nil {
+			results = []Result{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(results)
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tPROVIDER\tSTATUS\tIP\tDURATION\tMESSAGE")
+		for _, r := range results {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Domain, r.Provider, r.Status,
+				dash(r.value()), r.Duration.Round(time.Millisecond), r.Message)
+		}
+		return tw.Flush()
+	case "plain":
+		for _, r := range results {
+			line := r.Domain + " " + r.Status + " " + dash(r.value())
+			if r.Message != "" {
+				line += " " + r.Message
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// value is the record the result is about: the TXT value or the address
+func (r Result) value() string {
+	if r.TXT != "" {
+		return r.TXT
+	}
+	if r.IP != "" {
+		return r.IP
+	}
+	return r.IPv6
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// schedule decides when the daemon runs next
+type schedule interface {
+	next(after time.Time) time.Time
+}
+
+// intervalSchedule runs a fixed time after the previous run
+type intervalSchedule time.Duration
+
+func (s intervalSchedule) next(after time.Time) time.Time {
+	return after.Add(time.Duration(s))
+}
+
+// cronSchedule runs at the times matched by a crontab expression
+type cronSchedule struct {
+	cron cron.Schedule
+}
+
+func (s cronSchedule) next(after time.Time) time.Time {
+	return s.cron.Next(after)
+}
+
+// jitterSchedule delays every run of another schedule by a random amount up to
+// max, so that many devices on the same schedule spread their requests
+type jitterSchedule struct {
+	schedule
+	max time.Duration
+}
+
+func (s jitterSchedule) next(after time.Time) time.Time {
+	return s.schedule.next(after).Add(randomDelay(s.max))
+}
+
+// randomDelay returns a random duration in [0, max)
+func randomDelay(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// newSchedule returns the cron schedule if one is configured, and the fixed
+// interval otherwise, with any jitter applied
+func newSchedule(update Update) (schedule, error) {
+	var s schedule = intervalSchedule(update.Interval)
+	if update.Schedule != "" {
+		c, err := cron.ParseStandard(update.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %v", update.Schedule, err)
+		}
+		s = cronSchedule{cron: c}
+	}
+	if update.Jitter > 0 {
+		s = jitterSchedule{schedule: s, max: update.Jitter}
+	}
+	return s, nil
+}
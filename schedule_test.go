@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewSchedule(t *testing.T) {
+	after := time.Date(2024, 5, 1, 12, 7, 30, 0, time.Local)
+	tests := []struct {
+		name    string
+		update  Update
+		want    time.Time
+		wantErr bool
+	}{
+		{
+			name:   "interval",
+			update: Update{Interval: 5 * time.Minute},
+			want:   after.Add(5 * time.Minute),
+		},
+		{
+			name:   "every quarter hour",
+			update: Update{Interval: 5 * time.Minute, Schedule: "*/15 * * * *"},
+			want:   time.Date(2024, 5, 1, 12, 15, 0, 0, time.Local),
+		},
+		{
+			name:   "daily",
+			update: Update{Schedule: "30 3 * * *"},
+			want:   time.Date(2024, 5, 2, 3, 30, 0, 0, time.Local),
+		},
+		{
+			name:   "descriptor",
+			update: Update{Schedule: "@hourly"},
+			want:   time.Date(2024, 5, 1, 13, 0, 0, 0, time.Local),
+		},
+		{
+			name:    "invalid",
+			update:  Update{Schedule: "every day"},
+			wantErr: true,
+		},
+		{
+			name:    "seconds are not standard",
+			update:  Update{Schedule: "0 */5 * * * *"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newSchedule(tt.update)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newSchedule() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := s.next(after); !got.Equal(tt.want) {
+				t.Errorf("next(%s) = %s, want %s", after, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJitterSchedule(t *testing.T) {
+	after := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		name   string
+		update Update
+		base   time.Time
+	}{
+		{
+			name:   "interval",
+			update: Update{Interval: 5 * time.Minute, Jitter: time.Minute},
+			base:   after.Add(5 * time.Minute),
+		},
+		{
+			name:   "cron",
+			update: Update{Schedule: "@hourly", Jitter: 10 * time.Minute},
+			base:   time.Date(2024, 5, 1, 13, 0, 0, 0, time.Local),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newSchedule(tt.update)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 50; i++ {
+				got := s.next(after)
+				if got.Before(tt.base) || !got.Before(tt.base.Add(tt.update.Jitter)) {
+					t.Fatalf("next() = %s, want within %s of %s", got, tt.update.Jitter, tt.base)
+				}
+			}
+		})
+	}
+}
+
+func TestRandomDelay(t *testing.T) {
+	for _, max := range []time.Duration{-time.Second, 0} {
+		if got := randomDelay(max); got != 0 {
+			t.Errorf("randomDelay(%s) = %s, want 0", max, got)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if got := randomDelay(time.Second); got < 0 || got >= time.Second {
+			t.Fatalf("randomDelay(1s) = %s, want [0, 1s)", got)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultReleasesURL answers with the latest GitHub release
+const defaultReleasesURL = "https://api.github.com/repos/theag3nt/duckdns/releases/latest"
+
+type release struct {
+	Tag    string `json:"tag_name"`
+	Assets []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+func (r *release) asset(name string) (string, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL, true
+		}
+	}
+	return "", false
+}
+
+// releaseAsset is the binary build-release publishes for this platform
+func releaseAsset(tag string) string {
+	name := fmt.Sprintf("duckdns-%s-%s-%s", tag, runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// runSelfUpdate replaces the running binary with the latest release, after
+// checking it against the release's SHA256SUMS
+func runSelfUpdate(update Update, releasesURL string, check, force bool) {
+	hc, err := newHTTPClient(update)
+	if err != nil {
+		fatal(exitConfig, err, "error preparing self-update")
+	}
+	// a binary takes longer than an API call on a slow link. The client is
+	// shared, so the copy is changed.
+	unbounded := *hc
+	unbounded.Timeout = 0
+	hc = &unbounded
+	ctx := context.Background()
+
+	var rel release
+	if err := getJSON(ctx, hc, releasesURL, &rel); err != nil {
+		fatal(exitNetwork, err, "unable to look up the latest release")
+	}
+	if rel.Tag == version && !force {
+		fmt.Printf("duckdns %s is the latest release\n", version)
+		return
+	}
+	if check {
+		fmt.Printf("duckdns %s is available, this is %s\n", rel.Tag, version)
+		return
+	}
+	if version == "dev" && !force {
+		fatal(exitError, nil, "this is a development build, use --force to replace it with %s", rel.Tag)
+	}
+
+	name := releaseAsset(rel.Tag)
+	binURL, ok := rel.asset(name)
+	if !ok {
+		fatal(exitError, nil, "release %s has no %s", rel.Tag, name)
+	}
+	sumsURL, ok := rel.asset("SHA256SUMS")
+	if !ok {
+		fatal(exitError, nil, "release %s has no SHA256SUMS, refusing to install it", rel.Tag)
+	}
+	want, err := releaseChecksum(ctx, hc, sumsURL, name)
+	if err != nil {
+		fatal(exitError, err, "unable to read the checksums of %s", rel.Tag)
+	}
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		fatal(exitError, err, "unable to find the duckdns binary")
+	}
+	if err := installRelease(ctx, hc, binURL, want, exe); err != nil {
+		fatal(exitError, err, "unable to install %s", name)
+	}
+	logrus.Infof("updated %s from %s to %s", exe, version, rel.Tag)
+}
+
+// installRelease downloads the binary at u next to exe, so the rename stays
+// on one filesystem, and moves it over exe if its SHA-256 is sum
+func installRelease(ctx context.Context, hc *http.Client, u, sum, exe string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(exe), ".duckdns-update-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := download(ctx, hc, u, tmp, sum); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	return replaceBinary(exe, tmp.Name())
+}
+
+// replaceBinary moves next over exe. Windows won't overwrite a running
+// binary but lets it be renamed, so the old one is moved out of the way first.
+func replaceBinary(exe, next string) error {
+	if runtime.GOOS != "windows" {
+		return os.Rename(next, exe)
+	}
+	old := exe + ".old"
+	os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		return err
+	}
+	if err := os.Rename(next, exe); err != nil {
+		os.Rename(old, exe)
+		return err
+	}
+	return nil
+}
+
+// releaseChecksum finds the SHA-256 of name in a sha256sum style file
+func releaseChecksum(ctx context.Context, hc *http.Client, u, name string) (string, error) {
+	resp, err := get(ctx, hc, u)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	s := bufio.NewScanner(resp.Body)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no checksum for %s", name)
+}
+
+// download writes u to w, failing unless its SHA-256 is sum
+func download(ctx context.Context, hc *http.Client, u string, w io.Writer, sum string) error {
+	resp, err := get(ctx, hc, u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), resp.Body); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+		return fmt.Errorf("checksum mismatch: got %s, want %s", got, sum)
+	}
+	return nil
+}
+
+func getJSON(ctx context.Context, hc *http.Client, u string, v interface{}) error {
+	resp, err := get(ctx, hc, u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// get fetches u, treating anything but a 200 as an error
+func get(ctx context.Context, hc *http.Client, u string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent())
+	resp, err := hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.New("unexpected status " + resp.Status)
+	}
+	return resp, nil
+}
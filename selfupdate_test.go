@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReleaseChecksum(t *testing.T) {
+	const sums = "0A1B  duckdns_linux_amd64.tar.gz\n" +
+		"2c3d *duckdns_windows_amd64.zip\n" +
+		"malformed line\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(sums))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		asset   string
+		want    string
+		wantErr bool
+	}{
+		{name: "text mode", asset: "duckdns_linux_amd64.tar.gz", want: "0a1b"},
+		{name: "binary mode", asset: "duckdns_windows_amd64.zip", want: "2c3d"},
+		{name: "missing", asset: "duckdns_plan9_amd64.tar.gz", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := releaseChecksum(context.Background(), srv.Client(), srv.URL, tt.asset)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("releaseChecksum() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("releaseChecksum() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadChecksum(t *testing.T) {
+	const body = "release archive"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	sum := sha256.Sum256([]byte(body))
+
+	tests := []struct {
+		name    string
+		sum     string
+		wantErr string
+	}{
+		{name: "matching", sum: hex.EncodeToString(sum[:])},
+		{name: "tampered", sum: strings.Repeat("0", 64), wantErr: "checksum mismatch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			err := download(context.Background(), srv.Client(), srv.URL, &b, tt.sum)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("download() error = %v", err)
+				}
+				if b.String() != body {
+					t.Errorf("download() wrote %q, want %q", b.String(), body)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("download() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+// daemonState is what the daemon reports on the dashboard, /healthz, /status
+// and MQTT
+type daemonState struct {
+	mu       sync.Mutex
+	interval time.Duration
+	// apiToken guards POST /update, which sends runs to triggers
+	apiToken string
+	triggers chan updateTrigger
+	// update is the configuration in use, for the names and history file of
+	// the dashboard
+	update Update
+
+	IP        string                 `json:"ip,omitempty"`
+	LastRun   time.Time              `json:"last_run"`
+	LastError string                 `json:"last_error,omitempty"`
+	NextRun   time.Time              `json:"next_run"`
+	Domains   map[string]domainState `json:"domains"`
+}
+
+type domainState struct {
+	Result string `json:"result"`
+	IP     string `json:"ip,omitempty"`
+	IPv6   string `json:"ipv6,omitempty"`
+
+	// the rest comes from the state file, so it survives restarts
+	LastSuccess time.Time `json:"last_success"`
+	LastError   string    `json:"last_error,omitempty"`
+	Failures    int       `json:"failures,omitempty"`
+}
+
+func newDaemonState(update Update) *daemonState {
+	return &daemonState{
+		interval: update.Interval,
+		apiToken: update.APIToken,
+		triggers: make(chan updateTrigger),
+		update:   update,
+		Domains:  map[string]domainState{},
+	}
+}
+
+// record stores the outcome of a run
+func (s *daemonState) record(update Update, results []Result, err error, next time.Time) {
+	cache, cacheErr := loadCache(update.CacheFile)
+	if cacheErr != nil {
+		logrus.WithError(cacheErr).Debugf("unable to read %s", update.CacheFile)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.LastRun = time.Now()
+	s.LastError = ""
+	if err != nil {
+		s.LastError = redact(err.Error())
+	}
+	s.NextRun = next
+
+	// start from what the state file says, then apply this run's results
+	for _, a := range update.accounts() {
+		for _, name := range a.Names {
+			c := cache.Domains[name]
+			s.Domains[name] = domainState{
+				Result:      "error",
+				IP:          c.IP,
+				IPv6:        c.IPv6,
+				LastSuccess: c.LastSuccess,
+				LastError:   c.LastError,
+				Failures:    c.Failures,
+			}
+		}
+	}
+	for _, r := range results {
+		if r.IP != "" {
+			s.IP = r.IP
+		}
+		d := s.Domains[r.Domain]
+		if r.IP != "" || r.IPv6 != "" {
+			d.IP, d.IPv6 = r.IP, r.IPv6
+		}
+		switch {
+		case r.Status == resultFailed || r.Status == resultError:
+			d.Result = r.Status
+		case r.Updated:
+			d.Result = resultUpdated
+		default:
+			d.Result = resultUnchanged
+		}
+		s.Domains[r.Domain] = d
+	}
+}
+
+// healthy applies the same rules as the healthcheck subcommand, except that
+// the deadline follows the schedule: a run is overdue two intervals after it
+// was due
+func (s *daemonState) healthy() (bool, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case s.LastRun.IsZero():
+		return false, "no update has run yet"
+	case time.Now().After(s.NextRun.Add(2 * s.interval)):
+		return false, "last update ran " + time.Since(s.LastRun).Round(time.Second).String() + " ago"
+	case s.LastError != "":
+		return false, "last update failed: " + s.LastError
+	}
+	return true, "ok"
+}
+
+func (s *daemonState) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	ok, msg := s.healthy()
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write([]byte(msg + "\n"))
+}
+
+func (s *daemonState) handleStatus(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(data, '\n'))
+}
+
+// startServer serves the daemon's HTTP endpoints on addr until ctx is done
+func startServer(ctx context.Context, addr string, state *daemonState) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", state.handleDashboard)
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", state.handleHealthz)
+	mux.HandleFunc("/status", state.handleStatus)
+	mux.HandleFunc("/update", state.handleUpdate)
+	paths := "the dashboard, /metrics, /healthz and /status"
+	if state.apiToken != "" {
+		paths = "the dashboard, /metrics, /healthz, /status and POST /update"
+	}
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		logrus.Infof("Serving %s on %s", paths, addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logrus.WithError(err).Error("HTTP server failed")
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+}
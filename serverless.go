@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// lambdaRuntimeVersion is the version of the Lambda runtime API spoken
+const lambdaRuntimeVersion = "2018-06-01"
+
+// lambdaEvent is what an invocation can carry: the addresses at the top for a
+// direct invocation or an EventBridge input, under detail for an EventBridge
+// event, or as a JSON body for a function URL or API Gateway. All of it is
+// optional, a scheduled invocation usually has none of it.
+type lambdaEvent struct {
+	triggerAddrs
+	Detail *triggerAddrs `json:"detail"`
+	Body   string        `json:"body"`
+}
+
+// addrs picks the addresses the event gives, if any
+func (e lambdaEvent) addrs() (triggerAddrs, error) {
+	a := e.triggerAddrs
+	if e.Detail != nil && a.IP == "" && a.IPv6 == "" {
+		a = *e.Detail
+	}
+	if e.Body != "" && a.IP == "" && a.IPv6 == "" {
+		if err := json.Unmarshal([]byte(e.Body), &a); err != nil {
+			return a, fmt.Errorf("invalid body: %v", err)
+		}
+	}
+	return a, a.check()
+}
+
+// runLambda serves invocations from the AWS Lambda runtime API at api until
+// ctx is cancelled, each one a run like `duckdns update`. The binary is the
+// bootstrap of a provided.al2023 function, configured through its environment.
+func runLambda(ctx context.Context, update Update, api string) {
+	base := "http://" + api + "/" + lambdaRuntimeVersion + "/runtime"
+	hc := &http.Client{}
+	if err := update.check(); err != nil {
+		postLambda(hc, base+"/init/error", lambdaError(err, "ConfigError"))
+		fatal(exitConfig, err, "not starting")
+	}
+	logrus.Infof("Serving Lambda invocations for %s", os.Getenv("AWS_LAMBDA_FUNCTION_NAME"))
+
+	for ctx.Err() == nil {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/invocation/next", nil)
+		if err != nil {
+			fatal(exitError, err, "unable to ask for an invocation")
+		}
+		resp, err := hc.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			fatal(exitError, err, "unable to get the next invocation")
+		}
+		id := resp.Header.Get("Lambda-Runtime-Aws-Request-Id")
+		deadline, _ := strconv.ParseInt(resp.Header.Get("Lambda-Runtime-Deadline-Ms"), 10, 64)
+		var event lambdaEvent
+		payload, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		resp.Body.Close()
+		if err == nil && len(bytes.TrimSpace(payload)) > 0 {
+			err = json.Unmarshal(payload, &event)
+		}
+		var addrs triggerAddrs
+		if err == nil {
+			addrs, err = event.addrs()
+		}
+		if err != nil {
+			logrus.WithError(err).Warnf("invocation %s refused", id)
+			postLambda(hc, base+"/invocation/"+id+"/error", lambdaError(err, "InvalidEvent"))
+			continue
+		}
+
+		runCtx, cancel := context.WithCancel(ctx)
+		if deadline > 0 {
+			cancel()
+			runCtx, cancel = context.WithDeadline(ctx, time.Unix(0, deadline*int64(time.Millisecond)))
+		}
+		logrus.WithFields(logrus.Fields{"ip": addrs.IP, "ipv6": addrs.IPv6}).
+			Debugf("Lambda invocation %s", id)
+		res := runRequested(runCtx, update, addrs)
+		cancel()
+		if res.Error != "" {
+			postLambda(hc, base+"/invocation/"+id+"/error", map[string]string{
+				"errorMessage": res.Error,
+				"errorType":    "UpdateFailed",
+			})
+			continue
+		}
+		if res.Results == nil {
+			res.Results = []Result{}
+		}
+		postLambda(hc, base+"/invocation/"+id+"/response", res)
+	}
+}
+
+// lambdaError is the body the runtime API takes for an error
+func lambdaError(err error, kind string) map[string]string {
+	return map[string]string{"errorMessage": redact(err.Error()), "errorType": kind}
+}
+
+// postLambda sends v as JSON to the runtime API. There is nobody else to tell
+// when that fails, so it is only logged.
+func postLambda(hc *http.Client, to string, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		logrus.WithError(err).Error("unable to encode the Lambda answer")
+		return
+	}
+	resp, err := hc.Post(to, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logrus.WithError(err).Error("unable to answer the Lambda runtime")
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		logrus.Errorf("Lambda runtime answered %s", resp.Status)
+	}
+}
+
+// runRequested runs an update with the addresses of a request instead of
+// detecting them, when given
+func runRequested(ctx context.Context, update Update, addrs triggerAddrs) triggerResult {
+	run := update
+	run.ip, run.ipv6 = addrs.IP, addrs.IPv6
+	results, err := makeUpdate(ctx, run)
+	res := triggerResult{Results: results}
+	if err != nil {
+		res.Error = redact(err.Error())
+	}
+	return res
+}
+
+// handlerAddr is where `duckdns http-handler` listens: --addr, or the port the
+// platform hands over in PORT (Cloud Run, Knative) or
+// FUNCTIONS_CUSTOMHANDLER_PORT (Azure Functions)
+func handlerAddr(addr string) string {
+	if addr != "" {
+		return addr
+	}
+	for _, key := range []string{"PORT", "FUNCTIONS_CUSTOMHANDLER_PORT"} {
+		if port := os.Getenv(key); port != "" {
+			return ":" + port
+		}
+	}
+	return ":8080"
+}
+
+// runHTTPHandler answers every POST on addr with a run, for serverless
+// platforms that invoke a container over HTTP on a schedule. The body can give
+// the addresses like POST /update of the daemon. As with POST /update, the
+// API token has to be set and is needed as a bearer token.
+func runHTTPHandler(ctx context.Context, update Update, addr string) {
+	if err := update.check(); err != nil {
+		fatal(exitConfig, err, "not serving")
+	}
+	if update.APIToken == "" {
+		fatal(exitConfig, nil, "not serving without an API token, set --api-token, DUCK_API_TOKEN or api_token")
+	}
+	var mu sync.Mutex
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if !bearerAuthorized(w, r, update.APIToken) {
+			return
+		}
+		ip, ipv6, err := readTriggerBody(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logrus.Debugf("Update requested by %s", r.RemoteAddr)
+		// runs share the cache and history files, so one at a time
+		mu.Lock()
+		res := runRequested(r.Context(), update, triggerAddrs{IP: ip, IPv6: ipv6})
+		mu.Unlock()
+		writeTriggerResult(w, res)
+	})
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal(exitError, err, "unable to listen on %s", addr)
+	}
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	logrus.Infof("Running an update for every POST to http://%s", ln.Addr())
+	if err := srv.Serve(ln); err != http.ErrServerClosed {
+		fatal(exitError, err, "HTTP handler failed")
+	}
+}
+
+// ssmParameter reads a decrypted SSM parameter through the AWS Parameters and
+// Secrets Lambda extension, which must be a layer of the function
+func ssmParameter(name string) (string, error) {
+	port := os.Getenv("PARAMETERS_SECRETS_EXTENSION_HTTP_PORT")
+	if port == "" {
+		port = "2773"
+	}
+	u := "http://localhost:" + port + "/systemsmanager/parameters/get?withDecryption=true&name=" +
+		url.QueryEscape(name)
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Aws-Parameters-Secrets-Token", os.Getenv("AWS_SESSION_TOKEN"))
+	hc := &http.Client{Timeout: 10 * time.Second}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("parameters extension answered %s", resp.Status)
+	}
+	var p struct {
+		Parameter struct {
+			Value string `json:"Value"`
+		} `json:"Parameter"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return "", err
+	}
+	return p.Parameter.Value, nil
+}
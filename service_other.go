@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "github.com/sirupsen/logrus"
+
+func isWindowsService() bool { return false }
+
+func runWindowsService(update Update) {}
+
+func runServiceCommand(update Update, verb string) {
+	logrus.Fatal("Windows services are only supported on Windows, " +
+		"see `duckdns install systemd` instead")
+}
@@ -0,0 +1,251 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const (
+	serviceName        = "duckdns"
+	serviceDisplayName = "DuckDNS updater"
+)
+
+// isWindowsService reports whether the service manager started this process
+func isWindowsService() bool {
+	ok, err := svc.IsWindowsService()
+	if err != nil {
+		logrus.WithError(err).Debug("unable to tell if running as a service")
+		return false
+	}
+	return ok
+}
+
+// duckService runs the daemon loop under the service manager
+type duckService struct {
+	update Update
+}
+
+func (s *duckService) Execute(args []string, r <-chan svc.ChangeRequest,
+	status chan<- svc.Status) (bool, uint32) {
+
+	status <- svc.Status{State: svc.StartPending}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		runDaemon(ctx, s.update, nil)
+		close(done)
+	}()
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				status <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+				<-done
+				return false, 0
+			}
+		case <-done:
+			// the daemon should only return once cancelled
+			return false, 1
+		}
+	}
+}
+
+// runWindowsService hands control to the service manager, logging to the
+// Windows event log since there is no console
+func runWindowsService(update Update) {
+	elog, err := eventlog.Open(serviceName)
+	if err == nil {
+		defer elog.Close()
+		logrus.AddHook(&eventlogHook{elog: elog})
+	}
+
+	if err := svc.Run(serviceName, &duckService{update: update}); err != nil {
+		logrus.WithError(err).Fatal("error running the service")
+	}
+}
+
+// eventlogHook copies log entries to the Windows event log
+type eventlogHook struct {
+	elog *eventlog.Log
+}
+
+func (h *eventlogHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel,
+		logrus.WarnLevel, logrus.InfoLevel}
+}
+
+func (h *eventlogHook) Fire(e *logrus.Entry) error {
+	msg, err := e.String()
+	if err != nil {
+		return err
+	}
+	switch e.Level {
+	case logrus.InfoLevel:
+		return h.elog.Info(1, msg)
+	case logrus.WarnLevel:
+		return h.elog.Warning(2, msg)
+	}
+	return h.elog.Error(3, msg)
+}
+
+// runServiceCommand handles `duckdns service install|uninstall|start|stop`
+func runServiceCommand(update Update, verb string) {
+	var err error
+	switch verb {
+	case "install":
+		err = installService(update)
+	case "uninstall":
+		err = uninstallService()
+	case "start":
+		err = startService()
+	case "stop":
+		err = stopService()
+	default:
+		err = fmt.Errorf("unknown service command %q, expected install, uninstall, start or stop",
+			verb)
+	}
+	if err != nil {
+		logrus.WithError(err).Fatalf("unable to %s the service", verb)
+	}
+}
+
+func installService(update Update) error {
+	bin, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	var args []string
+	if isRemoteConfig(update.configFile) {
+		args = append(args, "-c", update.configFile)
+	} else if update.configFile != "" {
+		if _, err := os.Stat(update.configFile); err == nil {
+			config, _ := filepath.Abs(update.configFile)
+			args = append(args, "-c", config)
+		}
+	}
+	args = append(args, "daemon")
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", serviceName)
+	}
+	s, err := m.CreateService(serviceName, bin, mgr.Config{
+		DisplayName: serviceDisplayName,
+		Description: "Keeps DuckDNS records pointing at this machine",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	// restart after a minute if the updater ever exits unexpectedly
+	err = s.SetRecoveryActions([]mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: time.Minute},
+	}, uint32((24 * time.Hour).Seconds()))
+	if err != nil {
+		logrus.WithError(err).Warn("unable to set service recovery actions")
+	}
+
+	err = eventlog.InstallAsEventCreate(serviceName,
+		eventlog.Error|eventlog.Warning|eventlog.Info)
+	if err != nil {
+		logrus.WithError(err).Warn("unable to register the event log source")
+	}
+
+	logrus.Infof("installed service %s running %s %v", serviceName, bin, args)
+	return nil
+}
+
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	if err := eventlog.Remove(serviceName); err != nil {
+		logrus.WithError(err).Debug("unable to remove the event log source")
+	}
+
+	logrus.Infof("removed service %s", serviceName)
+	return nil
+}
+
+func startService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+	defer s.Close()
+	return s.Start()
+}
+
+func stopService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+	defer s.Close()
+
+	status, err := s.Control(svc.Stop)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(30 * time.Second)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s to stop", serviceName)
+		}
+		time.Sleep(300 * time.Millisecond)
+		if status, err = s.Query(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"text/template"
+)
+
+// SlackNotify configures messages to a Slack incoming webhook
+type SlackNotify struct {
+	Webhook string `yaml:"webhook"`
+	// Template is a text/template rendered with the event, see the README
+	Template string `yaml:"template"`
+}
+
+type slackNotifier struct {
+	webhook  string
+	template *template.Template
+	hc       *http.Client
+}
+
+func newSlackNotifier(c SlackNotify, hc *http.Client) (*slackNotifier, error) {
+	t, err := parseTemplate("slack", c.Template)
+	if err != nil {
+		return nil, err
+	}
+	return &slackNotifier{webhook: c.Webhook, template: t, hc: hc}, nil
+}
+
+func (s *slackNotifier) Name() string { return "slack" }
+
+func (s *slackNotifier) Notify(ctx context.Context, ev notifyEvent) error {
+	text, err := message(s.template, ev)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(struct {
+		Text string `json:"text"`
+	}{text})
+	if err != nil {
+		return err
+	}
+	return postNotification(ctx, s.hc, s.webhook, "application/json", body)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const duckDNSZone = ".duckdns.org"
+
+// fqdn returns the full host name for a configured subdomain. Names with a dot
+// are already full, DuckDNS subdomains can't have one.
+func fqdn(name string) string {
+	if strings.Contains(name, ".") {
+		return name
+	}
+	return name + duckDNSZone
+}
+
+// recordState compares the addresses a name resolves to with the addresses
+// detected for this machine
+func recordState(records []net.IP, v4, v6 net.IP) string {
+	state := "in sync"
+	for _, want := range []net.IP{v4, v6} {
+		if want == nil {
+			continue
+		}
+		found, sameFamily := false, false
+		for _, r := range records {
+			if (r.To4() != nil) != (want.To4() != nil) {
+				continue
+			}
+			sameFamily = true
+			if r.Equal(want) {
+				found = true
+			}
+		}
+		switch {
+		case !sameFamily:
+			return "missing"
+		case !found:
+			state = "stale"
+		}
+	}
+	return state
+}
+
+// runStatus prints whether every configured name points at the current public
+// address, without updating anything. It exits non-zero unless all are in sync.
+func runStatus(ctx context.Context, update Update) {
+	if err := update.check(); err != nil {
+		fatal(exitConfig, err, "not checking")
+	}
+	hc, err := newHTTPClient(update)
+	if err != nil {
+		logrus.WithError(err).Fatal("error preparing status check")
+	}
+
+	providers, err := newIPProviders(update, hc)
+	if err != nil {
+		logrus.WithError(err).Fatal("error preparing status check")
+	}
+	resolver, err := newResolver(update)
+	if err != nil {
+		logrus.WithError(err).Fatal("error preparing status check")
+	}
+
+	v4, err := detectIP(ctx, providers, false, update.IPQuorum)
+	if err != nil {
+		logrus.WithError(err).Debug("unable to detect public IPv4 address")
+	}
+	v6, err := detectIP(ctx, providers, true, update.IPQuorum)
+	if err != nil {
+		logrus.WithError(err).Debug("unable to detect public IPv6 address")
+	}
+	if v4 == nil && v6 == nil {
+		logrus.Fatal("unable to detect the public IP address")
+	}
+	logrus.Debugf("Detected public addresses: %v %v", v4, v6)
+
+	cache, err := loadCache(update.CacheFile)
+	if err != nil {
+		logrus.WithError(err).Warnf("ignoring unreadable cache file %s", update.CacheFile)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tRECORDS\tPUBLIC IP\tSTATUS\tLAST UPDATE")
+
+	ok := true
+	for _, a := range update.accounts() {
+		for _, n := range a.Names {
+			host := fqdn(n)
+			var records []net.IP
+			addrs, err := resolver.LookupIPAddr(ctx, host)
+			if err != nil {
+				logrus.WithError(err).Debugf("unable to resolve %s", host)
+			}
+			for _, addr := range addrs {
+				records = append(records, addr.IP)
+			}
+
+			state := recordState(records, v4, v6)
+			if state != "in sync" {
+				ok = false
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", host, joinIPs(records),
+				joinIPs([]net.IP{v4, v6}), state, lastUpdate(cache.Domains[n]))
+		}
+	}
+	w.Flush()
+
+	if !ok {
+		logrus.Exit(1)
+	}
+}
+
+// lastUpdate describes how the latest updates of a name went, according to
+// the state file
+func lastUpdate(c cachedIP) string {
+	switch {
+	case c.Failures > 0:
+		return fmt.Sprintf("failed %d time(s): %s", c.Failures, c.LastError)
+	case c.LastSuccess.IsZero():
+		return "-"
+	}
+	return time.Since(c.LastSuccess).Round(time.Second).String() + " ago"
+}
+
+func joinIPs(ips []net.IP) string {
+	var s []string
+	for _, ip := range ips {
+		if ip != nil {
+			s = append(s, ip.String())
+		}
+	}
+	if len(s) == 0 {
+		return "-"
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// defaultSTUNServers are tried in order by the plain "stun" provider
+var defaultSTUNServers = []string{
+	"stun.l.google.com:19302",
+	"stun.cloudflare.com:3478",
+}
+
+const (
+	stunMagicCookie     = 0x2112A442
+	stunBindingRequest  = 0x0001
+	stunBindingSuccess  = 0x0101
+	stunMappedAddress   = 0x0001
+	stunXORMappedAddr   = 0x0020
+	stunHeaderLen       = 20
+	stunAttempts        = 3
+	stunAttemptTimeout  = 2 * time.Second
+	stunMaxResponseSize = 1500
+)
+
+// stunProvider discovers the public address with a STUN binding request, which
+// works behind NAT without an HTTP "what's my IP" service
+type stunProvider struct {
+	servers []string
+}
+
+func (p *stunProvider) Name() string {
+	return "stun:" + strings.Join(p.servers, ",")
+}
+
+func (p *stunProvider) Detect(ctx context.Context, v6 bool) (net.IP, error) {
+	var errs []string
+	for _, s := range p.servers {
+		ip, err := stunBinding(ctx, s, v6)
+		if err == nil {
+			return ip, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", s, err))
+	}
+	return nil, errors.New(strings.Join(errs, "; "))
+}
+
+// stunBinding sends a binding request to server and returns the mapped
+// address from the reply, retransmitting a few times since it is UDP
+func stunBinding(ctx context.Context, server string, v6 bool) (net.IP, error) {
+	network := "udp4"
+	if v6 {
+		network = "udp6"
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req := make([]byte, stunHeaderLen)
+	binary.BigEndian.PutUint16(req[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+	if _, err := rand.Read(req[8:20]); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, stunMaxResponseSize)
+	for i := 0; i < stunAttempts; i++ {
+		deadline := time.Now().Add(stunAttemptTimeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetDeadline(deadline)
+
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		n, err := conn.Read(buf)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() && ctx.Err() == nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return parseSTUNResponse(buf[:n], req[8:20])
+	}
+	return nil, fmt.Errorf("no reply after %d attempts", stunAttempts)
+}
+
+// parseSTUNResponse extracts the (XOR-)MAPPED-ADDRESS from a binding success
+func parseSTUNResponse(b, txID []byte) (net.IP, error) {
+	if len(b) < stunHeaderLen ||
+		binary.BigEndian.Uint16(b[0:]) != stunBindingSuccess ||
+		binary.BigEndian.Uint32(b[4:]) != stunMagicCookie ||
+		string(b[8:20]) != string(txID) {
+		return nil, errors.New("unexpected STUN response")
+	}
+
+	var mapped net.IP
+	attrs := b[stunHeaderLen:]
+	for len(attrs) >= 4 {
+		typ := binary.BigEndian.Uint16(attrs[0:])
+		size := int(binary.BigEndian.Uint16(attrs[2:]))
+		if len(attrs) < 4+size {
+			break
+		}
+		val := attrs[4 : 4+size]
+
+		switch typ {
+		case stunXORMappedAddr:
+			if ip := stunAddress(val, b[4:20]); ip != nil {
+				return ip, nil
+			}
+		case stunMappedAddress:
+			mapped = stunAddress(val, nil)
+		}
+
+		// attributes are padded to a multiple of four bytes
+		attrs = attrs[4+(size+3)&^3:]
+	}
+	if mapped != nil {
+		return mapped, nil
+	}
+	return nil, errors.New("STUN response has no mapped address")
+}
+
+// stunAddress decodes an address attribute, XORed with the cookie and
+// transaction ID in key when set
+func stunAddress(val, key []byte) net.IP {
+	if len(val) < 4 {
+		return nil
+	}
+	size := net.IPv4len
+	if val[1] == 0x02 {
+		size = net.IPv6len
+	}
+	if len(val) < 4+size {
+		return nil
+	}
+
+	ip := make(net.IP, size)
+	copy(ip, val[4:4+size])
+	for i := range ip {
+		if key != nil {
+			ip[i] ^= key[i]
+		}
+	}
+	return ip
+}
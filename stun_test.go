@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+var stunTxID = []byte("0123456789ab")
+
+// stunMessage builds a binding response carrying attrs
+func stunMessage(typ uint16, txID []byte, attrs ...[]byte) []byte {
+	b := make([]byte, stunHeaderLen)
+	binary.BigEndian.PutUint16(b[0:], typ)
+	binary.BigEndian.PutUint32(b[4:], stunMagicCookie)
+	copy(b[8:], txID)
+	for _, a := range attrs {
+		b = append(b, a...)
+	}
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)-stunHeaderLen))
+	return b
+}
+
+// stunAttr encodes an address attribute, XORed with the cookie and
+// transaction ID for XOR-MAPPED-ADDRESS
+func stunAttr(typ uint16, ip net.IP) []byte {
+	family, addr := byte(0x01), ip.To4()
+	if addr == nil {
+		family, addr = 0x02, ip.To16()
+	}
+	val := append([]byte{0, family, 0, 0}, addr...)
+	if typ == stunXORMappedAddr {
+		key := make([]byte, 4, 16)
+		binary.BigEndian.PutUint32(key, stunMagicCookie)
+		key = append(key, stunTxID...)
+		for i := range addr {
+			val[4+i] ^= key[i]
+		}
+	}
+	a := make([]byte, 4, 4+len(val))
+	binary.BigEndian.PutUint16(a[0:], typ)
+	binary.BigEndian.PutUint16(a[2:], uint16(len(val)))
+	return append(a, val...)
+}
+
+func TestParseSTUNResponse(t *testing.T) {
+	v4, v6 := net.ParseIP("203.0.113.5"), net.ParseIP("2001:db8::1")
+	// SOFTWARE, three bytes padded to four
+	software := []byte{0x80, 0x22, 0, 3, 'g', 'o', '!', 0}
+
+	tests := []struct {
+		name    string
+		msg     []byte
+		want    net.IP
+		wantErr bool
+	}{
+		{
+			name: "XOR-MAPPED-ADDRESS",
+			msg:  stunMessage(stunBindingSuccess, stunTxID, stunAttr(stunXORMappedAddr, v4)),
+			want: v4,
+		},
+		{
+			name: "XOR-MAPPED-ADDRESS IPv6",
+			msg:  stunMessage(stunBindingSuccess, stunTxID, stunAttr(stunXORMappedAddr, v6)),
+			want: v6,
+		},
+		{
+			name: "after a padded attribute",
+			msg:  stunMessage(stunBindingSuccess, stunTxID, software, stunAttr(stunXORMappedAddr, v4)),
+			want: v4,
+		},
+		{
+			name: "XOR-MAPPED-ADDRESS wins over MAPPED-ADDRESS",
+			msg: stunMessage(stunBindingSuccess, stunTxID,
+				stunAttr(stunMappedAddress, net.ParseIP("10.0.0.1")), stunAttr(stunXORMappedAddr, v4)),
+			want: v4,
+		},
+		{
+			name: "MAPPED-ADDRESS of an old server",
+			msg:  stunMessage(stunBindingSuccess, stunTxID, stunAttr(stunMappedAddress, v4)),
+			want: v4,
+		},
+		{
+			name:    "no address",
+			msg:     stunMessage(stunBindingSuccess, stunTxID, software),
+			wantErr: true,
+		},
+		{
+			name:    "other transaction",
+			msg:     stunMessage(stunBindingSuccess, []byte("ba9876543210"), stunAttr(stunXORMappedAddr, v4)),
+			wantErr: true,
+		},
+		{
+			name:    "error response",
+			msg:     stunMessage(0x0111, stunTxID),
+			wantErr: true,
+		},
+		{
+			name:    "truncated",
+			msg:     stunMessage(stunBindingSuccess, stunTxID, stunAttr(stunXORMappedAddr, v4))[:26],
+			wantErr: true,
+		},
+		{
+			name:    "too short",
+			msg:     []byte{0x01, 0x01},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSTUNResponse(tt.msg, stunTxID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSTUNResponse() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseSTUNResponse() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
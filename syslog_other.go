@@ -0,0 +1,56 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+	"net/url"
+
+	"github.com/sirupsen/logrus"
+	lsyslog "github.com/sirupsen/logrus/hooks/syslog"
+)
+
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":   syslog.LOG_KERN,
+	"user":   syslog.LOG_USER,
+	"daemon": syslog.LOG_DAEMON,
+	"syslog": syslog.LOG_SYSLOG,
+	"local0": syslog.LOG_LOCAL0,
+	"local1": syslog.LOG_LOCAL1,
+	"local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3,
+	"local4": syslog.LOG_LOCAL4,
+	"local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6,
+	"local7": syslog.LOG_LOCAL7,
+}
+
+// addSyslogHook copies log entries to syslog. target is "local" for the local
+// syslog daemon, or a udp:// or tcp:// address for a remote one.
+func addSyslogHook(target, facility, tag string) error {
+	prio, ok := syslogFacilities[facility]
+	if !ok {
+		return fmt.Errorf("unknown syslog facility %q", facility)
+	}
+
+	var network, addr string
+	if target != "local" {
+		u, err := url.Parse(target)
+		if err != nil {
+			return err
+		}
+		if u.Scheme != "udp" && u.Scheme != "tcp" {
+			return fmt.Errorf("syslog address %q must be local, udp://host:port or tcp://host:port", target)
+		}
+		network, addr = u.Scheme, u.Host
+	}
+
+	hook, err := lsyslog.NewSyslogHook(network, addr, prio|syslog.LOG_INFO, tag)
+	if err != nil {
+		return err
+	}
+	logrus.AddHook(hook)
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "errors"
+
+func addSyslogHook(target, facility, tag string) error {
+	return errors.New("syslog is not available on Windows, " +
+		"the service logs to the event log instead")
+}
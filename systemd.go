@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sdNotifier speaks the sd_notify protocol to systemd. It does nothing when
+// not started by systemd with NOTIFY_SOCKET set.
+type sdNotifier struct {
+	socket string
+
+	mu        sync.Mutex
+	busySince time.Time
+}
+
+func newSDNotifier() *sdNotifier {
+	n := &sdNotifier{socket: os.Getenv("NOTIFY_SOCKET")}
+	if n.socket != "" {
+		logrus.Debugf("Notifying systemd on %s", n.socket)
+	}
+	return n
+}
+
+// notify sends state, such as "READY=1", to systemd
+func (n *sdNotifier) notify(state string) {
+	if n.socket == "" {
+		return
+	}
+	addr := &net.UnixAddr{Name: n.socket, Net: "unixgram"}
+	// a leading @ is an abstract socket
+	if addr.Name[0] == '@' {
+		addr.Name = "\x00" + addr.Name[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		logrus.WithError(err).Debug("unable to notify systemd")
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		logrus.WithError(err).Debug("unable to notify systemd")
+	}
+}
+
+// busy marks the start and end of an update run, so the watchdog can tell a
+// wedged run apart from waiting for the next one
+func (n *sdNotifier) busy(b bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if b {
+		n.busySince = time.Now()
+	} else {
+		n.busySince = time.Time{}
+	}
+}
+
+// watchdog pings systemd at half the WatchdogSec interval for as long as no
+// update run has been stuck for a whole interval
+func (n *sdNotifier) watchdog() {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if n.socket == "" || err != nil || usec <= 0 {
+		return
+	}
+	timeout := time.Duration(usec) * time.Microsecond
+	logrus.Debugf("systemd watchdog enabled with a %s timeout", timeout)
+
+	go func() {
+		for range time.Tick(timeout / 2) {
+			n.mu.Lock()
+			stuck := !n.busySince.IsZero() && time.Since(n.busySince) > timeout
+			n.mu.Unlock()
+
+			if stuck {
+				logrus.Warn("update run is stuck, no longer pinging the systemd watchdog")
+				continue
+			}
+			n.notify("WATCHDOG=1")
+		}
+	}()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+const defaultTelegramAPI = "https://api.telegram.org"
+
+// TelegramNotify configures messages sent by a Telegram bot
+type TelegramNotify struct {
+	// BotToken is the token BotFather handed out for the bot
+	BotToken string `yaml:"bot_token"`
+	// ChatID is the user, group or channel the bot writes to
+	ChatID   string `yaml:"chat_id"`
+	Template string `yaml:"template"`
+	// APIURL points at a self-hosted Bot API server instead of Telegram's
+	APIURL string `yaml:"api_url"`
+}
+
+type telegramNotifier struct {
+	url      string
+	chatID   string
+	template *template.Template
+	hc       *http.Client
+}
+
+func newTelegramNotifier(c TelegramNotify, hc *http.Client) (*telegramNotifier, error) {
+	t, err := parseTemplate("telegram", c.Template)
+	if err != nil {
+		return nil, err
+	}
+	api := c.APIURL
+	if api == "" {
+		api = defaultTelegramAPI
+	}
+	return &telegramNotifier{
+		url:      strings.TrimSuffix(api, "/") + "/bot" + c.BotToken + "/sendMessage",
+		chatID:   c.ChatID,
+		template: t,
+		hc:       hc,
+	}, nil
+}
+
+func (t *telegramNotifier) Name() string { return "telegram" }
+
+func (t *telegramNotifier) Notify(ctx context.Context, ev notifyEvent) error {
+	text, err := message(t.template, ev)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(struct {
+		ChatID string `json:"chat_id"`
+		Text   string `json:"text"`
+	}{t.chatID, text})
+	if err != nil {
+		return err
+	}
+	return postNotification(ctx, t.hc, t.url, "application/json", body)
+}
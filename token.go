@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// readTokenInput reads a token from stdin. On a terminal it prompts without
+// echoing, otherwise the first line is used, so that the token stays out of
+// shell history and ps output.
+func readTokenInput() (string, error) {
+	var token string
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, "DuckDNS token: ")
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		token = string(b)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		token = line
+	}
+
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", fmt.Errorf("no token given")
+	}
+	return token, nil
+}
+
+// stdinIsTerminal reports whether someone could answer a prompt
+func stdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// normalizeTokens drops the whitespace around every token, such as the
+// newline pasted along with it
+func (u *Update) normalizeTokens() {
+	u.Token = strings.TrimSpace(u.Token)
+	for i := range u.Accounts {
+		u.Accounts[i].Token = strings.TrimSpace(u.Accounts[i].Token)
+	}
+}
+
+// tokenProblem says what is wrong with a DuckDNS token, empty when it looks
+// like the UUID DuckDNS hands out. The token itself is never part of it.
+func tokenProblem(token string) string {
+	switch {
+	case tokenPattern.MatchString(token):
+		return ""
+	case strings.ContainsAny(token, "\r\n"):
+		return "it contains a line break"
+	case strings.ContainsAny(token, " \t"):
+		return "it contains spaces"
+	case strings.Trim(token, `"'`) != token:
+		return "it is wrapped in quotes"
+	case strings.HasPrefix(strings.ToLower(token), "token="):
+		return "it starts with token="
+	case len(token) != 36:
+		return fmt.Sprintf("it is %d characters long instead of 36", len(token))
+	default:
+		return "it has characters other than hex digits and hyphens, or hyphens out of place"
+	}
+}
+
+// checkTokens returns an error for every DuckDNS account whose token is
+// malformed, which DuckDNS would only answer with a KO for every name
+func (u *Update) checkTokens() error {
+	var problems []string
+	for i, a := range u.accounts() {
+		if providerName(a) != "duckdns" || a.Token == "" {
+			continue
+		}
+		if p := tokenProblem(a.Token); p != "" {
+			problems = append(problems, fmt.Sprintf("account %d: token malformed (expected a UUID), %s", i+1, p))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(problems, "; "))
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracingShutdownTimeout bounds the wait for the last spans to be exported
+const tracingShutdownTimeout = 5 * time.Second
+
+// tracer creates every span. It is a no-op until startTracing installs an
+// exporter, so the spans cost nothing when tracing is off.
+var tracer = otel.Tracer("github.com/theag3nt/duckdns")
+
+// tracingEnabled reports whether spans are exported, to --otlp-endpoint or
+// wherever the standard OTEL_EXPORTER_OTLP_* variables point
+func tracingEnabled(u Update) bool {
+	return u.OTLPEndpoint != "" || os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" ||
+		os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// startTracing sets up the OTLP/HTTP exporter when tracing is enabled and
+// records loading the config, from start to loaded, after the fact since the
+// exporter comes from the config. A single run is one trace, under a span
+// named after the command; the daemon starts a trace for every check
+// instead, as one spanning its whole life would never be complete. The
+// returned function flushes the spans, and also runs when fatal exits.
+func startTracing(ctx context.Context, u Update, command string, start, loaded time.Time) (context.Context, func()) {
+	if !tracingEnabled(u) {
+		return ctx, func() {}
+	}
+	var opts []otlptracehttp.Option
+	if u.OTLPEndpoint != "" {
+		endpoint, err := otlpEndpointURL(u.OTLPEndpoint)
+		if err != nil {
+			logrus.Warnf("not tracing, otlp endpoint %q is not an http(s) URL", u.OTLPEndpoint)
+			return ctx, func() {}
+		}
+		opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		logrus.WithError(err).Warn("not tracing, unable to set up the OTLP exporter")
+		return ctx, func() {}
+	}
+	res, _ := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName("duckdns"),
+		semconv.ServiceVersion(version),
+	))
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logrus.WithError(err).Debug("tracing")
+	}))
+
+	var root trace.Span
+	if !u.Daemon {
+		ctx, root = tracer.Start(ctx, "duckdns "+command, trace.WithTimestamp(start))
+	}
+	_, span := tracer.Start(ctx, "load config", trace.WithTimestamp(start),
+		trace.WithAttributes(attribute.String("config.file", u.configFile)))
+	span.End(trace.WithTimestamp(loaded))
+
+	done := false
+	shutdown := func() {
+		if done {
+			return
+		}
+		done = true
+		if root != nil {
+			root.End()
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		if err := tp.Shutdown(ctx); err != nil {
+			logrus.WithError(err).Warn("unable to export the last spans")
+		}
+	}
+	logrus.RegisterExitHandler(shutdown)
+	return ctx, shutdown
+}
+
+// otlpEndpointURL is where traces are sent for the collector at endpoint,
+// /v1/traces unless the URL has a path of its own
+func otlpEndpointURL(endpoint string) (string, error) {
+	e, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	if (e.Scheme != "http" && e.Scheme != "https") || e.Host == "" {
+		return "", fmt.Errorf("%q is not an http(s) URL", endpoint)
+	}
+	if e.Path == "" || e.Path == "/" {
+		e.Path = "/v1/traces"
+	}
+	return e.String(), nil
+}
+
+// endSpan records err, if any, on span and ends it. The error is redacted
+// like the status, as that of a request holds the whole update URL.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		msg := redact(err.Error())
+		span.RecordError(errors.New(msg))
+		span.SetStatus(codes.Error, msg)
+	}
+	span.End()
+}
+
+// tracingTransport gives every request of the shared client a span, with
+// the token masked in the recorded URL
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+func (t tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := tracer.Start(req.Context(), "HTTP "+req.Method+" "+req.URL.Host,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.URLFull(redact(req.URL.String())),
+			semconv.ServerAddress(req.URL.Hostname()),
+		))
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	span.End()
+	return resp, nil
+}
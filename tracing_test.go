@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestEndSpanRedacts(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer tp.Shutdown(context.Background())
+
+	const token = "a7c4d0ad-114e-40ef-ba1d-d217904a50f2"
+	_, span := tp.Tracer("test").Start(context.Background(), "update")
+	endSpan(span, &url.Error{
+		Op:  "Get",
+		URL: "https://www.duckdns.org/update?domains=home&token=" + token + "&ip=",
+		Err: errors.New("connection reset by peer"),
+	})
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("recorded %d spans, want 1", len(spans))
+	}
+	s := spans[0]
+	if strings.Contains(s.Status().Description, token) {
+		t.Errorf("status %q holds the token", s.Status().Description)
+	}
+	if len(s.Events()) == 0 {
+		t.Fatal("the error was not recorded")
+	}
+	for _, e := range s.Events() {
+		for _, a := range e.Attributes {
+			if v := a.Value.Emit(); strings.Contains(v, token) {
+				t.Errorf("event %s attribute %s = %q holds the token", e.Name, a.Key, v)
+			}
+		}
+	}
+	if want := "token=" + fingerprint(token); !strings.Contains(s.Status().Description, want) {
+		t.Errorf("status %q, want the token as %s", s.Status().Description, want)
+	}
+}
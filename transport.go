@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theag3nt/duckdns/pkg/duckdns"
+)
+
+// maxIdleConnsPerHost keeps a connection around for every worker updating
+// names at once, well above the default concurrency
+const maxIdleConnsPerHost = 16
+
+// transportSettings are what the shared client is built from
+type transportSettings struct {
+	proxy          string
+	connectTimeout time.Duration
+	timeout        time.Duration
+	caFile         string
+	minVersion     string
+	pins           string
+	pinHost        string
+	network        string
+	sourceIP       string
+	bindInterface  string
+}
+
+var (
+	clientMu       sync.Mutex
+	sharedClient   *http.Client
+	sharedSettings transportSettings
+)
+
+// newHTTPClient returns the client used for all DuckDNS requests, with
+// timeouts so that a stalled endpoint can't hang the process. The client is
+// shared by every run of the process while the settings stay the same, so the
+// daemon, the IP providers and notifications keep reusing open connections
+// instead of paying for a new TLS handshake on every request.
+func newHTTPClient(u Update) (*http.Client, error) {
+	settings := transportSettings{
+		proxy:          u.Proxy,
+		connectTimeout: u.ConnectTimeout,
+		timeout:        u.Timeout,
+		caFile:         u.TLS.CAFile,
+		minVersion:     u.TLS.MinVersion,
+		pins:           strings.Join(u.TLS.Pins, " "),
+		pinHost:        endpointHost(u.Endpoint),
+		network:        endpointNetwork(u),
+		sourceIP:       u.SourceIP,
+		bindInterface:  u.BindInterface,
+	}
+	clientMu.Lock()
+	defer clientMu.Unlock()
+	if sharedClient != nil && sharedSettings == settings {
+		return sharedClient, nil
+	}
+
+	proxy, err := proxyFunc(u.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := u.TLS.build(settings.pinHost)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{
+		Timeout:   u.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	if err := bindDialer(dialer, u.SourceIP, u.BindInterface); err != nil {
+		return nil, err
+	}
+	if sharedClient != nil {
+		sharedClient.CloseIdleConnections()
+	}
+	sharedClient = &http.Client{
+		Timeout: u.Timeout,
+		Transport: &http.Transport{
+			Proxy:       proxy,
+			DialContext: endpointDialer(dialer, settings.pinHost, settings.network),
+			// a custom dialer turns HTTP/2 off unless asked for
+			ForceAttemptHTTP2:     true,
+			TLSClientConfig:       tlsConfig,
+			TLSHandshakeTimeout:   u.ConnectTimeout,
+			ResponseHeaderTimeout: u.Timeout,
+			MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+			IdleConnTimeout:       90 * time.Second,
+		},
+	}
+	if tracingEnabled(u) {
+		sharedClient.Transport = tracingTransport{next: sharedClient.Transport}
+	}
+	sharedSettings = settings
+	return sharedClient, nil
+}
+
+// bindDialer makes connections leave from source, an address of this
+// machine, and through the network interface iface, for multi-homed hosts
+// where the route matters to the address DuckDNS sees
+func bindDialer(d *net.Dialer, source, iface string) error {
+	if source != "" {
+		ip := net.ParseIP(source)
+		if ip == nil {
+			return fmt.Errorf("source_ip %q is not an IP address", source)
+		}
+		d.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	if iface == "" {
+		return nil
+	}
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return fmt.Errorf("bind_interface: %v", err)
+	}
+	return bindInterface(d, ifi)
+}
+
+// endpointNetwork is the network to dial the endpoint with, tcp4 or tcp6
+// when a family is forced
+func endpointNetwork(u Update) string {
+	switch {
+	case u.ForceIPv4:
+		return "tcp4"
+	case u.ForceIPv6:
+		return "tcp6"
+	}
+	return "tcp"
+}
+
+// endpointDialer dials connections to host over network, and everything
+// else as asked. IP lookups and notifications keep using either family, an
+// IPv6 lookup can't work over IPv4. Through a proxy the proxy decides.
+func endpointDialer(d *net.Dialer, host, network string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	forced := network
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if h, _, err := net.SplitHostPort(addr); err == nil && strings.EqualFold(h, host) {
+			network = forced
+		}
+		return d.DialContext(ctx, network, addr)
+	}
+}
+
+// proxyFunc returns the proxy selection for the transport. An explicit proxy
+// wins, otherwise the standard HTTP_PROXY, HTTPS_PROXY and NO_PROXY variables
+// are used. http.Transport speaks both HTTP CONNECT and SOCKS5 itself.
+func proxyFunc(proxy string) (func(*http.Request) (*url.URL, error), error) {
+	if proxy == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	p, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %v", err)
+	}
+	switch p.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, use http, https or socks5",
+			p.Scheme)
+	}
+	if p.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", proxy)
+	}
+
+	return http.ProxyURL(p), nil
+}
+
+// TLSConfig adjusts how HTTPS servers are checked, for networks behind a
+// TLS-intercepting middlebox or that only want to trust DuckDNS's own key
+type TLSConfig struct {
+	// CAFile is a PEM bundle of root certificates trusted on top of the
+	// system ones
+	CAFile string `yaml:"ca_file"`
+	// MinVersion is the oldest TLS version accepted: 1.0, 1.1, 1.2 or 1.3.
+	// Go accepts 1.2 and up when it is empty.
+	MinVersion string `yaml:"min_version"`
+	// Pins are the keys the update endpoint must present, each either
+	// sha256/<base64> of a public key or the SHA-256 fingerprint of a
+	// certificate in hex. A match anywhere in the chain is enough, so an
+	// intermediate can be pinned.
+	Pins []string `yaml:"pins"`
+}
+
+// merge fills the settings left unset in c from o
+func (c *TLSConfig) merge(o TLSConfig) {
+	if c.CAFile == "" {
+		c.CAFile = o.CAFile
+	}
+	if c.MinVersion == "" {
+		c.MinVersion = o.MinVersion
+	}
+	if len(c.Pins) == 0 {
+		c.Pins = o.Pins
+	}
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// build returns the crypto/tls settings, nil when there is nothing to change.
+// The pins only apply to connections to pinHost.
+func (c TLSConfig) build(pinHost string) (*tls.Config, error) {
+	if c.CAFile == "" && c.MinVersion == "" && len(c.Pins) == 0 {
+		return nil, nil
+	}
+	config := &tls.Config{}
+
+	if c.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		data, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls ca_file: %v", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("tls ca_file: no certificates found in %s", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if c.MinVersion != "" {
+		v, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("tls min_version %q is not 1.0, 1.1, 1.2 or 1.3", c.MinVersion)
+		}
+		config.MinVersion = v
+	}
+
+	if len(c.Pins) > 0 {
+		keys, certs, err := parsePins(c.Pins)
+		if err != nil {
+			return nil, err
+		}
+		// no server name is sent to an IP address
+		if net.ParseIP(pinHost) != nil {
+			pinHost = ""
+		}
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			if !strings.EqualFold(cs.ServerName, pinHost) {
+				return nil
+			}
+			for _, chain := range cs.VerifiedChains {
+				for _, cert := range chain {
+					key := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+					sum := sha256.Sum256(cert.Raw)
+					if keys[key] || certs[sum] {
+						return nil
+					}
+				}
+			}
+			return errors.New("the endpoint's certificate matches none of the tls pins")
+		}
+	}
+	return config, nil
+}
+
+// parsePins splits pins into public key and certificate digests
+func parsePins(pins []string) (keys, certs map[[32]byte]bool, err error) {
+	keys, certs = map[[32]byte]bool{}, map[[32]byte]bool{}
+	for _, p := range pins {
+		var sum []byte
+		if b64 := strings.TrimPrefix(p, "sha256/"); b64 != p {
+			sum, err = base64.StdEncoding.DecodeString(b64)
+			// curl writes the pin as sha256//<base64>, and base64 may start with a slash
+			if err != nil || len(sum) != sha256.Size {
+				sum, err = base64.StdEncoding.DecodeString(strings.TrimPrefix(b64, "/"))
+			}
+		} else {
+			sum, err = hex.DecodeString(strings.Replace(p, ":", "", -1))
+		}
+		if err != nil || len(sum) != sha256.Size {
+			return nil, nil, fmt.Errorf("tls pin %q is neither sha256/<base64> nor a SHA-256 fingerprint", p)
+		}
+		var d [32]byte
+		copy(d[:], sum)
+		if strings.HasPrefix(p, "sha256/") {
+			keys[d] = true
+		} else {
+			certs[d] = true
+		}
+	}
+	return keys, certs, nil
+}
+
+// endpointHost is the host the pins apply to, DuckDNS unless the endpoint
+// says otherwise
+func endpointHost(endpoint string) string {
+	if endpoint == "" {
+		endpoint = duckdns.DefaultEndpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestParsePins(t *testing.T) {
+	sum := sha256.Sum256([]byte("certificate"))
+	b64 := base64.StdEncoding.EncodeToString(sum[:])
+	hexSum := hex.EncodeToString(sum[:])
+	var colons []string
+	for i := 0; i < len(hexSum); i += 2 {
+		colons = append(colons, strings.ToUpper(hexSum[i:i+2]))
+	}
+
+	tests := []struct {
+		name    string
+		pin     string
+		key     bool
+		wantErr bool
+	}{
+		{name: "public key", pin: "sha256/" + b64, key: true},
+		{name: "public key as curl writes it", pin: "sha256//" + b64, key: true},
+		{name: "certificate fingerprint", pin: hexSum},
+		{name: "fingerprint with colons", pin: strings.Join(colons, ":")},
+		{name: "short digest", pin: "sha256/" + base64.StdEncoding.EncodeToString(sum[:16]), wantErr: true},
+		{name: "not base64", pin: "sha256/not-base64!", wantErr: true},
+		{name: "not hex", pin: "example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, certs, err := parsePins([]string{tt.pin})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePins(%q) error = %v, wantErr %t", tt.pin, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if keys[sum] != tt.key || certs[sum] == tt.key {
+				t.Errorf("parsePins(%q) = keys %v, certs %v, want it as a key pin: %t", tt.pin, keys, certs, tt.key)
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/term"
+)
+
+// watchLogLines is how many of the latest log lines `duckdns watch` shows
+const watchLogLines = 12
+
+// watchResolveInterval is how often `duckdns watch` looks the names up again
+const watchResolveInterval = 15 * time.Second
+
+// logRing keeps the latest log lines for the terminal view, formatted like
+// the text log on stderr
+type logRing struct {
+	mu    sync.Mutex
+	lines []string
+	size  int
+}
+
+func (l *logRing) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (l *logRing) Fire(e *logrus.Entry) error {
+	line := e.Time.Format("15:04:05") + " " + strings.ToUpper(e.Level.String())[:4] + " " + e.Message
+	if err, ok := e.Data[logrus.ErrorKey]; ok {
+		line += ": " + fmt.Sprint(err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, redact(line))
+	if len(l.lines) > l.size {
+		l.lines = l.lines[len(l.lines)-l.size:]
+	}
+	return nil
+}
+
+func (l *logRing) recent() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.lines...)
+}
+
+// watchView is what the terminal view shows besides the daemon state: the
+// records of each name as looked up last
+type watchView struct {
+	mu       sync.Mutex
+	records  map[string][]net.IP
+	resolved time.Time
+}
+
+// runWatch runs the daemon with a live view of it in the terminal instead of
+// the log: the public IP, each name's records against what was sent, the
+// countdown to the next check and the latest log lines. u checks right away
+// and q quits.
+func runWatch(ctx context.Context, update Update, reload func() Update) {
+	in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(in) || !term.IsTerminal(out) {
+		fatal(exitConfig, nil, "watch needs a terminal, use the daemon command otherwise")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	logs := &logRing{size: watchLogLines}
+	logrus.AddHook(logs)
+	// the log still goes to a log file, but not over the view
+	logger := logrus.StandardLogger()
+	previous := logger.Out
+	if previous == os.Stderr || previous == os.Stdout {
+		logrus.SetOutput(ioutil.Discard)
+	}
+	raw, err := term.MakeRaw(in)
+	if err != nil {
+		fatal(exitError, err, "unable to set up the terminal")
+	}
+	var once sync.Once
+	restore := func() {
+		once.Do(func() {
+			term.Restore(in, raw)
+			// leave the alternate screen and show the cursor again
+			fmt.Fprint(os.Stdout, "\x1b[?25h\x1b[?1049l")
+			logrus.SetOutput(previous)
+		})
+	}
+	// a fatal error of the daemon ends the view too, so show why once the
+	// terminal is back to normal
+	var finished bool
+	logrus.RegisterExitHandler(func() {
+		if finished {
+			return
+		}
+		restore()
+		for _, l := range logs.recent() {
+			fmt.Fprintln(os.Stderr, l)
+		}
+	})
+	fmt.Fprint(os.Stdout, "\x1b[?1049h\x1b[?25l")
+
+	state := newDaemonState(update)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		daemonLoop(ctx, update, reload, state)
+	}()
+
+	view := &watchView{}
+	go view.resolve(ctx, state)
+	keys := make(chan byte)
+	go readKeys(keys)
+
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+draw:
+	for {
+		drawWatch(out, state, view, logs)
+		select {
+		case <-ctx.Done():
+			break draw
+		case <-done:
+			break draw
+		case <-tick.C:
+		case k := <-keys:
+			switch k {
+			case 'q', 'Q', 3, 4: // Ctrl-C and Ctrl-D as well, raw mode has no signals
+				cancel()
+			case 'u', 'U':
+				go func() {
+					t := updateTrigger{from: "the keyboard", done: make(chan triggerResult, 1)}
+					select {
+					case state.triggers <- t:
+					case <-ctx.Done():
+					}
+				}()
+			}
+		}
+	}
+	cancel()
+	<-done
+	finished = true
+	restore()
+}
+
+// readKeys sends every byte typed on stdin to keys
+func readKeys(keys chan<- byte) {
+	buf := make([]byte, 1)
+	for {
+		if n, err := os.Stdin.Read(buf); err != nil {
+			return
+		} else if n == 1 {
+			keys <- buf[0]
+		}
+	}
+}
+
+// resolve looks up the names of the configuration every watchResolveInterval,
+// and again soon after each run so that a change shows up quickly
+func (v *watchView) resolve(ctx context.Context, state *daemonState) {
+	var lastRun time.Time
+	for {
+		state.mu.Lock()
+		update := state.update
+		state.mu.Unlock()
+		resolver, err := newResolver(update)
+		if err != nil {
+			logrus.WithError(err).Debug("looking names up with the system resolver")
+			resolver = net.DefaultResolver
+		}
+		records := map[string][]net.IP{}
+		for _, a := range update.accounts() {
+			for _, n := range a.Names {
+				lookup, cancel := context.WithTimeout(ctx, 5*time.Second)
+				addrs, err := resolver.LookupIPAddr(lookup, fqdn(n))
+				cancel()
+				if err != nil {
+					logrus.WithError(err).Debugf("unable to resolve %s", n)
+				}
+				for _, addr := range addrs {
+					records[n] = append(records[n], addr.IP)
+				}
+			}
+		}
+		v.mu.Lock()
+		v.records, v.resolved = records, time.Now()
+		v.mu.Unlock()
+
+		deadline := time.Now().Add(watchResolveInterval)
+		for time.Now().Before(deadline) {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			state.mu.Lock()
+			run := state.LastRun
+			state.mu.Unlock()
+			if !run.Equal(lastRun) {
+				// give DuckDNS a moment to answer with the new record
+				lastRun = run
+				deadline = time.Now().Add(2 * time.Second)
+			}
+		}
+	}
+}
+
+// drawWatch redraws the whole screen
+func drawWatch(fd int, state *daemonState, view *watchView, logs *logRing) {
+	width, height, err := term.GetSize(fd)
+	if err != nil || width < 20 {
+		width, height = 80, 24
+	}
+	var b bytes.Buffer
+	line := func(format string, args ...interface{}) {
+		s := fmt.Sprintf(format, args...)
+		if len(s) > width {
+			s = s[:width]
+		}
+		// raw mode doesn't turn \n into \r\n
+		b.WriteString(s + "\x1b[K\r\n")
+	}
+
+	state.mu.Lock()
+	update := state.update
+	ip, lastRun, lastError, nextRun := state.IP, state.LastRun, state.LastError, state.NextRun
+	domains := map[string]domainState{}
+	for n, d := range state.Domains {
+		domains[n] = d
+	}
+	state.mu.Unlock()
+	view.mu.Lock()
+	records, resolved := view.records, view.resolved
+	view.mu.Unlock()
+
+	b.WriteString("\x1b[H")
+	line("duckdns %s - u updates now, q quits", version)
+	line("")
+	if ip == "" {
+		ip = "-"
+	}
+	line("Public IP   %s", ip)
+	switch {
+	case lastRun.IsZero():
+		line("Last check  running...")
+	case lastError != "":
+		line("Last check  %s, failed: %s", timeAgo(lastRun), lastError)
+	default:
+		line("Last check  %s", timeAgo(lastRun))
+	}
+	if !nextRun.IsZero() && lastRun.Before(nextRun) {
+		line("Next check  in %s", time.Until(nextRun).Round(time.Second))
+	} else {
+		line("Next check  -")
+	}
+	line("")
+	line("%-28s %-10s %-30s %s", "NAME", "RESULT", "RECORDS", "STATE")
+	shown := 6
+	for _, a := range update.accounts() {
+		for _, n := range a.Names {
+			d := domains[n]
+			result := d.Result
+			if result == "" {
+				result = "-"
+			}
+			recordsState := "-"
+			if sent := []net.IP{net.ParseIP(d.IP), net.ParseIP(d.IPv6)}; records != nil &&
+				(sent[0] != nil || sent[1] != nil) {
+				recordsState = recordState(records[n], sent[0], sent[1])
+			}
+			if d.Failures > 0 {
+				recordsState += fmt.Sprintf(", %d failure(s) in a row", d.Failures)
+			}
+			line("%-28s %-10s %-30s %s", n, result, joinIPs(records[n]), recordsState)
+			shown++
+		}
+	}
+	if !resolved.IsZero() {
+		line("records looked up %s", timeAgo(resolved))
+	} else {
+		line("looking up the records...")
+	}
+	line("")
+	shown += 2
+
+	recent := logs.recent()
+	if room := height - shown - 1; room < len(recent) {
+		if room < 0 {
+			room = 0
+		}
+		recent = recent[len(recent)-room:]
+	}
+	for _, l := range recent {
+		line("%s", l)
+	}
+	// clear whatever is left of the previous frame
+	b.WriteString("\x1b[J")
+	os.Stdout.Write(b.Bytes())
+}
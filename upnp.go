@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	ssdpAddr        = "239.255.255.250:1900"
+	ssdpWait        = 2 * time.Second
+	igdSearchTarget = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+)
+
+// wanServices are the IGD services able to report the external address
+var wanServices = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// upnpProvider asks the local router for its WAN address over UPnP IGD, which
+// never leaves the home network
+type upnpProvider struct {
+	// hc must not go through a proxy since the router is on the LAN
+	hc *http.Client
+}
+
+func (p *upnpProvider) Name() string { return "upnp" }
+
+func (p *upnpProvider) Detect(ctx context.Context, v6 bool) (net.IP, error) {
+	if v6 {
+		return nil, errors.New("UPnP IGD only reports the IPv4 address")
+	}
+
+	location, err := ssdpSearch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	control, service, err := p.findWANService(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	return p.externalIP(ctx, control, service)
+}
+
+// ssdpSearch multicasts an M-SEARCH for a gateway and returns the description
+// URL from the first reply
+func ssdpSearch(ctx context.Context) (string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	dst, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return "", err
+	}
+	msg := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddr + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: " + igdSearchTarget + "\r\n\r\n"
+	if _, err := conn.WriteTo([]byte(msg), dst); err != nil {
+		return "", err
+	}
+
+	deadline := time.Now().Add(ssdpWait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return "", fmt.Errorf("no UPnP gateway found: %v", err)
+		}
+		res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		res.Body.Close()
+		if loc := res.Header.Get("Location"); loc != "" {
+			return loc, nil
+		}
+	}
+}
+
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+// findWANService reads the device description and returns the absolute
+// control URL and type of the first WAN connection service
+func (p *upnpProvider) findWANService(ctx context.Context, location string) (string, string, error) {
+	req, err := http.NewRequest(http.MethodGet, location, nil)
+	if err != nil {
+		return "", "", err
+	}
+	res, err := p.hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", "", err
+	}
+	defer res.Body.Close()
+
+	var desc struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(res.Body).Decode(&desc); err != nil {
+		return "", "", fmt.Errorf("invalid UPnP device description: %v", err)
+	}
+
+	base, err := url.Parse(location)
+	if err != nil {
+		return "", "", err
+	}
+	if desc.URLBase != "" {
+		if b, err := url.Parse(desc.URLBase); err == nil {
+			base = b
+		}
+	}
+
+	devices := []upnpDevice{desc.Device}
+	for len(devices) > 0 {
+		d := devices[0]
+		devices = append(devices[1:], d.Devices...)
+		for _, s := range d.Services {
+			for _, want := range wanServices {
+				if s.ServiceType != want {
+					continue
+				}
+				ref, err := url.Parse(s.ControlURL)
+				if err != nil {
+					return "", "", err
+				}
+				return base.ResolveReference(ref).String(), s.ServiceType, nil
+			}
+		}
+	}
+	return "", "", errors.New("gateway has no WAN connection service")
+}
+
+// externalIP calls GetExternalIPAddress on the WAN connection service
+func (p *upnpProvider) externalIP(ctx context.Context, control, service string) (net.IP, error) {
+	body := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" ` +
+		`s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:GetExternalIPAddress xmlns:u="` + service + `"/></s:Body>` +
+		`</s:Envelope>`
+
+	req, err := http.NewRequest(http.MethodPost, control, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+service+`#GetExternalIPAddress"`)
+
+	res, err := p.hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GetExternalIPAddress returned %s", res.Status)
+	}
+
+	var env struct {
+		IP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	if err := xml.NewDecoder(res.Body).Decode(&env); err != nil {
+		return nil, fmt.Errorf("invalid GetExternalIPAddress response: %v", err)
+	}
+	ip := net.ParseIP(strings.TrimSpace(env.IP))
+	if ip == nil {
+		return nil, fmt.Errorf("gateway returned an invalid address %q", env.IP)
+	}
+	return ip, nil
+}
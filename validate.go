@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/theag3nt/duckdns/pkg/duckdns"
+)
+
+var (
+	// tokenPattern matches the UUID tokens handed out by DuckDNS
+	tokenPattern = regexp.MustCompile(
+		`(?i)^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+	// namePattern matches a single DNS label as accepted for a subdomain
+	namePattern = regexp.MustCompile(`(?i)^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+	// hostPattern matches the full host names other providers expect
+	hostPattern = regexp.MustCompile(
+		`(?i)^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+)
+
+// validate checks the merged configuration and returns every problem found
+// rather than stopping at the first one
+func (u *Update) validate() []error {
+	errs := append([]error(nil), u.configErrors...)
+
+	accounts := u.accounts()
+	if len(accounts) == 0 {
+		errs = append(errs, fmt.Errorf("no domains configured"))
+	}
+	for i, a := range accounts {
+		where := fmt.Sprintf("account %d", i+1)
+		duck := providerName(a) == "duckdns"
+		if _, err := newDNSProvider(a, &duckdns.Client{}); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", where, err))
+		}
+		if a.Token == "" {
+			errs = append(errs, fmt.Errorf("%s: token is not set", where))
+		} else if p := tokenProblem(a.Token); duck && p != "" {
+			errs = append(errs, fmt.Errorf("%s: token malformed (expected a UUID), %s", where, p))
+		}
+		if len(a.Names) == 0 {
+			errs = append(errs, fmt.Errorf("%s: no domains configured", where))
+		}
+		for _, n := range a.Names {
+			if !validName(a, n) {
+				errs = append(errs, fmt.Errorf("%s: invalid domain name %q", where, n))
+			}
+		}
+	}
+
+	if e, err := url.Parse(u.Endpoint); err != nil {
+		errs = append(errs, fmt.Errorf("invalid endpoint: %v", err))
+	} else if (e.Scheme != "http" && e.Scheme != "https") || e.Host == "" {
+		errs = append(errs, fmt.Errorf("endpoint %q is not an http(s) URL", u.Endpoint))
+	}
+	if _, err := proxyFunc(u.Proxy); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := u.TLS.build(endpointHost(u.Endpoint)); err != nil {
+		errs = append(errs, err)
+	}
+	if len(u.TLS.Pins) > 0 && !strings.HasPrefix(u.Endpoint, "https://") {
+		errs = append(errs, fmt.Errorf("tls pins need an https endpoint, not %q", u.Endpoint))
+	}
+	if u.ForceIPv4 && u.ForceIPv6 {
+		errs = append(errs, fmt.Errorf("force_ipv4 and force_ipv6 can't both be set"))
+	}
+	if _, err := newResolver(*u); err != nil {
+		errs = append(errs, err)
+	}
+	if err := bindDialer(&net.Dialer{}, u.SourceIP, u.BindInterface); err != nil {
+		errs = append(errs, err)
+	}
+	if ip := net.ParseIP(u.SourceIP); ip != nil {
+		if v4 := ip.To4() != nil; v4 && u.ForceIPv6 || !v4 && u.ForceIPv4 {
+			errs = append(errs, fmt.Errorf("source_ip %s is not of the forced address family", u.SourceIP))
+		}
+	}
+	for _, spec := range u.IPProviders {
+		if _, err := newIPProvider(spec, *u, nil); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if u.IPQuorum < 0 {
+		errs = append(errs, fmt.Errorf("ip quorum must not be negative"))
+	} else if u.IPQuorum > len(u.IPProviders) && u.Interface == "" {
+		errs = append(errs, fmt.Errorf("ip quorum %d needs at least as many ip providers, not %d",
+			u.IPQuorum, len(u.IPProviders)))
+	}
+	if _, err := newNotifiers(u.Notify, nil); err != nil {
+		errs = append(errs, err)
+	}
+	if b := u.MQTT.Broker; b != "" {
+		if m, err := url.Parse(b); err != nil || m.Host == "" {
+			errs = append(errs, fmt.Errorf("MQTT broker %q is not a URL", b))
+		}
+	}
+	for key, value := range u.Addresses {
+		if _, _, err := splitAddrs(value); err != nil {
+			errs = append(errs, fmt.Errorf("addresses: %s: %v", key, err))
+		} else if !u.hasName(key) {
+			errs = append(errs, fmt.Errorf("addresses: %s is not one of the configured domains", key))
+		}
+	}
+	if s := u.Metrics.Statsd; s != "" {
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			errs = append(errs, fmt.Errorf("statsd address %q is not host:port", s))
+		}
+	}
+	if i := u.Metrics.InfluxDB; i != "" {
+		if m, err := url.Parse(i); err != nil || m.Host == "" ||
+			(m.Scheme != "http" && m.Scheme != "https" && m.Scheme != "udp") {
+			errs = append(errs, fmt.Errorf("InfluxDB URL %q is not an http(s) or udp URL", i))
+		}
+	}
+	if o := u.OTLPEndpoint; o != "" {
+		if _, err := otlpEndpointURL(o); err != nil {
+			errs = append(errs, fmt.Errorf("otlp endpoint %q is not an http(s) URL", o))
+		}
+	}
+	if u.Hooks.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("hooks timeout must not be negative"))
+	}
+	if (u.Cert.CertFile == "") != (u.Cert.KeyFile == "") {
+		errs = append(errs, fmt.Errorf("cert needs both cert_file and key_file"))
+	}
+	if u.Cert.RenewBefore < 0 {
+		errs = append(errs, fmt.Errorf("cert renew_before must not be negative"))
+	}
+	if _, err := newSchedule(*u); err != nil {
+		errs = append(errs, err)
+	}
+	if u.ConnectTimeout < 0 {
+		errs = append(errs, fmt.Errorf("connect timeout must not be negative"))
+	}
+	if u.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("timeout must not be negative"))
+	}
+	if u.Interval < 0 {
+		errs = append(errs, fmt.Errorf("interval must not be negative"))
+	}
+	if u.Jitter < 0 || u.StartupDelay < 0 {
+		errs = append(errs, fmt.Errorf("jitter and startup delay must not be negative"))
+	}
+	if u.RefreshInterval < 0 {
+		errs = append(errs, fmt.Errorf("refresh interval must not be negative"))
+	}
+	if u.RetryAttempts < 1 {
+		errs = append(errs, fmt.Errorf("retry attempts must be at least 1"))
+	}
+	if u.RetryDelay < 0 {
+		errs = append(errs, fmt.Errorf("retry delay must not be negative"))
+	}
+	if u.OfflineRetry < 0 {
+		errs = append(errs, fmt.Errorf("offline retry must not be negative"))
+	}
+	if u.RetryFailedAfter < 0 {
+		errs = append(errs, fmt.Errorf("retry failed after must not be negative"))
+	}
+	if u.Concurrency < 1 {
+		errs = append(errs, fmt.Errorf("concurrency must be at least 1"))
+	}
+	if u.RateLimit < 0 {
+		errs = append(errs, fmt.Errorf("rate limit must not be negative"))
+	}
+
+	return errs
+}
+
+// configProblems is every reason check found for not running at all
+type configProblems []error
+
+func (p configProblems) Error() string {
+	if len(p) == 1 {
+		return p[0].Error()
+	}
+	lines := []string{fmt.Sprintf("%d configuration problems:", len(p))}
+	for _, err := range p {
+		lines = append(lines, "  - "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// check returns everything that keeps the update from running, so that it can
+// all be fixed in one go: problems reading the config file and its profile,
+// token and domains files, missing tokens or domains, and malformed ones. It
+// is nil when the update can run; validate goes further for the validate
+// command.
+func (u *Update) check() error {
+	problems := configProblems(append([]error(nil), u.configErrors...))
+	accounts := u.accounts()
+	if len(accounts) == 0 {
+		problems = append(problems, fmt.Errorf("no domains configured, set --names, DUCK_NAMES or domains"))
+	}
+	for i, a := range accounts {
+		if a.Token == "" {
+			problems = append(problems, fmt.Errorf("account %d: no token, set --token, DUCK_TOKEN or token", i+1))
+		}
+		if len(a.Names) == 0 {
+			problems = append(problems, fmt.Errorf("account %d: no domains", i+1))
+		}
+	}
+	if err := u.checkTokens(); err != nil {
+		problems = append(problems, err)
+	}
+	if err := u.checkNames(); err != nil {
+		problems = append(problems, err)
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return problems
+}
+
+// runValidate reports every problem with the configuration and exits non-zero
+// if there were any
+func runValidate(update Update) {
+	errs := update.validate()
+	for _, err := range errs {
+		logrus.Error(err)
+	}
+	if len(errs) != 0 {
+		fatal(exitConfig, nil, "configuration has %d problem(s)", len(errs))
+	}
+	logrus.Info("configuration is valid")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/theag3nt/duckdns/pkg/duckdns"
+)
+
+// verifyResult polls DNS until the name reflects what DuckDNS reported for the
+// update, or the timeout passes
+func verifyResult(ctx context.Context, r *net.Resolver, res duckdns.Result,
+	timeout, interval time.Duration) error {
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	host := fqdn(res.Domain)
+	for {
+		err := checkRecord(ctx, r, host, res)
+		if err == nil {
+			return nil
+		}
+		logrus.WithError(err).Debugf("%s has not propagated yet", host)
+
+		t := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+func checkRecord(ctx context.Context, r *net.Resolver, host string,
+	res duckdns.Result) error {
+
+	if res.TXT != "" {
+		txts, err := r.LookupTXT(ctx, host)
+		if err != nil {
+			return err
+		}
+		for _, t := range txts {
+			if t == res.TXT {
+				return nil
+			}
+		}
+		return fmt.Errorf("TXT record of %s is %q, expected %q", host, txts, res.TXT)
+	}
+
+	addrs, err := r.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, want := range []string{res.IP, res.IPv6} {
+		if want == "" {
+			continue
+		}
+		found := false
+		for _, a := range addrs {
+			if a.IP.Equal(net.ParseIP(want)) {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s resolves to %v, expected %s", host, addrs, want)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata, set by build-release with
+// -ldflags "-X main.version=... -X main.commit=... -X main.date=..."
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+// buildInfo fills in the commit and date from the VCS stamp of `go build`
+// when the linker flags didn't set them
+func buildInfo() (string, string) {
+	c, d := commit, date
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && c == "":
+				c = s.Value
+			case s.Key == "vcs.time" && d == "":
+				d = s.Value
+			}
+		}
+	}
+	if c == "" {
+		c = "unknown"
+	}
+	if d == "" {
+		d = "unknown"
+	}
+	return c, d
+}
+
+func printVersion() {
+	c, d := buildInfo()
+	fmt.Printf("duckdns %s\ncommit: %s\nbuilt: %s\ngo: %s %s/%s\n",
+		version, c, d, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+// userAgent identifies this client to DuckDNS
+func userAgent() string {
+	return "duckdns/" + version + " (+https://github.com/theag3nt/duckdns)"
+}
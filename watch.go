@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// watchInterval is how often watched files are checked when no file event
+// arrives, which is all there is on network filesystems
+const watchInterval = 10 * time.Second
+
+// watchSettle is the wait after a file event before checking the files, so
+// that an editor or the kubelet can finish writing
+const watchSettle = 500 * time.Millisecond
+
+// sourceFiles lists the files the configuration was read from
+func (u *Update) sourceFiles() []string {
+	var files []string
+	for _, f := range []string{u.configFile, u.TokenFile, u.NamesFile} {
+		if f != "" && f != "-" && !isRemoteConfig(f) {
+			files = append(files, f)
+		}
+	}
+	for _, a := range u.Accounts {
+		if a.TokenFile != "" {
+			files = append(files, a.TokenFile)
+		}
+	}
+	return files
+}
+
+// watchFiles signals on the returned channel whenever one of files changes,
+// until ctx is done. File events only trigger a check and contents are
+// compared, because Kubernetes swaps in a new ConfigMap or Secret by replacing
+// a symlink to the directory holding them, and editors replace files rather
+// than writing to them.
+func watchFiles(ctx context.Context, files []string, interval time.Duration) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+	sums := make([][]byte, len(files))
+	for i, f := range files {
+		sums[i] = fileSum(f)
+	}
+	events := watchEvents(ctx, files)
+	logrus.Debugf("Watching %v for changes", files)
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			case <-events:
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(watchSettle):
+				}
+				// drop the events of the same write
+				select {
+				case <-events:
+				default:
+				}
+			}
+			// a ConfigMap update often changes several files at once,
+			// which should only cause one reload
+			dirty := false
+			for i, f := range files {
+				sum := fileSum(f)
+				if bytes.Equal(sum, sums[i]) {
+					continue
+				}
+				logrus.Infof("%s changed", f)
+				sums[i] = sum
+				dirty = true
+			}
+			if dirty {
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return changed
+}
+
+// watchEvents signals when anything happens in the directories of files. It
+// returns nil when file events aren't available, leaving the polling.
+func watchEvents(ctx context.Context, files []string) <-chan struct{} {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		logrus.WithError(err).Warnf("unable to watch for file events, checking every %s", watchInterval)
+		return nil
+	}
+	dirs := map[string]bool{}
+	for _, f := range files {
+		dir := filepath.Dir(f)
+		if dirs[dir] {
+			continue
+		}
+		dirs[dir] = true
+		if err := w.Add(dir); err != nil {
+			logrus.WithError(err).Warnf("unable to watch %s, checking it every %s", dir, watchInterval)
+		}
+	}
+
+	events := make(chan struct{}, 1)
+	go func() {
+		defer w.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				select {
+				case events <- struct{}{}:
+				default:
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				logrus.WithError(err).Debug("file watcher error")
+			}
+		}
+	}()
+	return events
+}
+
+// fileSum hashes the contents of path, nil if it can't be read
+func fileSum(path string) []byte {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	return sum[:]
+}